@@ -0,0 +1,279 @@
+// Package agent periodically captures goroutine dumps from inside the running
+// process, for services that can't expose net/http/pprof.
+//
+// Dumps are written in gscrape's layout (<dir>/<host>/<timestamp>.goroutines.txt.gz)
+// and/or pushed to a gscrape receiver (gscrape -receive-addr), so gindex and
+// gweb work on them unchanged.
+//
+//	a, err := agent.New(agent.Options{
+//		Host:          "billing-worker",
+//		PushURL:       "http://gscrape:6070/push",
+//		Token:         os.Getenv("GSCRAPE_TOKEN"),
+//		MaxGoroutines: 50000,
+//	})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	go a.Run(ctx)
+package agent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"time"
+
+	"gscrape/snapshot"
+)
+
+// ErrSkipped is returned by Dump when an overhead limit prevented the dump
+var ErrSkipped = errors.New("agent: dump skipped")
+
+// Options configures an Agent. At least one of Dir and PushURL must be set.
+type Options struct {
+	// Interval between dumps (default 15s)
+	Interval time.Duration
+
+	// Host is the host/app name used as directory name and push tag
+	// (default: os.Hostname)
+	Host string
+
+	// Dir is the output directory; dumps go to Dir/<Host>/
+	Dir string
+
+	// PushURL is a gscrape receiver push endpoint, e.g. http://gscrape:6070/push
+	PushURL string
+	// Token is the bearer token for PushURL
+	Token string
+	// Client is used for pushing (default: http.Client with 30s timeout)
+	Client *http.Client
+
+	// MaxGoroutines skips the dump when more goroutines are running; the
+	// dump stops the world for a time proportional to this count (0 = no limit)
+	MaxGoroutines int
+	// MaxDumpBytes skips the dump when the previous dump's size per goroutine
+	// times the running goroutines exceeds it, and drops dumps that turn out
+	// larger anyway (0 = no limit). The first dump can't be estimated and
+	// is only dropped.
+	MaxDumpBytes int
+	// MaxOverhead is the largest fraction of wall time that may be spent
+	// dumping, compressing and shipping; dumps are skipped to stay under it (0 = no limit)
+	MaxOverhead float64
+
+	// Logf receives progress and error messages (default: log.Printf)
+	Logf func(format string, args ...interface{})
+}
+
+// Agent captures goroutine dumps on a schedule
+type Agent struct {
+	opts Options
+
+	mu        sync.Mutex
+	lastCost  time.Duration // time spent on the last dump
+	lastStart time.Time
+	perGoro   float64 // dump text bytes per goroutine in the last dump
+}
+
+// New validates opts and returns an Agent
+func New(opts Options) (*Agent, error) {
+	if opts.Dir == "" && opts.PushURL == "" {
+		return nil, errors.New("agent: Dir or PushURL required")
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 15 * time.Second
+	}
+	if opts.Host == "" {
+		h, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("agent: hostname: %w", err)
+		}
+		opts.Host = h
+	}
+	if strings.ContainsAny(opts.Host, `/\`) || opts.Host == "." || opts.Host == ".." {
+		return nil, fmt.Errorf("agent: invalid host %q", opts.Host)
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+	if opts.Logf == nil {
+		opts.Logf = log.Printf
+	}
+	return &Agent{opts: opts}, nil
+}
+
+// Run dumps every Interval until ctx is cancelled
+func (a *Agent) Run(ctx context.Context) error {
+	ticker := time.NewTicker(a.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := a.Dump(ctx); err != nil && !errors.Is(err, ErrSkipped) {
+			a.opts.Logf("[gscrape-agent] ERROR: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dump captures one goroutine dump and writes and/or pushes it
+func (a *Agent) Dump(ctx context.Context) error {
+	if reason := a.skipReason(); reason != "" {
+		a.opts.Logf("[gscrape-agent] skipping dump: %s", reason)
+		return ErrSkipped
+	}
+
+	start := time.Now()
+	defer func() {
+		a.mu.Lock()
+		a.lastStart = start
+		a.lastCost = time.Since(start)
+		a.mu.Unlock()
+	}()
+
+	goroutines := runtime.NumGoroutine()
+	var raw bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&raw, 2); err != nil {
+		return fmt.Errorf("goroutine dump: %w", err)
+	}
+	a.mu.Lock()
+	a.perGoro = float64(raw.Len()) / float64(goroutines)
+	a.mu.Unlock()
+	if a.opts.MaxDumpBytes > 0 && raw.Len() > a.opts.MaxDumpBytes {
+		a.opts.Logf("[gscrape-agent] dropping dump: %d bytes exceeds limit of %d", raw.Len(), a.opts.MaxDumpBytes)
+		return ErrSkipped
+	}
+
+	var compressed bytes.Buffer
+	gw, err := gzip.NewWriterLevel(&compressed, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	if _, err := gw.Write(raw.Bytes()); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+
+	var errs []error
+	if a.opts.Dir != "" {
+		if err := a.write(start, compressed.Bytes()); err != nil {
+			errs = append(errs, fmt.Errorf("write: %w", err))
+		}
+	}
+	if a.opts.PushURL != "" {
+		if err := a.push(ctx, start, compressed.Bytes()); err != nil {
+			errs = append(errs, fmt.Errorf("push: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// skipReason checks the overhead limits before dumping
+func (a *Agent) skipReason() string {
+	if a.opts.MaxGoroutines > 0 {
+		if n := runtime.NumGoroutine(); n > a.opts.MaxGoroutines {
+			return fmt.Sprintf("%d goroutines above limit of %d", n, a.opts.MaxGoroutines)
+		}
+	}
+
+	// The dump stops the world for as long as it takes to write, so an
+	// oversized one is skipped before it is taken
+	if a.opts.MaxDumpBytes > 0 {
+		a.mu.Lock()
+		perGoro := a.perGoro
+		a.mu.Unlock()
+		n := runtime.NumGoroutine()
+		if estimate := int(perGoro * float64(n)); estimate > a.opts.MaxDumpBytes {
+			return fmt.Sprintf("dump of %d goroutines estimated at %d bytes, above limit of %d", n, estimate, a.opts.MaxDumpBytes)
+		}
+	}
+
+	if a.opts.MaxOverhead > 0 {
+		a.mu.Lock()
+		lastCost, lastStart := a.lastCost, a.lastStart
+		a.mu.Unlock()
+		// Space dumps so that cost / spacing stays under MaxOverhead
+		minSpacing := time.Duration(float64(lastCost) / a.opts.MaxOverhead)
+		if !lastStart.IsZero() && time.Since(lastStart) < minSpacing {
+			return fmt.Sprintf("last dump took %s, overhead limit %.1f%%", lastCost.Round(time.Millisecond), a.opts.MaxOverhead*100)
+		}
+	}
+
+	return ""
+}
+
+// write stores the compressed dump as Dir/<Host>/<timestamp>.goroutines.txt.gz
+// with a <timestamp>.meta.json sidecar holding the precise time, and lists it
+// in the host's manifest like gscrape does (see snapshot.Writer), so gindex
+// never reads half a dump.
+func (a *Agent) write(ts time.Time, compressed []byte) error {
+	outPath := filepath.Join(a.opts.Dir, strings.ReplaceAll(a.opts.Host, ":", "_"))
+	if err := os.MkdirAll(outPath, 0755); err != nil {
+		return err
+	}
+
+	snap, err := snapshot.Reserve(outPath, ts.UTC())
+	if err != nil {
+		return err
+	}
+	if err := snap.WriteFile(".goroutines.txt.gz", compressed); err != nil {
+		snap.Abort()
+		return err
+	}
+	if err := snap.WriteMeta(&snapshot.Meta{Time: snap.Time().UTC()}); err != nil {
+		snap.Abort()
+		return err
+	}
+	return snap.Commit()
+}
+
+// push sends the compressed dump to a gscrape receiver
+func (a *Agent) push(ctx context.Context, ts time.Time, compressed []byte) error {
+	u, err := url.Parse(a.opts.PushURL)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("host", a.opts.Host)
+	q.Set("t", ts.UTC().Format(time.RFC3339Nano))
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(compressed))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Content-Type", "text/plain")
+	if a.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.opts.Token)
+	}
+
+	resp, err := a.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status code: %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
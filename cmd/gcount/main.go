@@ -2,18 +2,19 @@ package main
 
 import (
 	"bufio"
-	"compress/gzip"
 	"flag"
 	"fmt"
-	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
-	"regexp"
 	"runtime"
 	"sort"
 	"strings"
 	"sync"
+
+	"gscrape/snapshot"
+	"gscrape/traceback"
 )
 
 func main() {
@@ -24,14 +25,50 @@ func main() {
 	)
 	flag.Parse()
 
-	// Find all .goroutines.txt.gz files
-	var files []string
-	err := filepath.Walk(*inputDir, func(path string, info os.FileInfo, err error) error {
+	// Find all .goroutines.txt.gz files and deltas (.goroutines.delta.gz),
+	// or their zstd versions, loose or rolled up into rollups/*.tar. Each
+	// directory is opened once, so its archive indexes and zstd dictionaries
+	// are loaded once. A delta needs the dump before it, so each full dump
+	// and the deltas following it are processed in order by one worker.
+	var chains [][]workItem
+	files := 0
+	err := filepath.WalkDir(*inputDir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if !info.IsDir() && strings.HasSuffix(path, ".goroutines.txt.gz") {
-			files = append(files, path)
+		if !d.IsDir() {
+			return nil
+		}
+		if name := d.Name(); path != *inputDir && (name == snapshot.RollupDir || name == snapshot.DictDir) {
+			return filepath.SkipDir
+		}
+		dir, err := snapshot.OpenDir(path)
+		if err != nil {
+			log.Printf("Failed to read archives of %s: %v", path, err)
+		}
+		var names []string
+		for _, suffix := range snapshot.DumpSuffixes {
+			matches, err := dir.Glob("*" + suffix)
+			if err != nil {
+				return err
+			}
+			names = append(names, matches...)
+		}
+		sort.Strings(names) // Sort by timestamp
+
+		newChain := true
+		for _, name := range names {
+			item, err := newWorkItem(*inputDir, *outputDir, dir, filepath.Join(path, name))
+			if err != nil {
+				log.Printf("Failed to get relative path for %s: %v", name, err)
+				continue
+			}
+			if newChain || !snapshot.IsDelta(name) {
+				chains = append(chains, nil)
+			}
+			chains[len(chains)-1] = append(chains[len(chains)-1], item)
+			files++
+			newChain = false
 		}
 		return nil
 	})
@@ -39,15 +76,15 @@ func main() {
 		log.Fatalf("Failed to walk input directory: %v", err)
 	}
 
-	if len(files) == 0 {
-		log.Println("No .goroutines.txt.gz files found")
+	if files == 0 {
+		log.Println("No .goroutines.txt.gz, .goroutines.delta.gz or .zst files found")
 		return
 	}
 
-	log.Printf("Found %d files to process with %d workers", len(files), *workers)
+	log.Printf("Found %d files to process with %d workers", files, *workers)
 
 	// Create work channel and start workers
-	workCh := make(chan workItem, len(files))
+	workCh := make(chan []workItem, len(chains))
 	var wg sync.WaitGroup
 
 	for i := 0; i < *workers; i++ {
@@ -59,21 +96,8 @@ func main() {
 	}
 
 	// Queue work
-	for _, f := range files {
-		// Extract host directory and filename
-		// Input:  output/<host>/<timestamp>.goroutines.txt.gz
-		// Output: goro-counts/<host>/<timestamp>.goroutines.txt
-		rel, err := filepath.Rel(*inputDir, f)
-		if err != nil {
-			log.Printf("Failed to get relative path for %s: %v", f, err)
-			continue
-		}
-
-		outPath := filepath.Join(*outputDir, strings.TrimSuffix(rel, ".gz"))
-		workCh <- workItem{
-			inputPath:  f,
-			outputPath: outPath,
-		}
+	for _, chain := range chains {
+		workCh <- chain
 	}
 	close(workCh)
 
@@ -82,55 +106,76 @@ func main() {
 }
 
 type workItem struct {
+	dir        *snapshot.Dir
 	inputPath  string
 	outputPath string
 }
 
-func worker(ch <-chan workItem) {
-	for item := range ch {
-		if err := processFile(item.inputPath, item.outputPath); err != nil {
-			log.Printf("[%s] ERROR: %v", item.inputPath, err)
-		} else {
-			log.Printf("[%s] -> %s", item.inputPath, item.outputPath)
+// newWorkItem maps a dump file to the file its counts are written to:
+//
+//	Input:  output/<host>/<timestamp>.goroutines.txt.gz (or .goroutines.delta.gz, .zst)
+//	Output: goro-counts/<host>/<timestamp>.goroutines.txt
+func newWorkItem(inputDir, outputDir string, dir *snapshot.Dir, path string) (workItem, error) {
+	rel, err := filepath.Rel(inputDir, path)
+	if err != nil {
+		return workItem{}, err
+	}
+	for _, suffix := range snapshot.DumpSuffixes {
+		if prefix, ok := strings.CutSuffix(rel, suffix); ok {
+			rel = prefix + ".goroutines.txt"
+			break
 		}
 	}
+	return workItem{dir: dir, inputPath: path, outputPath: filepath.Join(outputDir, rel)}, nil
 }
 
-func processFile(inputPath, outputPath string) error {
-	// Read and decompress input
-	f, err := os.Open(inputPath)
-	if err != nil {
-		return fmt.Errorf("open: %w", err)
-	}
-	defer f.Close()
-
-	gr, err := gzip.NewReader(f)
-	if err != nil {
-		return fmt.Errorf("gzip reader: %w", err)
+// worker processes chains of dumps, each in order, so every delta is decoded
+// against the dump before it
+func worker(ch <-chan []workItem) {
+	for chain := range ch {
+		var prevName string
+		var prevDump []byte
+		for _, item := range chain {
+			dump, err := processFile(item.dir, item.inputPath, item.outputPath, prevName, prevDump)
+			prevName, prevDump = snapshot.Prefix(item.inputPath), dump
+			if err != nil {
+				log.Printf("[%s] ERROR: %v", item.inputPath, err)
+			} else {
+				log.Printf("[%s] -> %s", item.inputPath, item.outputPath)
+			}
+		}
 	}
-	defer gr.Close()
+}
 
-	data, err := io.ReadAll(gr)
+// processFile groups one dump into outputPath and returns the dump. A delta
+// against prevName is decoded against prevDump.
+func processFile(dir *snapshot.Dir, inputPath, outputPath, prevName string, prevDump []byte) ([]byte, error) {
+	// Read and decompress input, rebuilding deltas from their base
+	data, err := dir.ReadDumpAfter(filepath.Base(inputPath), prevName, prevDump)
 	if err != nil {
-		return fmt.Errorf("read: %w", err)
+		return nil, err
 	}
 
-	// Parse and group goroutines
-	grouped := parseAndGroup(string(data))
+	// Snapshots scraped with debug=1 (cost fallback) are already grouped
+	output := string(data)
+	if !strings.HasPrefix(output, "goroutine profile:") {
+		// Parse and group goroutines
+		grouped := parseAndGroup(output)
 
-	// Format output like debug=1
-	output := formatDebug1(grouped)
+		// Format output like debug=1
+		output = formatDebug1(grouped)
+	}
 
 	// Write output
 	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
-		return fmt.Errorf("mkdir: %w", err)
+		return data, fmt.Errorf("mkdir: %w", err)
 	}
 
 	if err := os.WriteFile(outputPath, []byte(output), 0644); err != nil {
-		return fmt.Errorf("write: %w", err)
+		return data, fmt.Errorf("write: %w", err)
 	}
 
-	return nil
+	return data, nil
 }
 
 // goroutineGroup represents a group of goroutines with the same stack trace
@@ -144,110 +189,21 @@ type goroutineGroup struct {
 // parseAndGroup parses debug=2 output and groups goroutines by stack trace
 func parseAndGroup(data string) map[string]*goroutineGroup {
 	groups := make(map[string]*goroutineGroup)
-
-	// Split into individual goroutine blocks
-	// Each goroutine starts with "goroutine N [state]:" or "goroutine N [state, M minutes]:"
-	goroutineHeaderRe := regexp.MustCompile(`(?m)^goroutine \d+ \[([^\]]+)\]:`)
-
-	// Find all goroutine blocks
-	matches := goroutineHeaderRe.FindAllStringIndex(data, -1)
-	if len(matches) == 0 {
-		return groups
-	}
-
-	for i, match := range matches {
-		start := match[0]
-		end := len(data)
-		if i+1 < len(matches) {
-			end = matches[i+1][0]
+	for _, g := range traceback.Parse(data) {
+		stack := g.Stack()
+		key := g.State + "\n" + stack
+
+		group, ok := groups[key]
+		if !ok {
+			group = &goroutineGroup{state: g.State, stack: stack, waits: []int{}}
+			groups[key] = group
 		}
-
-		block := strings.TrimSpace(data[start:end])
-		parseGoroutineBlock(block, groups)
-	}
-
-	return groups
-}
-
-// parseGoroutineBlock parses a single goroutine block and adds it to the groups
-func parseGoroutineBlock(block string, groups map[string]*goroutineGroup) {
-	lines := strings.Split(block, "\n")
-	if len(lines) < 1 {
-		return
-	}
-
-	// Parse header: "goroutine N [state]:" or "goroutine N [state, M minutes]:"
-	headerRe := regexp.MustCompile(`^goroutine \d+ \[([^\],]+)(?:,\s*(\d+)\s*minutes?)?\]:`)
-	match := headerRe.FindStringSubmatch(lines[0])
-	if match == nil {
-		return
-	}
-
-	state := match[1]
-	waitMinutes := 0
-	if match[2] != "" {
-		fmt.Sscanf(match[2], "%d", &waitMinutes)
-	}
-
-	// Extract stack trace (skip header line)
-	// For grouping, we use the stack trace without addresses
-	var stackLines []string
-	for _, line := range lines[1:] {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+		group.count++
+		if g.WaitMinutes > 0 {
+			group.waits = append(group.waits, g.WaitMinutes)
 		}
-		// Remove addresses like "0x12345" and "+0x123" for grouping
-		// Keep function names and file:line info
-		cleaned := cleanStackLine(line)
-		stackLines = append(stackLines, cleaned)
 	}
-
-	stack := strings.Join(stackLines, "\n")
-	key := state + "\n" + stack
-
-	if g, ok := groups[key]; ok {
-		g.count++
-		if waitMinutes > 0 {
-			g.waits = append(g.waits, waitMinutes)
-		}
-	} else {
-		waits := []int{}
-		if waitMinutes > 0 {
-			waits = append(waits, waitMinutes)
-		}
-		groups[key] = &goroutineGroup{
-			count: 1,
-			state: state,
-			stack: stack,
-			waits: waits,
-		}
-	}
-}
-
-// Precompile regexes for performance
-var (
-	// Match offset at end of line like +0x123
-	offsetRe = regexp.MustCompile(`\+0x[0-9a-fA-F]+\s*$`)
-	// Match "created by ... in goroutine N" - normalize goroutine number
-	createdByRe = regexp.MustCompile(`(created by .+) in goroutine \d+`)
-	// Match hex pointer values like 0x12345 or 0x12345? (with optional ?)
-	hexPtrRe = regexp.MustCompile(`0x[0-9a-fA-F]+\??`)
-)
-
-// cleanStackLine removes memory addresses for grouping purposes
-func cleanStackLine(line string) string {
-	// Remove offset at end of file:line like +0x123
-	line = offsetRe.ReplaceAllString(line, "")
-
-	// Normalize "created by ... in goroutine N" to remove goroutine number
-	line = createdByRe.ReplaceAllString(line, "$1")
-
-	// Replace all hex pointer values with "..."
-	// This normalizes (0xc00123, 0x456) -> (..., ...) and {0xc00123, 0x1} -> {..., ...}
-	line = hexPtrRe.ReplaceAllString(line, "...")
-
-	return line
+	return groups
 }
 
 // formatDebug1 formats the grouped goroutines like pprof debug=1 output
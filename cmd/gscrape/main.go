@@ -1,33 +1,92 @@
 package main
 
 import (
-	"compress/gzip"
 	"context"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
+
+	"gscrape/scraper"
 )
 
 func main() {
+	// "gscrape compact" rolls up completed hours once and exits
+	if len(os.Args) > 1 && os.Args[1] == "compact" {
+		runCompact(os.Args[2:])
+		return
+	}
+	// "gscrape serve" also indexes the output and serves the web UI, see serve.go
+	var sf *serveFlags
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		sf = registerServeFlags()
+	}
+
 	var (
 		interval = flag.Duration("interval", 15*time.Second, "Scrape interval")
 		outDir   = flag.String("output", "output", "Output directory")
 		timeout  = flag.Duration("timeout", 30*time.Second, "HTTP request timeout")
+		config   = flag.String("config", "", "JSON file with per-target settings")
+		profiles = flag.String("profiles", "", "Extra pprof profiles to fetch with every dump (goroutine,heap,mutex,block,threadcreate,allocs)")
+
+		runtimeMetrics = flag.String("runtime-metrics", "", "Runtime metrics to fetch with every dump: expvar (/debug/vars) or prometheus (/metrics) (none if empty)")
+
+		jitter        = flag.Float64("jitter", 0.1, "Randomly shift each scrape by up to this fraction of the interval")
+		maxConcurrent = flag.Int("max-concurrent", 32, "Maximum scrapes in flight across all targets (0 = no limit)")
+
+		maxDumpMB      = flag.Float64("max-dump-mb", 0, "Default ceiling on the estimated debug=2 dump size in MB before falling back to debug=1 (0 = no limit)")
+		maxDumpLatency = flag.Duration("max-dump-latency", 0, "Default ceiling on the estimated debug=2 dump time before falling back to debug=1 (0 = no limit)")
+		keyframeEvery  = flag.Int("keyframe-every", 0, "Default number of snapshots per full keyframe; the others are stored as deltas (0 or 1 = all full)")
+		maxResponseMB  = flag.Float64("max-response-mb", 256, "Default maximum goroutine dump response in MB; larger ones are truncated and stored as partial (0 = no limit)")
+
+		redact = flag.String("redact", "", "Default redaction profile from the -config file for all targets and pushed dumps (none if empty)")
+
+		compression = flag.String("compression", "gzip", "Dump compression: gzip, or zstd with a per-host trained dictionary")
+		dictKB      = flag.Int("zstd-dict-kb", 112, "Size of the trained zstd dictionaries in KB")
+		dictRetrain = flag.Duration("zstd-retrain", 24*time.Hour, "How often zstd dictionaries are retrained")
+
+		receiveAddr   = flag.String("receive-addr", "", "Listen address for pushed dumps (disabled if empty)")
+		receiveTokens = flag.String("receive-tokens", "", "File with \"<sender> <token>\" lines allowed to push dumps")
+		receiveRate   = flag.Float64("receive-rate", 12, "Pushes per minute allowed per sender")
+		receiveBurst  = flag.Int("receive-burst", 5, "Push burst allowed per sender")
+		receiveMax    = flag.Int64("receive-max-bytes", 256<<20, "Maximum size of a pushed dump (uncompressed)")
+
+		retainMaxAge   = flag.Duration("retain-max-age", 0, "Delete snapshots older than this (0 = keep forever)")
+		retainHostMB   = flag.Int64("retain-host-mb", 0, "Maximum MB kept per host, oldest snapshots deleted first (0 = no limit)")
+		retainTotalMB  = flag.Int64("retain-total-mb", 0, "Maximum MB kept across all hosts, oldest snapshots deleted first (0 = no limit)")
+		thinAfter      = flag.Duration("thin-after", 0, "Thin snapshots older than this (0 = no thinning)")
+		thinKeep       = flag.Int("thin-keep", 10, "When thinning, keep 1 in N snapshots")
+		retainInterval = flag.Duration("retain-interval", 5*time.Minute, "How often retention is enforced")
+		compactAfter   = flag.Duration("compact-after", 0, "Roll up hours that ended this long ago into one archive per host (0 = never)")
+
+		metricsAddr   = flag.String("metrics-addr", "", "Listen address for Prometheus /metrics (disabled if empty)")
+		controlAddr   = flag.String("control-addr", "", "Listen address for the control API, e.g. 127.0.0.1:6071 (disabled if empty)")
+		controlTokens = flag.String("control-tokens", "", "File with \"<client> <token>\" lines allowed to use the control API (required unless -control-addr is loopback)")
 	)
 	flag.Parse()
 
-	endpoints := flag.Args()
-	if len(endpoints) == 0 {
+	cfg, err := scraper.LoadConfig(*config, flag.Args(), splitList(*profiles), *runtimeMetrics)
+	if err != nil {
+		log.Fatalf("Failed to load targets: %v", err)
+	}
+	targets := cfg.Targets
+	if *redact != "" && cfg.Redaction[*redact] == nil {
+		log.Fatalf("-redact: unknown redaction profile %q", *redact)
+	}
+	if *jitter < 0 || *jitter >= 1 {
+		log.Fatal("-jitter must be between 0 and 1")
+	}
+	if *compression != "gzip" && *compression != "zstd" {
+		log.Fatal("-compression must be gzip or zstd")
+	}
+	if len(targets) == 0 && *receiveAddr == "" && *controlAddr == "" {
 		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <endpoint1> <endpoint2> ...\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Example: %s http://10.2.4.19:12300 http://10.2.4.20:12300\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "\nFlags:\n")
@@ -47,242 +106,122 @@ func main() {
 		cancel()
 	}()
 
-	scraper := &Scraper{
-		client: &http.Client{
+	s := scraper.New(scraper.Options{
+		Client: &http.Client{
 			Timeout: *timeout,
 		},
-		outDir:   *outDir,
-		interval: *interval,
-		stats:    make(map[string]*HostStats),
-	}
-
-	log.Printf("Starting scraper with %d endpoints, interval=%s, output=%s", len(endpoints), *interval, *outDir)
-
-	// Initial scrape
-	scraper.scrapeAll(ctx, endpoints)
-
-	ticker := time.NewTicker(*interval)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			log.Println("Scraper stopped")
-			return
-		case <-ticker.C:
-			scraper.scrapeAll(ctx, endpoints)
+		OutDir:        *outDir,
+		Interval:      *interval,
+		Jitter:        *jitter,
+		MaxConcurrent: *maxConcurrent,
+
+		MaxDumpMB:      *maxDumpMB,
+		MaxDumpLatency: *maxDumpLatency,
+		KeyframeEvery:  *keyframeEvery,
+		MaxResponseMB:  *maxResponseMB,
+
+		Zstd:        *compression == "zstd",
+		DictSize:    *dictKB << 10,
+		DictRetrain: *dictRetrain,
+
+		Redaction:     cfg.Redaction,
+		DefaultRedact: *redact,
+
+		Metrics: *metricsAddr != "",
+	})
+
+	if *metricsAddr != "" {
+		go func() {
+			if err := s.Metrics().Serve(ctx, *metricsAddr); err != nil {
+				log.Fatalf("Metrics server failed: %v", err)
+			}
+		}()
+	}
+
+	// Everything besides the scraper that writes or removes snapshots, waited
+	// for before the last index pass
+	var writers sync.WaitGroup
+
+	if *controlAddr != "" {
+		var tokens map[string]string
+		if *controlTokens != "" {
+			if tokens, err = scraper.LoadTokens(*controlTokens); err != nil {
+				log.Fatalf("Failed to load control tokens: %v", err)
+			}
 		}
-	}
-}
-
-// HostStats tracks data rate statistics for a single host
-type HostStats struct {
-	mu      sync.Mutex
-	samples []sample
-}
-
-type sample struct {
-	timestamp time.Time
-	bytes     int64
-}
-
-// Record adds a new sample and prunes old ones (older than 1 hour)
-func (h *HostStats) Record(bytes int64) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-
-	now := time.Now()
-	cutoff := now.Add(-time.Hour)
-
-	// Prune old samples
-	validIdx := 0
-	for _, s := range h.samples {
-		if s.timestamp.After(cutoff) {
-			h.samples[validIdx] = s
-			validIdx++
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			if err := s.ServeControl(ctx, *controlAddr, tokens); err != nil {
+				log.Fatalf("Control API failed: %v", err)
+			}
+		}()
+	}
+
+	if *receiveAddr != "" {
+		if *receiveTokens == "" {
+			log.Fatal("-receive-tokens is required with -receive-addr")
 		}
-	}
-	h.samples = h.samples[:validIdx]
-
-	// Add new sample
-	h.samples = append(h.samples, sample{timestamp: now, bytes: bytes})
-}
-
-// HourlyRate returns the moving average data rate in bytes per hour
-func (h *HostStats) HourlyRate() float64 {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-
-	if len(h.samples) < 2 {
-		return 0
-	}
-
-	// Calculate total bytes and time span
-	var totalBytes int64
-	for _, s := range h.samples {
-		totalBytes += s.bytes
-	}
-
-	// Time span from first to last sample
-	timeSpan := h.samples[len(h.samples)-1].timestamp.Sub(h.samples[0].timestamp)
-	if timeSpan <= 0 {
-		return 0
-	}
-
-	// Extrapolate to hourly rate
-	hoursElapsed := timeSpan.Hours()
-	return float64(totalBytes) / hoursElapsed
-}
-
-type Scraper struct {
-	client   *http.Client
-	outDir   string
-	interval time.Duration
-
-	statsMu sync.RWMutex
-	stats   map[string]*HostStats
-}
-
-func (s *Scraper) getStats(host string) *HostStats {
-	s.statsMu.RLock()
-	st, ok := s.stats[host]
-	s.statsMu.RUnlock()
-	if ok {
-		return st
-	}
-
-	s.statsMu.Lock()
-	defer s.statsMu.Unlock()
-	// Double-check after acquiring write lock
-	if st, ok := s.stats[host]; ok {
-		return st
-	}
-	st = &HostStats{}
-	s.stats[host] = st
-	return st
-}
-
-func (s *Scraper) scrapeAll(ctx context.Context, endpoints []string) {
-	var wg sync.WaitGroup
-	wg.Add(len(endpoints))
-
-	for _, endpoint := range endpoints {
-		go func(ep string) {
-			defer wg.Done()
-			s.scrapeOne(ctx, ep)
-		}(endpoint)
-	}
-
-	wg.Wait()
+		tokens, err := scraper.LoadTokens(*receiveTokens)
+		if err != nil {
+			log.Fatalf("Failed to load receiver tokens: %v", err)
+		}
+		receiver := scraper.NewReceiver(s, tokens, *receiveRate, *receiveBurst, *receiveMax)
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			if err := receiver.Serve(ctx, *receiveAddr); err != nil {
+				log.Fatalf("Receiver failed: %v", err)
+			}
+		}()
+	}
+
+	retention := scraper.RetentionPolicy{
+		MaxAge:        *retainMaxAge,
+		MaxHostBytes:  *retainHostMB << 20,
+		MaxTotalBytes: *retainTotalMB << 20,
+		ThinAfter:     *thinAfter,
+		ThinKeep:      *thinKeep,
+		ThinInterval:  *interval,
+		CompactAfter:  *compactAfter,
+	}
+	if retention.Enabled() {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			scraper.RunRetention(ctx, *outDir, retention, *retainInterval)
+		}()
+	}
+
+	log.Printf("Starting scraper with %d endpoints, interval=%s, output=%s", len(targets), *interval, *outDir)
+
+	if sf != nil {
+		runServe(ctx, s, targets, *outDir, sf, &writers)
+	} else {
+		s.Run(ctx, targets)
+		writers.Wait()
+	}
+	log.Println("Scraper stopped")
 }
 
-func (s *Scraper) scrapeOne(ctx context.Context, endpoint string) {
-	start := time.Now()
-
-	// Parse endpoint to extract host for directory naming
-	parsed, err := url.Parse(endpoint)
-	if err != nil {
-		log.Printf("[%s] ERROR: invalid URL: %v", endpoint, err)
-		return
-	}
-
-	// Build the goroutine debug URL
-	goroutineURL := endpoint
-	if !strings.Contains(endpoint, "/debug/pprof/goroutine") {
-		goroutineURL = strings.TrimSuffix(endpoint, "/") + "/debug/pprof/goroutine?debug=2"
-	}
-
-	// Create request with context
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, goroutineURL, nil)
-	if err != nil {
-		log.Printf("[%s] ERROR: failed to create request: %v", endpoint, err)
-		return
-	}
-
-	resp, err := s.client.Do(req)
-	if err != nil {
-		log.Printf("[%s] ERROR: request failed: %v", endpoint, err)
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("[%s] ERROR: unexpected status code: %d", endpoint, resp.StatusCode)
-		return
-	}
+// runCompact is the "gscrape compact" subcommand: roll up the completed hours
+// of every host once and exit
+func runCompact(args []string) {
+	fs := flag.NewFlagSet("compact", flag.ExitOnError)
+	outDir := fs.String("output", "output", "Output directory")
+	after := fs.Duration("after", time.Hour, "Roll up hours that ended at least this long ago")
+	fs.Parse(args)
 
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("[%s] ERROR: failed to read response: %v", endpoint, err)
-		return
-	}
-
-	// Create output directory: output/<host>/
-	hostDir := sanitizeHost(parsed.Host)
-	outPath := filepath.Join(s.outDir, hostDir)
-	if err := os.MkdirAll(outPath, 0755); err != nil {
-		log.Printf("[%s] ERROR: failed to create output dir: %v", endpoint, err)
-		return
-	}
-
-	// Write to gzipped file: output/<host>/<timestamp>.goroutines.txt.gz
-	timestamp := time.Now().Format("2006-01-02T15-04-05")
-	filename := filepath.Join(outPath, fmt.Sprintf("%s.goroutines.txt.gz", timestamp))
-
-	compressedSize, err := writeGzipped(filename, body)
-	if err != nil {
-		log.Printf("[%s] ERROR: failed to write file: %v", endpoint, err)
-		return
-	}
-
-	// Record stats for this host
-	hostStats := s.getStats(parsed.Host)
-	hostStats.Record(compressedSize)
-	hourlyRate := hostStats.HourlyRate()
-
-	duration := time.Since(start)
-	rawMB := float64(len(body)) / 1024 / 1024
-	compMB := float64(compressedSize) / 1024 / 1024
-	hourlyMB := hourlyRate / 1024 / 1024
-
-	log.Printf("[%s] OK: %.3f MB (%.3f MB gz) in %s, ~%.1f MB/hr -> %s",
-		parsed.Host, rawMB, compMB, duration.Round(time.Millisecond), hourlyMB, filename)
+	scraper.CompactAll(*outDir, *after, time.Now())
 }
 
-// writeGzipped writes data to a gzip-compressed file and returns the compressed size
-func writeGzipped(filename string, data []byte) (int64, error) {
-	f, err := os.Create(filename)
-	if err != nil {
-		return 0, err
-	}
-	defer f.Close()
-
-	gw, err := gzip.NewWriterLevel(f, gzip.BestCompression)
-	if err != nil {
-		return 0, err
-	}
-
-	if _, err := gw.Write(data); err != nil {
-		gw.Close()
-		return 0, err
-	}
-
-	if err := gw.Close(); err != nil {
-		return 0, err
-	}
-
-	// Get the compressed file size
-	info, err := f.Stat()
-	if err != nil {
-		return 0, err
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
 	}
-
-	return info.Size(), nil
-}
-
-// sanitizeHost converts a host:port string into a safe directory name
-func sanitizeHost(host string) string {
-	// Replace colons with underscores for Windows compatibility
-	return strings.ReplaceAll(host, ":", "_")
+	return out
 }
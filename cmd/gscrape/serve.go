@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"gscrape/index"
+	"gscrape/scraper"
+	"gscrape/web"
+)
+
+// "gscrape serve" runs the scraper, indexes the output directory into a
+// Pebble database as it grows and serves the gweb UI and API from that
+// database, all in one process. It takes the flags of a plain run plus the
+// ones below. The database is rebuilt from scratch at startup; after that
+// new snapshots are appended to it every -index-interval. On SIGTERM
+// the web server, the scraper, the receiver and retention stop first, then
+// one last index pass picks up the final snapshots and the database is
+// flushed and closed.
+
+// serveFlags are the flags only "gscrape serve" takes
+type serveFlags struct {
+	db            *string
+	addr          *string
+	indexInterval *time.Duration
+	workers       *int
+	series        *string
+}
+
+func registerServeFlags() *serveFlags {
+	return &serveFlags{
+		db:            flag.String("db", "gindex.db", "Path to the Pebble database, rebuilt at startup"),
+		addr:          flag.String("addr", ":8080", "Listen address for the web UI"),
+		indexInterval: flag.Duration("index-interval", time.Minute, "How often new snapshots are indexed"),
+		workers:       flag.Int("workers", runtime.NumCPU(), "Number of indexing worker goroutines"),
+		series:        flag.String("series", strings.Join(index.DefaultSeries, ","), "Comma-separated runtime metric series to index: expvar paths (memstats.HeapAlloc) or Prometheus metric names"),
+	}
+}
+
+// runServe runs the scraper, the indexer and the web server until ctx is
+// done. writers are the other goroutines writing or removing snapshots
+// (receiver, control API, retention), waited for before the last index pass.
+func runServe(ctx context.Context, s *scraper.Scraper, targets []*scraper.Target, outDir string, sf *serveFlags, writers *sync.WaitGroup) {
+	db, err := index.Create(*sf.db)
+	if err != nil {
+		log.Fatalf("Failed to create database: %v", err)
+	}
+	indexer := index.NewIndexer(db, outDir, *sf.workers, splitList(*sf.series))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runIndexer(ctx, indexer, *sf.indexInterval)
+	}()
+
+	srv := &http.Server{Addr: *sf.addr, Handler: web.NewServer(index.NewReader(db)).Handler()}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		log.Printf("Serving web UI on http://%s", *sf.addr)
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Web server failed: %v", err)
+		}
+	}()
+
+	s.Run(ctx, targets)
+	wg.Wait()
+	writers.Wait()
+
+	// Nothing writes snapshots anymore, so the last ones can be indexed
+	if _, err := indexer.Update(); err != nil {
+		log.Printf("[index] ERROR: %v", err)
+	}
+	if err := db.Flush(); err != nil {
+		log.Printf("[index] ERROR: flush: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		log.Printf("[index] ERROR: close: %v", err)
+	}
+}
+
+// runIndexer indexes changed hosts right away and then every interval
+func runIndexer(ctx context.Context, indexer *index.Indexer, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		if n, err := indexer.Update(); err != nil {
+			log.Printf("[index] ERROR: %v", err)
+		} else if n > 0 {
+			log.Printf("[index] Indexed %d changed hosts in %s", n, time.Since(start).Round(time.Millisecond))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gscrape/snapshot"
+)
+
+// Crash output (GOTRACEBACK=all, SIGQUIT) in log files is extracted as a
+// sequence of logical lines, each carrying the most recent log timestamp.
+type logLine struct {
+	text string
+	ts   time.Time
+}
+
+var (
+	// Timestamps at the start of plain log lines
+	logTimestampRes = []struct {
+		re     *regexp.Regexp
+		layout string
+	}{
+		{regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:\d{2})`), time.RFC3339Nano},
+		{regexp.MustCompile(`^\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)?`), "2006-01-02 15:04:05.999999999"},
+		{regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)?`), "2006/01/02 15:04:05.999999999"},
+	}
+
+	// Goroutine header, including the Go 1.22+ "gp=... m=..." form printed on
+	// crashes, which traceback.Parse reads as is
+	tracebackHeaderRe = regexp.MustCompile(`^goroutine \d+ (?:gp=\S+ m=\S+ (?:mp=\S+ )?)?\[`)
+
+	// Field names commonly used for the message and time in JSON log records
+	jsonMsgFields  = []string{"msg", "message", "log", "MESSAGE"}
+	jsonTimeFields = []string{"time", "ts", "timestamp", "@timestamp", "t"}
+)
+
+// ImportLog extracts traceback blocks from log files and stores each as a
+// snapshot in <inputDir>/<host>/ so it can be indexed like scraped dumps. It
+// returns the number of snapshots written; unreadable log files are skipped.
+func ImportLog(inputDir, host string, logFiles []string) (int, error) {
+	if host == "" {
+		return 0, errors.New("host is required")
+	}
+	if len(logFiles) == 0 {
+		return 0, errors.New("no log files")
+	}
+	if strings.ContainsAny(host, `/\`) || host == "." || host == ".." {
+		return 0, fmt.Errorf("invalid host: %s", host)
+	}
+
+	outPath := filepath.Join(inputDir, strings.ReplaceAll(host, ":", "_"))
+	if err := os.MkdirAll(outPath, 0755); err != nil {
+		return 0, fmt.Errorf("create output dir: %w", err)
+	}
+
+	// Earlier imports may have been rolled up into archives
+	dir, err := snapshot.OpenDir(outPath)
+	if err != nil {
+		log.Printf("Failed to read archives of %s: %v", outPath, err)
+	}
+
+	total := 0
+	written := make(map[string]bool) // snapshots written by this import
+	for _, logFile := range logFiles {
+		lines, err := readLogLines(logFile)
+		if err != nil {
+			log.Printf("Failed to read %s: %v", logFile, err)
+			continue
+		}
+		logName := filepath.Base(logFile)
+
+		// Lines without any timestamp in the log fall back to the file's mtime
+		var fallback time.Time
+		if info, err := os.Stat(logFile); err == nil {
+			fallback = info.ModTime()
+		}
+
+		blocks := extractTracebacks(lines)
+		imported := 0
+		for _, b := range blocks {
+			ts := b.ts
+			if ts.IsZero() {
+				ts = fallback
+			}
+			// Importing the same log again would store its tracebacks twice,
+			// each a millisecond after the first copy
+			if name := snapshot.Name(ts); !written[name] && importedFrom(dir, name, logName) {
+				log.Printf("  %s: traceback at %s already imported, skipping", logFile, ts.Format(time.RFC3339))
+				continue
+			}
+			snap, err := writeSnapshot(outPath, ts, b.text, map[string]string{"log": logName})
+			if err != nil {
+				log.Printf("Failed to write snapshot: %v", err)
+				continue
+			}
+			written[snap.Name()] = true
+			imported++
+			log.Printf("  %s: %d goroutines at %s -> %s", logFile, b.goroutines, ts.Format(time.RFC3339), snap.Path(".goroutines.txt.gz"))
+		}
+		log.Printf("Imported %d tracebacks from %s", imported, logFile)
+		total += imported
+	}
+
+	log.Printf("Import complete. %d snapshots written to %s", total, outPath)
+	return total, nil
+}
+
+// readLogLines reads a plain or gzipped log file, unwrapping JSON records
+func readLogLines(path string) ([]logLine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		r = gr
+	}
+
+	var lines []logLine
+	var lastTS time.Time
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		raw := scanner.Text()
+
+		if msg, ts, ok := unwrapJSONLine(raw); ok {
+			if !ts.IsZero() {
+				lastTS = ts
+			}
+			// A record may hold a whole traceback or a single line of it
+			for _, text := range strings.Split(strings.TrimSuffix(msg, "\n"), "\n") {
+				lines = append(lines, logLine{text: text, ts: lastTS})
+			}
+			continue
+		}
+
+		if ts, ok := parseLineTimestamp(raw); ok {
+			lastTS = ts
+		}
+		lines = append(lines, logLine{text: raw, ts: lastTS})
+	}
+	return lines, scanner.Err()
+}
+
+// unwrapJSONLine extracts message and timestamp from a JSON log record
+func unwrapJSONLine(line string) (string, time.Time, bool) {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "{") {
+		return "", time.Time{}, false
+	}
+
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(trimmed), &record); err != nil {
+		return "", time.Time{}, false
+	}
+
+	var msg string
+	found := false
+	for _, field := range jsonMsgFields {
+		if v, ok := record[field].(string); ok {
+			msg, found = v, true
+			break
+		}
+	}
+	if !found {
+		return "", time.Time{}, false
+	}
+
+	var ts time.Time
+	for _, field := range jsonTimeFields {
+		if t, ok := parseJSONTime(record[field]); ok {
+			ts = t
+			break
+		}
+	}
+	return msg, ts, true
+}
+
+// parseJSONTime accepts RFC 3339 strings and unix seconds or milliseconds
+func parseJSONTime(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case string:
+		if ts, err := time.Parse(time.RFC3339Nano, t); err == nil {
+			return ts, true
+		}
+		if ts, ok := parseLineTimestamp(t); ok {
+			return ts, true
+		}
+	case float64:
+		if t > 1e12 { // milliseconds
+			return time.UnixMilli(int64(t)), true
+		}
+		sec, frac := math.Modf(t)
+		return time.Unix(int64(sec), int64(frac*1e9)), true
+	}
+	return time.Time{}, false
+}
+
+// parseLineTimestamp parses a timestamp at the start of a plain log line.
+// Timestamps without a zone are taken as local time.
+func parseLineTimestamp(line string) (time.Time, bool) {
+	for _, p := range logTimestampRes {
+		if m := p.re.FindString(line); m != "" {
+			if ts, err := time.ParseInLocation(p.layout, m, time.Local); err == nil {
+				return ts, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// logTraceback is one goroutine dump extracted from a log
+type logTraceback struct {
+	text       string
+	ts         time.Time
+	goroutines int
+}
+
+// extractTracebacks finds contiguous runs of goroutine blocks. A run starts at
+// a goroutine header and ends at the first line that isn't part of a traceback
+// (register dumps, "exit status", regular log output). Function and "created
+// by" lines are recognized by the tab-indented file line that follows them.
+func extractTracebacks(lines []logLine) []logTraceback {
+	var result []logTraceback
+	var cur *logTraceback
+	var buf strings.Builder
+
+	flush := func() {
+		if cur != nil && cur.goroutines > 0 {
+			cur.text = strings.TrimRight(buf.String(), "\n") + "\n"
+			result = append(result, *cur)
+		}
+		cur = nil
+		buf.Reset()
+	}
+
+	textAt := func(i int) string {
+		if i >= len(lines) {
+			return ""
+		}
+		return strings.TrimRight(lines[i].text, "\r")
+	}
+
+	for i, l := range lines {
+		text := textAt(i)
+
+		if tracebackHeaderRe.MatchString(text) {
+			if cur == nil {
+				cur = &logTraceback{ts: l.ts}
+			}
+			cur.goroutines++
+			buf.WriteString(text)
+			buf.WriteByte('\n')
+			continue
+		}
+
+		if cur == nil {
+			continue
+		}
+
+		switch {
+		case text == "",
+			strings.HasPrefix(text, "\t"),
+			strings.HasPrefix(text, "...additional frames elided..."),
+			strings.HasPrefix(textAt(i+1), "\t") && !hasLogTimestamp(text):
+			buf.WriteString(text)
+			buf.WriteByte('\n')
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	return result
+}
+
+func hasLogTimestamp(line string) bool {
+	_, ok := parseLineTimestamp(line)
+	return ok
+}
+
+// importedFrom reports whether the snapshot name was imported from a log
+// file called logName
+func importedFrom(dir *snapshot.Dir, name, logName string) bool {
+	data, err := dir.ReadFile(name + ".meta.json")
+	if err != nil {
+		return false
+	}
+	var meta snapshot.Meta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return false
+	}
+	return meta.Labels["log"] == logName
+}
+
+// writeSnapshot stores a dump as <outPath>/<timestamp>.goroutines.txt.gz with
+// its .meta.json sidecar, and lists it in the host's manifest if it has one.
+// If a snapshot already exists for that millisecond, the next free one is used.
+func writeSnapshot(outPath string, ts time.Time, text string, labels map[string]string) (*snapshot.Writer, error) {
+	snap, err := snapshot.Reserve(outPath, ts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := snap.WriteGzipped(".goroutines.txt.gz", []byte(text)); err != nil {
+		snap.Abort()
+		return nil, fmt.Errorf("write %s: %w", snap.Path(".goroutines.txt.gz"), err)
+	}
+	if err := snap.WriteMeta(&snapshot.Meta{Time: snap.Time().UTC(), Labels: labels}); err != nil {
+		snap.Abort()
+		return nil, fmt.Errorf("write %s: %w", snap.Path(".meta.json"), err)
+	}
+	if err := snap.CommitIfListed(); err != nil {
+		return nil, fmt.Errorf("update manifest: %w", err)
+	}
+	return snap, nil
+}
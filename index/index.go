@@ -0,0 +1,1308 @@
+// Package index builds the Pebble database of a gscrape output directory that
+// gweb serves: Build indexes it once, Indexer keeps an open database up to
+// date while gscrape writes, and Reader reads it.
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+
+	"gscrape/snapshot"
+	"gscrape/traceback"
+)
+
+/*
+Database schema:
+
+Key prefixes:
+- "g:<host>:<goroutineID>" -> GoroutineTimeSeries (gzip-compressed JSON)
+  Contains: [{timestamp, state, stack}, ...]
+
+- "f:<funcName>" -> FuncIndex (gzip-compressed JSON)
+  Contains: [{host, goroutineID, firstSeen, lastSeen}, ...]
+
+- "l:<host>" -> LabelStats (gzip-compressed JSON)
+  Contains: goroutine counts per pprof label value over time
+
+- "p:<host>:<timestamp>:<profile>" -> raw pprof profile (gzipped protobuf as scraped)
+
+- "j:<host>" -> JournalStats (gzip-compressed JSON)
+  Contains: failed, skipped and missed scrape attempts from journal.jsonl
+
+- "s:<host>" -> host stats (gzip-compressed JSON)
+  Contains: snapshot timestamps, goroutine counts, available profiles, triggers
+
+- "c:<host>:<parentID>" -> []ChildInfo (gzip-compressed JSON)
+- "h:<host>" -> snapshot.HostInfo (JSON): alias, URL and labels of the target
+- "r:<host>" -> RuntimeStats (gzip-compressed JSON): selected runtime metric series
+- "t:<host>:<timestamp>" -> execution trace (gzip as scraped)
+
+- "m:hosts" -> []string (list of all hosts)
+- "m:funcs" -> []string (list of all function names)
+
+Timestamps, in keys and values, are Unix milliseconds, so snapshots taken
+within the same second stay apart.
+*/
+
+// ========== Data structures ==========
+
+type StackEntry struct {
+	Timestamp int64             `json:"t"`           // Unix milliseconds
+	State     string            `json:"s"`           // e.g., "IO wait", "select"
+	Stack     string            `json:"k"`           // Normalized stack trace
+	CreatedBy int64             `json:"c,omitempty"` // Parent goroutine ID (from "created by ... in goroutine N")
+	Labels    map[string]string `json:"l,omitempty"` // pprof labels (from goroutine?debug=0 profile)
+}
+
+type GoroutineTimeSeries struct {
+	Entries []StackEntry `json:"e"`
+}
+
+// LabelStats holds goroutine counts per label value for the snapshots that
+// have a protobuf goroutine profile
+type LabelStats struct {
+	Timestamps []int64                     `json:"t"`
+	Values     map[string]map[string][]int `json:"v"` // key -> value -> counts aligned with Timestamps
+}
+
+type FuncOccurrence struct {
+	Host        string `json:"h"`
+	GoroutineID int64  `json:"g"`
+	FirstSeen   int64  `json:"f"` // Unix milliseconds
+	LastSeen    int64  `json:"l"` // Unix milliseconds
+}
+
+type FuncIndex struct {
+	Occurrences []FuncOccurrence `json:"o"`
+}
+
+// HostStats holds the snapshots of a host with their goroutine counts and
+// what else was captured with them, for the overview chart
+type HostStats struct {
+	Timestamps []int64            `json:"t"`
+	Counts     []int              `json:"c"`
+	Profiles   map[int64][]string `json:"p,omitempty"`  // timestamp -> available profiles
+	Triggers   map[int64]string   `json:"tr,omitempty"` // timestamp -> trigger of burst snapshots
+	Grouped    []int64            `json:"gr,omitempty"` // timestamps of debug=1 snapshots (counts only)
+	Redactions map[int64]string   `json:"rd,omitempty"` // timestamp -> redaction profile
+	Traces     map[int64]string   `json:"tc,omitempty"` // timestamp -> trigger of the captured trace
+}
+
+// ChildInfo is a goroutine created by another one
+type ChildInfo struct {
+	ID        int64  `json:"i"`
+	Funcs     string `json:"f"` // First two function names
+	FirstSeen int64  `json:"s"`
+	LastSeen  int64  `json:"e"`
+}
+
+// ========== Indexing ==========
+
+// Create removes the database at dbPath and opens an empty one with Zstd
+// compression at all levels
+func Create(dbPath string) (*pebble.DB, error) {
+	os.RemoveAll(dbPath)
+	return pebble.Open(dbPath, &pebble.Options{
+		Levels: []pebble.LevelOptions{
+			{Compression: pebble.ZstdCompression},
+			{Compression: pebble.ZstdCompression},
+			{Compression: pebble.ZstdCompression},
+			{Compression: pebble.ZstdCompression},
+			{Compression: pebble.ZstdCompression},
+			{Compression: pebble.ZstdCompression},
+			{Compression: pebble.ZstdCompression},
+		},
+		Logger: &quietLogger{},
+	})
+}
+
+// Build indexes all hosts of inputDir into a new database at dbPath. series
+// are the runtime metric series to keep (see runtime.go).
+func Build(inputDir, dbPath string, numWorkers int, series []string) error {
+	db, err := Create(dbPath)
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	defer db.Close()
+
+	// Find all hosts
+	hosts, err := findHosts(inputDir)
+	if err != nil {
+		return fmt.Errorf("find hosts: %w", err)
+	}
+	log.Printf("Found %d hosts", len(hosts))
+
+	// Store hosts metadata
+	hostsJSON, _ := json.Marshal(hosts)
+	if err := db.Set([]byte("m:hosts"), hostsJSON, pebble.Sync); err != nil {
+		return fmt.Errorf("store hosts: %w", err)
+	}
+
+	// Process each host
+	allFuncs := make(map[string]struct{})
+	for _, host := range hosts {
+		log.Printf("Processing host: %s", host)
+		hi := newHostIndex()
+		processHost(db, inputDir, host, numWorkers, series, hi)
+		for f := range hi.funcs {
+			allFuncs[f] = struct{}{}
+		}
+	}
+
+	// Store function list
+	funcList := make([]string, 0, len(allFuncs))
+	for f := range allFuncs {
+		funcList = append(funcList, f)
+	}
+	sort.Strings(funcList)
+	funcsJSON, _ := json.Marshal(funcList)
+	if err := db.Set([]byte("m:funcs"), funcsJSON, pebble.Sync); err != nil {
+		return fmt.Errorf("store funcs: %w", err)
+	}
+
+	log.Printf("Indexing complete. %d unique functions indexed.", len(funcList))
+	return nil
+}
+
+func findHosts(inputDir string) ([]string, error) {
+	entries, err := os.ReadDir(inputDir)
+	if err != nil {
+		return nil, err
+	}
+	var hosts []string
+	for _, e := range entries {
+		if e.IsDir() {
+			hosts = append(hosts, e.Name())
+		}
+	}
+	return hosts, nil
+}
+
+// snapshotPatterns match the snapshot files processHost indexes
+var snapshotPatterns = []string{
+	"*.goroutines.*.gz", "*.goroutines.*.zst", // dumps, full or delta
+	"*.meta.json", "*.pb.gz", "*.trace.gz", "*.vars.json.gz", "*.metrics.txt.gz",
+}
+
+// hostIndex is what processHost knows about the indexed snapshots of a host,
+// so that a later call can append newer ones instead of starting over
+type hostIndex struct {
+	files    map[string]bool           // snapshot files indexed, by name
+	newest   string                    // newest snapshot prefix among them
+	metas    map[string]*snapshot.Meta // sidecar metadata by snapshot prefix
+	last     string                    // snapshot prefix of the last dump read...
+	lastDump []byte                    // ...and that dump, the base of a following delta
+	funcs    map[string]struct{}       // functions seen on the host
+}
+
+func newHostIndex() *hostIndex {
+	return &hostIndex{
+		files: make(map[string]bool),
+		metas: make(map[string]*snapshot.Meta),
+		funcs: make(map[string]struct{}),
+	}
+}
+
+// dumpChain is a full dump and the deltas following it, parsed in order by
+// one worker. A chain continuing the last one indexed starts with a delta
+// against prevDump; once parsed, prevName and prevDump are its last dump.
+type dumpChain struct {
+	files    []string
+	prevName string
+	prevDump []byte
+}
+
+// processHost indexes the snapshots of a host that hi doesn't list yet and
+// appends them to the host's series in db. Snapshots hi lists that are gone
+// (retention) are removed from them first. It returns false, without writing
+// anything, if new snapshots are older than the newest indexed (imported
+// logs) or only some files of a snapshot are gone: the host must then be
+// dropped and indexed again with a new hostIndex.
+func processHost(db *pebble.DB, inputDir, host string, numWorkers int, series []string, hi *hostIndex) bool {
+	hostDir := filepath.Join(inputDir, host)
+
+	// Completed snapshots come from the manifest when gscrape wrote one
+	m, err := readManifest(hostDir)
+	if err != nil {
+		log.Printf("Error reading manifest for %s, indexing files on disk: %v", host, err)
+	}
+
+	// Find the snapshot files not indexed yet, by pattern
+	appending := len(hi.files) > 0
+	newFiles := make(map[string][]string)
+	present := make(map[string]bool)
+	for _, pattern := range snapshotPatterns {
+		matches, err := m.glob(hostDir, pattern)
+		if err != nil {
+			log.Printf("Error finding files for %s: %v", host, err)
+			return true
+		}
+		for _, f := range matches {
+			name := filepath.Base(f)
+			present[name] = true
+			if hi.files[name] {
+				continue
+			}
+			if appending && snapshot.Prefix(name) <= hi.newest {
+				return false
+			}
+			newFiles[pattern] = append(newFiles[pattern], f)
+		}
+	}
+	gone := make(map[string]bool) // snapshot prefixes
+	for name := range hi.files {
+		if !present[name] {
+			gone[snapshot.Prefix(name)] = true
+		}
+	}
+	for name := range present {
+		if gone[snapshot.Prefix(name)] {
+			return false
+		}
+	}
+	if len(gone) > 0 {
+		removeSnapshots(db, host, hi, gone)
+	}
+	for name := range present {
+		hi.files[name] = true
+		hi.newest = max(hi.newest, snapshot.Prefix(name))
+	}
+
+	// Dumps, full and deltas, gzip or zstd
+	files := append(newFiles["*.goroutines.*.gz"], newFiles["*.goroutines.*.zst"]...)
+	if m.listed() {
+		unlisted := 0
+		for _, pattern := range snapshotPatterns[:2] {
+			onDisk, _ := m.onDisk(hostDir, pattern)
+			for _, f := range onDisk {
+				if !m.covers(f) {
+					unlisted++
+				}
+			}
+		}
+		if unlisted > 0 {
+			log.Printf("  Skipping %d dumps not in the manifest (still being written?) for %s", unlisted, host)
+		}
+	}
+
+	sort.Strings(files) // Sort by timestamp
+
+	// A delta needs the dump before it, so each full dump and the deltas
+	// following it are parsed in order by one worker
+	var chains []*dumpChain
+	for _, f := range files {
+		if snapshot.IsDelta(f) && len(chains) > 0 {
+			chains[len(chains)-1].files = append(chains[len(chains)-1].files, f)
+			continue
+		}
+		chain := &dumpChain{files: []string{f}}
+		if snapshot.IsDelta(f) {
+			chain.prevName, chain.prevDump = hi.last, hi.lastDump
+		}
+		chains = append(chains, chain)
+	}
+
+	var report integrityReport
+	defer report.log(host)
+
+	// Sidecar metadata, keyed by snapshot file prefix
+	readSnapshotMeta(newFiles["*.meta.json"], m, &report, hi.metas)
+	metas := hi.metas
+
+	// Parse all files and collect goroutine data
+	type parseResult struct {
+		timestamp   time.Time
+		goros       map[int64]*parsedGoroutine
+		labelCounts map[string]map[string]int // nil without a protobuf goroutine profile
+		grouped     int                       // total of a debug=1 snapshot, which has no per-goroutine data
+	}
+
+	results := make(chan parseResult, len(files))
+	chainCh := make(chan *dumpChain, len(chains))
+
+	// Start workers
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chain := range chainCh {
+				prevName, prevDump := chain.prevName, chain.prevDump
+				for _, file := range chain.files {
+					prefix := snapshot.Prefix(file)
+					ts, err := snapshotTime(prefix, metas[prefix])
+					if err != nil {
+						log.Printf("Failed to parse timestamp from %s: %v", file, err)
+						continue
+					}
+
+					data, err := readSnapshotDump(file, m, prevName, prevDump)
+					prevName, prevDump = prefix, data
+					if err != nil {
+						report.record(err)
+						continue
+					}
+
+					// Snapshots downgraded to debug=1 only contribute their total
+					if meta := metas[prefix]; meta != nil && meta.Debug == 1 {
+						total, err := parseGroupedDump(data)
+						if err != nil {
+							report.record(fmt.Errorf("%w: %s: %v", errCorrupt, file, err))
+							continue
+						}
+						results <- parseResult{timestamp: ts, grouped: total}
+						continue
+					}
+
+					// The last goroutine of a partial dump is likely cut off
+					if meta := metas[prefix]; meta != nil && meta.Partial != "" {
+						data = trimPartialDump(data)
+					}
+
+					goros := parseGoroutines(string(data))
+
+					// Join pprof labels from <timestamp>.goroutine.pb.gz if it was scraped
+					var labelCounts map[string]map[string]int
+					labelFile := filepath.Join(hostDir, prefix+".goroutine.pb.gz")
+					if m.covers(labelFile) {
+						profile, err := m.readFile(labelFile)
+						if err == nil {
+							labelCounts, err = applyLabels(profile, goros)
+						}
+						if err != nil && !errors.Is(err, errMissing) {
+							log.Printf("Failed to read labels from %s: %v", labelFile, err)
+						}
+					}
+
+					results <- parseResult{timestamp: ts, goros: goros, labelCounts: labelCounts}
+				}
+				chain.prevName, chain.prevDump = prevName, prevDump
+			}
+		}()
+	}
+
+	// Queue work
+	for _, chain := range chains {
+		chainCh <- chain
+	}
+	close(chainCh)
+
+	// Wait for workers and close results
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Collect results
+	var allResults []parseResult
+	for r := range results {
+		allResults = append(allResults, r)
+	}
+
+	// Sort by timestamp
+	sort.Slice(allResults, func(i, j int) bool {
+		return allResults[i].timestamp.Before(allResults[j].timestamp)
+	})
+
+	if len(chains) > 0 {
+		last := chains[len(chains)-1]
+		hi.last, hi.lastDump = last.prevName, last.prevDump
+	}
+
+	log.Printf("  Parsed %d snapshots for %s", len(allResults), host)
+
+	// Build goroutine time series and stats, continuing the stored ones
+	// First pass: collect stats (fast) and build goroSeries
+	goroSeries := make(map[int64]*GoroutineTimeSeries)
+
+	// Stats: timestamp -> goroutine count
+	var statsData HostStats
+	labelStats := LabelStats{Values: make(map[string]map[string][]int)}
+	if appending {
+		getCompressed(db, "s:"+host, &statsData)
+		getCompressed(db, "l:"+host, &labelStats)
+		if labelStats.Values == nil {
+			labelStats.Values = make(map[string]map[string][]int)
+		}
+	}
+
+	for _, r := range allResults {
+		ts := r.timestamp.UnixMilli()
+
+		if r.goros == nil {
+			statsData.Timestamps = append(statsData.Timestamps, ts)
+			statsData.Counts = append(statsData.Counts, r.grouped)
+			statsData.Grouped = append(statsData.Grouped, ts)
+			continue
+		}
+
+		// Record stats
+		statsData.Timestamps = append(statsData.Timestamps, ts)
+		statsData.Counts = append(statsData.Counts, len(r.goros))
+
+		if r.labelCounts != nil {
+			labelStats.add(ts, r.labelCounts)
+		}
+
+		for goroID, g := range r.goros {
+			// Add to time series
+			if goroSeries[goroID] == nil {
+				goroSeries[goroID] = &GoroutineTimeSeries{}
+			}
+			goroSeries[goroID].Entries = append(goroSeries[goroID].Entries, StackEntry{
+				Timestamp: ts,
+				State:     g.state,
+				Stack:     g.stack,
+				CreatedBy: g.createdBy,
+				Labels:    g.labels,
+			})
+		}
+	}
+
+	// Goroutines seen before continue their stored series
+	if appending {
+		for goroID, series := range goroSeries {
+			var stored GoroutineTimeSeries
+			if getCompressed(db, fmt.Sprintf("g:%s:%d", host, goroID), &stored) {
+				series.Entries = append(stored.Entries, series.Entries...)
+			}
+		}
+	}
+
+	// Second pass: build function occurrences in parallel
+	// Split goroutine IDs into chunks for parallel processing
+	goroIDs := make([]int64, 0, len(goroSeries))
+	for id := range goroSeries {
+		goroIDs = append(goroIDs, id)
+	}
+
+	type funcOccResult struct {
+		fn     string
+		goroID int64
+		occ    *FuncOccurrence
+	}
+
+	resultCh := make(chan []funcOccResult, numWorkers)
+	chunkSize := (len(goroIDs) + numWorkers - 1) / numWorkers
+
+	var funcWg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		start := i * chunkSize
+		if start >= len(goroIDs) {
+			break
+		}
+		end := start + chunkSize
+		if end > len(goroIDs) {
+			end = len(goroIDs)
+		}
+
+		funcWg.Add(1)
+		go func(ids []int64) {
+			defer funcWg.Done()
+			localResults := make([]funcOccResult, 0, 1000)
+			localFuncMap := make(map[string]map[int64]*FuncOccurrence)
+
+			for _, goroID := range ids {
+				series := goroSeries[goroID]
+				if len(series.Entries) == 0 {
+					continue
+				}
+
+				// Collect all unique functions across all entries for this goroutine
+				allFuncs := make(map[string]struct{})
+				var firstTs, lastTs int64
+				firstTs = series.Entries[0].Timestamp
+				lastTs = series.Entries[len(series.Entries)-1].Timestamp
+
+				for _, entry := range series.Entries {
+					// Extract function names from stack
+					funcs := extractFuncsFromStack(entry.Stack)
+					for _, fn := range funcs {
+						allFuncs[fn] = struct{}{}
+					}
+				}
+
+				// Build occurrences
+				for fn := range allFuncs {
+					if localFuncMap[fn] == nil {
+						localFuncMap[fn] = make(map[int64]*FuncOccurrence)
+					}
+					localFuncMap[fn][goroID] = &FuncOccurrence{
+						Host:        host,
+						GoroutineID: goroID,
+						FirstSeen:   firstTs,
+						LastSeen:    lastTs,
+					}
+				}
+			}
+
+			// Convert to results
+			for fn, goroMap := range localFuncMap {
+				for goroID, occ := range goroMap {
+					localResults = append(localResults, funcOccResult{fn: fn, goroID: goroID, occ: occ})
+				}
+			}
+			resultCh <- localResults
+		}(goroIDs[start:end])
+	}
+
+	go func() {
+		funcWg.Wait()
+		close(resultCh)
+	}()
+
+	// Merge results
+	funcOccurrences := make(map[string]map[int64]*FuncOccurrence)
+	for results := range resultCh {
+		for _, r := range results {
+			if funcOccurrences[r.fn] == nil {
+				funcOccurrences[r.fn] = make(map[int64]*FuncOccurrence)
+			}
+			funcOccurrences[r.fn][r.goroID] = r.occ
+		}
+	}
+
+	// Write goroutine time series to DB
+	batch := db.NewBatch()
+	batchSize := 0
+	for goroID, series := range goroSeries {
+		key := fmt.Sprintf("g:%s:%d", host, goroID)
+		value, err := compressJSON(series)
+		if err != nil {
+			log.Printf("Error compressing goroutine series: %v", err)
+			continue
+		}
+		if err := batch.Set([]byte(key), value, nil); err != nil {
+			log.Printf("Error writing goroutine series: %v", err)
+		}
+		batchSize++
+		if batchSize >= 1000 {
+			batch.Commit(pebble.Sync)
+			batch = db.NewBatch()
+			batchSize = 0
+		}
+	}
+	if batchSize > 0 {
+		batch.Commit(pebble.Sync)
+	}
+
+	log.Printf("  Wrote %d goroutine time series for %s", len(goroSeries), host)
+
+	// Build and store children index
+	// Map: parentGoroID -> []ChildInfo
+	childrenIndex := make(map[int64][]ChildInfo)
+
+	for goroID, series := range goroSeries {
+		if len(series.Entries) == 0 {
+			continue
+		}
+
+		// Find parent ID - check all entries since first entry might not have it
+		var parentID int64
+		var funcs string
+		for _, entry := range series.Entries {
+			if entry.CreatedBy != 0 {
+				parentID = entry.CreatedBy
+				// Use the stack from the entry where we found the parent
+				funcs = extractFirstTwoFuncs(entry.Stack)
+				break
+			}
+		}
+
+		if parentID == 0 {
+			continue
+		}
+
+		childrenIndex[parentID] = append(childrenIndex[parentID], ChildInfo{
+			ID:        goroID,
+			Funcs:     funcs,
+			FirstSeen: series.Entries[0].Timestamp,
+			LastSeen:  series.Entries[len(series.Entries)-1].Timestamp,
+		})
+	}
+
+	// Write children index, updating the children stored before
+	for parentID, children := range childrenIndex {
+		key := fmt.Sprintf("c:%s:%d", host, parentID)
+		if appending {
+			var stored []ChildInfo
+			if getCompressed(db, key, &stored) {
+				children = mergeChildren(stored, children)
+			}
+		}
+		if value, err := compressJSON(children); err == nil {
+			if err := db.Set([]byte(key), value, pebble.NoSync); err != nil {
+				log.Printf("Error writing children index: %v", err)
+			}
+		}
+	}
+	log.Printf("  Indexed children for %d parent goroutines for %s", len(childrenIndex), host)
+
+	// Store label series for this host
+	if len(labelStats.Timestamps) > 0 {
+		if value, err := compressJSON(&labelStats); err == nil {
+			if err := db.Set([]byte("l:"+host), value, pebble.NoSync); err != nil {
+				log.Printf("Error writing label stats: %v", err)
+			}
+		}
+		log.Printf("  Indexed %d label keys over %d snapshots for %s", len(labelStats.Values), len(labelStats.Timestamps), host)
+	}
+
+	// Store extra pprof profiles scraped alongside the dumps
+	profiles := indexProfiles(db, host, newFiles["*.pb.gz"], metas, m, &report)
+
+	// Execution traces captured when a trace trigger fired
+	traces := indexTraces(db, host, newFiles["*.trace.gz"], metas, m, &report)
+
+	// Runtime metrics scraped alongside the dumps
+	indexRuntimeMetrics(db, host, append(newFiles["*.vars.json.gz"], newFiles["*.metrics.txt.gz"]...), series, metas, m, &report, appending)
+
+	// Scrape attempts from the scraper's journal
+	indexJournal(db, hostDir, host)
+
+	// Alias, URL and labels of the target
+	indexHostInfo(db, hostDir, host, metas)
+
+	// Snapshots taken because a trigger fired, and the redaction profile
+	// ("<name>@<digest>") of redacted ones
+	triggers := make(map[int64]string)
+	redactions := make(map[int64]string)
+	for _, file := range newFiles["*.meta.json"] {
+		prefix := snapshot.Prefix(file)
+		meta := metas[prefix]
+		if meta == nil || (meta.Trigger == "" && meta.Redaction == "") {
+			continue
+		}
+		ts, err := snapshotTime(prefix, meta)
+		if err != nil {
+			continue
+		}
+		if meta.Trigger != "" {
+			triggers[ts.UnixMilli()] = meta.Trigger
+		}
+		if meta.Redaction != "" {
+			redactions[ts.UnixMilli()] = meta.Redaction + "@" + meta.RedactionDigest
+		}
+	}
+
+	// Store stats for this host
+	statsData.Profiles = mergeMaps(statsData.Profiles, profiles)
+	statsData.Triggers = mergeMaps(statsData.Triggers, triggers)
+	statsData.Redactions = mergeMaps(statsData.Redactions, redactions)
+	statsData.Traces = mergeMaps(statsData.Traces, traces)
+	if statsValue, err := compressJSON(&statsData); err == nil {
+		if err := db.Set([]byte("s:"+host), statsValue, pebble.Sync); err != nil {
+			log.Printf("Error writing stats: %v", err)
+		}
+	}
+
+	// Merge function occurrences into existing index
+	for funcName, goroMap := range funcOccurrences {
+		hi.funcs[funcName] = struct{}{}
+
+		key := []byte("f:" + funcName)
+
+		// Read existing
+		var existing FuncIndex
+		if val, closer, err := db.Get(key); err == nil {
+			decompressJSON(val, &existing)
+			closer.Close()
+		}
+
+		// Add new occurrences, replacing the host's stored ones of the
+		// same goroutines
+		stored := make(map[int64]int)
+		for i, occ := range existing.Occurrences {
+			if occ.Host == host {
+				stored[occ.GoroutineID] = i
+			}
+		}
+		for _, occ := range goroMap {
+			if i, ok := stored[occ.GoroutineID]; ok {
+				existing.Occurrences[i] = *occ
+			} else {
+				existing.Occurrences = append(existing.Occurrences, *occ)
+			}
+		}
+
+		// Write back
+		value, err := compressJSON(&existing)
+		if err != nil {
+			log.Printf("Error compressing func index: %v", err)
+			continue
+		}
+		if err := db.Set(key, value, pebble.NoSync); err != nil {
+			log.Printf("Error writing func index: %v", err)
+		}
+	}
+
+	log.Printf("  Indexed %d functions for %s", len(funcOccurrences), host)
+
+	return true
+}
+
+// mergeChildren updates the stored children of a goroutine with newer info
+// about them and about new ones
+func mergeChildren(stored, children []ChildInfo) []ChildInfo {
+	pos := make(map[int64]int, len(stored))
+	for i, c := range stored {
+		pos[c.ID] = i
+	}
+	for _, c := range children {
+		if i, ok := pos[c.ID]; ok {
+			stored[i] = c
+		} else {
+			stored = append(stored, c)
+		}
+	}
+	return stored
+}
+
+// mergeMaps adds the entries of src to dst, which may be nil
+func mergeMaps[K comparable, V any](dst, src map[K]V) map[K]V {
+	if dst == nil {
+		dst = make(map[K]V, len(src))
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
+
+// indexProfiles stores the given <timestamp>.<profile>.pb.gz files of a host
+// and returns which profiles exist for each snapshot timestamp
+func indexProfiles(db *pebble.DB, host string, files []string, metas map[string]*snapshot.Meta, m *manifest, report *integrityReport) map[int64][]string {
+	profiles := make(map[int64][]string)
+	for _, file := range files {
+		// 2026-01-17T14-33-01-123Z.mutex.pb.gz -> ["2026-01-17T14-33-01-123Z", "mutex"]
+		parts := strings.SplitN(strings.TrimSuffix(filepath.Base(file), ".pb.gz"), ".", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		ts, err := snapshotTime(parts[0], metas[parts[0]])
+		if err != nil {
+			log.Printf("Failed to parse timestamp from %s: %v", file, err)
+			continue
+		}
+
+		data, err := m.readFile(file)
+		if err != nil {
+			report.record(err)
+			continue
+		}
+
+		key := fmt.Sprintf("p:%s:%d:%s", host, ts.UnixMilli(), parts[1])
+		if err := db.Set([]byte(key), data, pebble.NoSync); err != nil {
+			log.Printf("Error writing profile: %v", err)
+			continue
+		}
+		profiles[ts.UnixMilli()] = append(profiles[ts.UnixMilli()], parts[1])
+	}
+
+	if len(profiles) > 0 {
+		log.Printf("  Stored profiles for %d snapshots for %s", len(profiles), host)
+	}
+	return profiles
+}
+
+// indexTraces stores the given <timestamp>.trace.gz files of a host and
+// returns the trigger behind each, by snapshot timestamp
+func indexTraces(db *pebble.DB, host string, files []string, metas map[string]*snapshot.Meta, m *manifest, report *integrityReport) map[int64]string {
+	traces := make(map[int64]string)
+	for _, file := range files {
+		prefix := strings.TrimSuffix(filepath.Base(file), ".trace.gz")
+		ts, err := snapshotTime(prefix, metas[prefix])
+		if err != nil {
+			log.Printf("Failed to parse timestamp from %s: %v", file, err)
+			continue
+		}
+
+		data, err := m.readFile(file)
+		if err != nil {
+			report.record(err)
+			continue
+		}
+
+		key := fmt.Sprintf("t:%s:%d", host, ts.UnixMilli())
+		if err := db.Set([]byte(key), data, pebble.NoSync); err != nil {
+			log.Printf("Error writing trace: %v", err)
+			continue
+		}
+		traces[ts.UnixMilli()] = "trace"
+		if meta := metas[prefix]; meta != nil && meta.Trace != "" {
+			traces[ts.UnixMilli()] = meta.Trace
+		}
+	}
+
+	if len(traces) > 0 {
+		log.Printf("  Stored %d traces for %s", len(traces), host)
+	}
+	return traces
+}
+
+// JournalStats summarizes a host's scrape journal: the number of attempts and
+// every attempt that didn't produce a complete snapshot on schedule
+type JournalStats struct {
+	Attempts int              `json:"n"`
+	Failures []JournalFailure `json:"f"`
+}
+
+type JournalFailure struct {
+	Timestamp  int64  `json:"t"`
+	DurationMS int64  `json:"d"`
+	Status     int    `json:"s,omitempty"`
+	Error      string `json:"e"`
+}
+
+// indexJournal stores the failed, skipped and missed attempts of a host's
+// journal.jsonl so gaps in the charts can be explained
+func indexJournal(db *pebble.DB, hostDir, host string) {
+	f, err := os.Open(filepath.Join(hostDir, snapshot.JournalFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to open journal for %s: %v", host, err)
+		}
+		return
+	}
+	defer f.Close()
+
+	var stats JournalStats
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e snapshot.JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue // torn last line of a running scraper
+		}
+		stats.Attempts++
+
+		var reasons []string
+		if e.Missed > 0 {
+			reasons = append(reasons, fmt.Sprintf("missed %d scrape(s), previous scrape still running", e.Missed))
+		}
+		if e.Skipped != "" {
+			reasons = append(reasons, "skipped: "+e.Skipped)
+		}
+		if e.Error != "" {
+			reasons = append(reasons, e.Error)
+		}
+		if e.Partial != "" {
+			reasons = append(reasons, "partial dump: "+e.Partial)
+		}
+		if len(reasons) == 0 {
+			continue
+		}
+		stats.Failures = append(stats.Failures, JournalFailure{
+			Timestamp:  e.Start.UnixMilli(),
+			DurationMS: e.DurationMS,
+			Status:     e.Status,
+			Error:      strings.Join(reasons, "; "),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Failed to read journal for %s: %v", host, err)
+	}
+
+	if value, err := compressJSON(&stats); err == nil {
+		if err := db.Set([]byte("j:"+host), value, pebble.NoSync); err != nil {
+			log.Printf("Error writing journal: %v", err)
+		}
+	}
+	log.Printf("  Indexed %d scrape attempts (%d failed) for %s", stats.Attempts, len(stats.Failures), host)
+}
+
+// indexHostInfo stores a host's host.json. Hosts without one (older output,
+// pushed dumps) get the URL and labels of their newest snapshot.
+func indexHostInfo(db *pebble.DB, hostDir, host string, metas map[string]*snapshot.Meta) {
+	var info snapshot.HostInfo
+	data, err := os.ReadFile(filepath.Join(hostDir, snapshot.HostInfoFile))
+	if err == nil {
+		if err := json.Unmarshal(data, &info); err != nil {
+			log.Printf("Failed to parse host.json for %s: %v", host, err)
+		}
+	} else {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read host.json for %s: %v", host, err)
+		}
+		var newest time.Time
+		for prefix, meta := range metas {
+			if ts, err := snapshotTime(prefix, meta); err == nil && ts.After(newest) {
+				newest = ts
+				info.URL, info.Labels = meta.URL, meta.Labels
+			}
+		}
+	}
+
+	value, err := json.Marshal(&info)
+	if err != nil {
+		return
+	}
+	if err := db.Set([]byte("h:"+host), value, pebble.NoSync); err != nil {
+		log.Printf("Error writing host info: %v", err)
+	}
+}
+
+// readSnapshotMeta reads the given <timestamp>.meta.json files into metas,
+// keyed by the file prefix shared with the rest of the snapshot
+func readSnapshotMeta(files []string, m *manifest, report *integrityReport, metas map[string]*snapshot.Meta) {
+	for _, file := range files {
+		data, err := m.readFile(file)
+		if err != nil {
+			report.record(err)
+			continue
+		}
+		var meta snapshot.Meta
+		if err := json.Unmarshal(data, &meta); err != nil {
+			log.Printf("Failed to parse %s: %v", file, err)
+			continue
+		}
+		metas[strings.TrimSuffix(filepath.Base(file), ".meta.json")] = &meta
+	}
+}
+
+// snapshotTime is the time of the snapshot with the given file prefix: the
+// precise time from its metadata, or else the time in its name
+func snapshotTime(prefix string, meta *snapshot.Meta) (time.Time, error) {
+	if meta != nil && !meta.Time.IsZero() {
+		return meta.Time, nil
+	}
+	return snapshot.ParseName(prefix)
+}
+
+// add appends one snapshot's label counts, padding series that are missing
+// from either side with zeros
+func (l *LabelStats) add(ts int64, counts map[string]map[string]int) {
+	n := len(l.Timestamps)
+	l.Timestamps = append(l.Timestamps, ts)
+
+	for key, values := range counts {
+		if l.Values[key] == nil {
+			l.Values[key] = make(map[string][]int)
+		}
+		for value, count := range values {
+			if l.Values[key][value] == nil {
+				l.Values[key][value] = make([]int, n)
+			}
+			l.Values[key][value] = append(l.Values[key][value], count)
+		}
+	}
+
+	for _, values := range l.Values {
+		for value, series := range values {
+			if len(series) == n {
+				values[value] = append(series, 0)
+			}
+		}
+	}
+}
+
+type parsedGoroutine struct {
+	state     string
+	stack     string            // normalized, see traceback.Goroutine.Stack
+	frames    []string          // function names in stack order, leaf first
+	createdBy int64             // parent goroutine ID
+	labels    map[string]string // pprof labels, joined from the protobuf profile
+}
+
+// readSnapshotDump reads the dump of a snapshot, gzip or zstd compressed. A
+// delta is decoded against prevDump, the dump of the snapshot before it
+// (prevName).
+func readSnapshotDump(path string, m *manifest, prevName string, prevDump []byte) ([]byte, error) {
+	data, err := m.readCompressedFile(path)
+	if err != nil || !snapshot.IsDelta(path) {
+		return data, err
+	}
+	data, err = snapshot.DecodeDelta(data, prevName, prevDump)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", errCorrupt, path, err)
+	}
+	return data, nil
+}
+
+// trimPartialDump drops the last goroutine block of a dump that broke off
+func trimPartialDump(data []byte) []byte {
+	if i := bytes.LastIndex(data, []byte("\n\n")); i >= 0 {
+		return data[:i+1]
+	}
+	return nil
+}
+
+// parseGroupedDump returns the goroutine total of a debug=1 dump
+// ("goroutine profile: total 1234")
+func parseGroupedDump(data []byte) (int, error) {
+	var total int
+	if _, err := fmt.Sscanf(string(data), "goroutine profile: total %d", &total); err != nil {
+		return 0, fmt.Errorf("not a debug=1 dump: %w", err)
+	}
+	return total, nil
+}
+
+// parseGoroutines parses a debug=2 dump by goroutine ID
+func parseGoroutines(data string) map[int64]*parsedGoroutine {
+	goros := traceback.Parse(data)
+	result := make(map[int64]*parsedGoroutine, len(goros))
+	for _, g := range goros {
+		result[g.ID] = &parsedGoroutine{
+			state:     g.State,
+			stack:     g.Stack(),
+			frames:    g.Funcs(),
+			createdBy: g.ParentID,
+		}
+	}
+	return result
+}
+
+// extractFuncsFromStack extracts all function names from a normalized stack trace
+func extractFuncsFromStack(stack string) []string {
+	lines := strings.Split(stack, "\n")
+	var funcs []string
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.Contains(line, ".go:") {
+			continue
+		}
+		if strings.HasPrefix(line, "created by") {
+			continue
+		}
+		// Extract function name
+		fn := line
+		if idx := strings.Index(fn, "("); idx > 0 {
+			// Check if it's a method receiver like (*Type)
+			beforeParen := fn[:idx]
+			if len(beforeParen) > 0 {
+				lastChar := beforeParen[len(beforeParen)-1]
+				if lastChar != '.' && lastChar != '*' {
+					fn = beforeParen
+				}
+			}
+		}
+		// Clean the function name (remove path)
+		fn = cleanFuncName(fn)
+		if fn != "" {
+			funcs = append(funcs, fn)
+		}
+	}
+	return funcs
+}
+
+// extractBottomTwoFuncs extracts the bottom two function names from a stack trace (entry point)
+// This shows where the goroutine started, not what it's currently doing
+func extractFirstTwoFuncs(stack string) string {
+	lines := strings.Split(stack, "\n")
+
+	// Collect all function lines first
+	var allFuncs []string
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		// Skip file lines (contain .go:)
+		if line == "" || strings.Contains(line, ".go:") {
+			continue
+		}
+		// Skip "created by" lines
+		if strings.HasPrefix(line, "created by") {
+			continue
+		}
+
+		fn := line
+
+		// Find the last slash to get the package portion first
+		lastSlash := strings.LastIndex(fn, "/")
+		if lastSlash >= 0 {
+			fn = fn[lastSlash+1:]
+		}
+
+		// Remove the trailing arguments - find the last '(' that starts the args
+		if lastParen := strings.LastIndex(fn, "("); lastParen > 0 {
+			beforeParen := fn[:lastParen]
+			if len(beforeParen) > 0 {
+				lastChar := beforeParen[len(beforeParen)-1]
+				if lastChar != '.' && lastChar != '*' {
+					fn = beforeParen
+				}
+			}
+		}
+
+		allFuncs = append(allFuncs, fn)
+	}
+
+	// Take the last two (bottom of stack = entry point)
+	if len(allFuncs) == 0 {
+		return ""
+	}
+	if len(allFuncs) == 1 {
+		return allFuncs[0]
+	}
+	// Return bottom two in order: second-to-last -> last (entry point)
+	return allFuncs[len(allFuncs)-2] + " -> " + allFuncs[len(allFuncs)-1]
+}
+
+func cleanFuncName(fn string) string {
+	// Remove trailing function arguments (...) but preserve method receivers like (*Type)
+	// Find the last ) that's part of a method receiver pattern .\(*...\)
+	// Then find any trailing ( that starts function arguments
+
+	// Look for the pattern ).MethodName( which indicates end of receiver + method + args
+	// Or just .FuncName( for regular functions
+	lastDot := strings.LastIndex(fn, ".")
+	if lastDot > 0 {
+		// Check if there's a ( after the last dot (function arguments)
+		afterDot := fn[lastDot:]
+		if parenIdx := strings.Index(afterDot, "("); parenIdx > 0 {
+			// This is function arguments, not a method receiver
+			fn = fn[:lastDot+parenIdx]
+		}
+	}
+	return fn
+}
+
+func compressJSON(v interface{}) ([]byte, error) {
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(jsonData); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// getCompressed decodes the gzip-compressed JSON value of key into v and
+// reports whether there was one
+func getCompressed(db *pebble.DB, key string, v interface{}) bool {
+	val, closer, err := db.Get([]byte(key))
+	if err != nil {
+		return false
+	}
+	defer closer.Close()
+	return decompressJSON(val, v) == nil
+}
+
+func decompressJSON(data []byte, v interface{}) error {
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+
+	jsonData, err := io.ReadAll(gr)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(jsonData, v)
+}
+
+// ========== Querying ==========
+
+// FuncMatch is a function matched by Query with the goroutines that ran it,
+// sorted by first seen
+type FuncMatch struct {
+	Func        string
+	Occurrences []FuncOccurrence
+}
+
+// Query returns the functions matching funcPattern (case-insensitive
+// substring) with their goroutines on hosts containing hostFilter
+func Query(dbPath, funcPattern, hostFilter string) ([]FuncMatch, error) {
+	r, err := Open(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	defer r.Close()
+
+	funcs, err := r.Funcs()
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []FuncMatch
+	pattern := strings.ToLower(funcPattern)
+	for _, fn := range funcs {
+		if !strings.Contains(strings.ToLower(fn), pattern) {
+			continue
+		}
+		idx, err := r.FuncIndex(fn)
+		if err != nil {
+			continue
+		}
+
+		// Filter by host if specified
+		var filtered []FuncOccurrence
+		for _, occ := range idx.Occurrences {
+			if hostFilter == "" || strings.Contains(occ.Host, hostFilter) {
+				filtered = append(filtered, occ)
+			}
+		}
+		if len(filtered) == 0 {
+			continue
+		}
+
+		sort.Slice(filtered, func(i, j int) bool {
+			return filtered[i].FirstSeen < filtered[j].FirstSeen
+		})
+		matches = append(matches, FuncMatch{Func: fn, Occurrences: filtered})
+	}
+	return matches, nil
+}
+
+// ListFuncs returns the indexed function names containing pattern
+// (case-insensitive), or all of them if pattern is empty
+func ListFuncs(dbPath, pattern string) ([]string, error) {
+	r, err := Open(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	defer r.Close()
+
+	funcs, err := r.Funcs()
+	if err != nil {
+		return nil, err
+	}
+
+	lowerPattern := strings.ToLower(pattern)
+	var out []string
+	for _, fn := range funcs {
+		if pattern == "" || strings.Contains(strings.ToLower(fn), lowerPattern) {
+			out = append(out, fn)
+		}
+	}
+	return out, nil
+}
+
+// ========== Utility ==========
+
+type quietLogger struct{}
+
+func (q *quietLogger) Infof(format string, args ...interface{})  {}
+func (q *quietLogger) Errorf(format string, args ...interface{}) {}
+func (q *quietLogger) Fatalf(format string, args ...interface{}) { log.Fatalf(format, args...) }
+
+func int64ToBytes(n int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(n))
+	return b
+}
+
+func bytesToInt64(b []byte) int64 {
+	return int64(binary.BigEndian.Uint64(b))
+}
@@ -0,0 +1,294 @@
+package index
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Goroutine stacks from the debug=2 text dump and the debug=0 protobuf profile
+// are matched by their function names. Both formats truncate deep stacks
+// differently, so only the top frames take part in the signature.
+const labelSigDepth = 32
+
+// labeledSample is one sample of a protobuf goroutine profile: a stack shared
+// by Count goroutines that all carry the same pprof labels
+type labeledSample struct {
+	Funcs  []string // function names, leaf first
+	Labels map[string]string
+	Count  int64
+}
+
+// stackSignature builds the join key between text dumps and protobuf samples.
+// Runtime frames are skipped: the text traceback elides some of them (e.g.
+// runtime.gopark under time.Sleep) while the profile keeps them.
+func stackSignature(funcs []string) string {
+	var sig []string
+	for _, fn := range funcs {
+		if strings.HasPrefix(fn, "runtime.") {
+			continue
+		}
+		sig = append(sig, fn)
+		if len(sig) >= labelSigDepth {
+			break
+		}
+	}
+	return strings.Join(sig, "\n")
+}
+
+// readLabeledSamples parses a gzipped goroutine profile (goroutine?debug=0)
+func readLabeledSamples(data []byte) ([]labeledSample, error) {
+	// Profiles are gzipped on the wire, but accept plain protobuf too
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		data, err = io.ReadAll(gr)
+		gr.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return decodeLabeledSamples(data)
+}
+
+// decodeLabeledSamples decodes the parts of profile.proto needed to resolve
+// sample stacks and string labels. Numeric labels are ignored.
+func decodeLabeledSamples(data []byte) ([]labeledSample, error) {
+	type rawSample struct {
+		locations []uint64
+		values    []uint64
+		labels    [][2]int64 // (key, str) string table indexes
+	}
+
+	var (
+		samples   []rawSample
+		locations = make(map[uint64][]uint64) // location ID -> function IDs (callee first)
+		functions = make(map[uint64]int64)    // function ID -> name string index
+		strs      []string
+	)
+
+	err := walkFields(data, func(num protowire.Number, typ protowire.Type, v []byte, x uint64) error {
+		switch num {
+		case 2: // sample
+			var s rawSample
+			err := walkFields(v, func(num protowire.Number, typ protowire.Type, v []byte, x uint64) error {
+				switch num {
+				case 1: // location_id
+					ids, err := unpackVarints(typ, v, x)
+					s.locations = append(s.locations, ids...)
+					return err
+				case 2: // value
+					vals, err := unpackVarints(typ, v, x)
+					s.values = append(s.values, vals...)
+					return err
+				case 3: // label
+					var key, str int64
+					err := walkFields(v, func(num protowire.Number, typ protowire.Type, v []byte, x uint64) error {
+						switch num {
+						case 1:
+							key = int64(x)
+						case 2:
+							str = int64(x)
+						}
+						return nil
+					})
+					if str != 0 {
+						s.labels = append(s.labels, [2]int64{key, str})
+					}
+					return err
+				}
+				return nil
+			})
+			samples = append(samples, s)
+			return err
+		case 4: // location
+			var id uint64
+			var funcs []uint64
+			err := walkFields(v, func(num protowire.Number, typ protowire.Type, v []byte, x uint64) error {
+				switch num {
+				case 1:
+					id = x
+				case 4: // line
+					return walkFields(v, func(num protowire.Number, typ protowire.Type, v []byte, x uint64) error {
+						if num == 1 {
+							funcs = append(funcs, x)
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+			locations[id] = funcs
+			return err
+		case 5: // function
+			var id uint64
+			var name int64
+			err := walkFields(v, func(num protowire.Number, typ protowire.Type, v []byte, x uint64) error {
+				switch num {
+				case 1:
+					id = x
+				case 2:
+					name = int64(x)
+				}
+				return nil
+			})
+			functions[id] = name
+			return err
+		case 6: // string_table
+			strs = append(strs, string(v))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	str := func(i int64) string {
+		if i < 0 || int(i) >= len(strs) {
+			return ""
+		}
+		return strs[i]
+	}
+
+	result := make([]labeledSample, 0, len(samples))
+	for _, s := range samples {
+		ls := labeledSample{}
+		if len(s.values) > 0 {
+			ls.Count = int64(s.values[0])
+		}
+		// Within a location, inlined callees come before their caller
+		for _, locID := range s.locations {
+			for _, fnID := range locations[locID] {
+				ls.Funcs = append(ls.Funcs, str(functions[fnID]))
+			}
+		}
+		if len(s.labels) > 0 {
+			ls.Labels = make(map[string]string, len(s.labels))
+			for _, l := range s.labels {
+				ls.Labels[str(l[0])] = str(l[1])
+			}
+		}
+		result = append(result, ls)
+	}
+	return result, nil
+}
+
+// walkFields calls fn for every field of a protobuf message. Length-delimited
+// fields are passed in v, varint and fixed fields in x.
+func walkFields(b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte, x uint64) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var v []byte
+		var x uint64
+		switch typ {
+		case protowire.VarintType:
+			x, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed32Type:
+			var x32 uint32
+			x32, n = protowire.ConsumeFixed32(b)
+			x = uint64(x32)
+		case protowire.Fixed64Type:
+			x, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if err := fn(num, typ, v, x); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unpackVarints handles both packed and unpacked repeated varint fields
+func unpackVarints(typ protowire.Type, v []byte, x uint64) ([]uint64, error) {
+	if typ == protowire.VarintType {
+		return []uint64{x}, nil
+	}
+	if typ != protowire.BytesType {
+		return nil, fmt.Errorf("unexpected wire type %d for repeated varint", typ)
+	}
+	var out []uint64
+	for len(v) > 0 {
+		val, n := protowire.ConsumeVarint(v)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		out = append(out, val)
+		v = v[n:]
+	}
+	return out, nil
+}
+
+// applyLabels assigns label sets from a protobuf goroutine profile to the
+// goroutines of the matching text dump and returns goroutine counts per label
+// (key -> value -> count). Goroutines sharing a stack signature are handed
+// the available label sets in goroutine ID order.
+func applyLabels(profile []byte, goros map[int64]*parsedGoroutine) (map[string]map[string]int, error) {
+	samples, err := readLabeledSamples(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	type pending struct {
+		labels map[string]string
+		left   int64
+	}
+	bySig := make(map[string][]*pending)
+	counts := make(map[string]map[string]int)
+
+	for _, s := range samples {
+		if len(s.Labels) == 0 {
+			continue
+		}
+		for k, v := range s.Labels {
+			if counts[k] == nil {
+				counts[k] = make(map[string]int)
+			}
+			counts[k][v] += int(s.Count)
+		}
+		sig := stackSignature(s.Funcs)
+		bySig[sig] = append(bySig[sig], &pending{labels: s.Labels, left: s.Count})
+	}
+
+	ids := make([]int64, 0, len(goros))
+	for id := range goros {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		g := goros[id]
+		queue := bySig[stackSignature(g.frames)]
+		for len(queue) > 0 && queue[0].left <= 0 {
+			queue = queue[1:]
+		}
+		if len(queue) == 0 {
+			continue
+		}
+		g.labels = queue[0].labels
+		queue[0].left--
+		bySig[stackSignature(g.frames)] = queue
+	}
+
+	return counts, nil
+}
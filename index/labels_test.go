@@ -0,0 +1,213 @@
+package index
+
+import (
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// testProfile builds the parts of a protobuf goroutine profile that
+// decodeLabeledSamples reads
+type testProfile struct {
+	buf  []byte
+	strs map[string]uint64
+	tab  []string
+}
+
+func newTestProfile() *testProfile {
+	return &testProfile{strs: map[string]uint64{"": 0}, tab: []string{""}}
+}
+
+// str returns the string table index of s
+func (p *testProfile) str(s string) uint64 {
+	if i, ok := p.strs[s]; ok {
+		return i
+	}
+	p.strs[s] = uint64(len(p.tab))
+	p.tab = append(p.tab, s)
+	return p.strs[s]
+}
+
+func (p *testProfile) message(num protowire.Number, fields []byte) {
+	p.buf = protowire.AppendTag(p.buf, num, protowire.BytesType)
+	p.buf = protowire.AppendBytes(p.buf, fields)
+}
+
+func varintField(b []byte, num protowire.Number, x uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, x)
+}
+
+// function adds function id named name
+func (p *testProfile) function(id uint64, name string) {
+	p.message(5, varintField(varintField(nil, 1, id), 2, p.str(name)))
+}
+
+// location adds location id with lines calling the given function IDs,
+// inlined callees first
+func (p *testProfile) location(id uint64, funcs ...uint64) {
+	b := varintField(nil, 1, id)
+	for _, fn := range funcs {
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendBytes(b, varintField(nil, 1, fn))
+	}
+	p.message(4, b)
+}
+
+// sample adds a sample of count goroutines at the given locations, leaf
+// first, with string labels given as key, value pairs. Location IDs are
+// packed unless packed is false.
+func (p *testProfile) sample(count uint64, packed bool, locs []uint64, labels ...string) {
+	var b []byte
+	if packed {
+		var ids []byte
+		for _, id := range locs {
+			ids = protowire.AppendVarint(ids, id)
+		}
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, ids)
+	} else {
+		for _, id := range locs {
+			b = varintField(b, 1, id)
+		}
+	}
+	b = varintField(b, 2, count)
+	for i := 0; i+1 < len(labels); i += 2 {
+		label := varintField(varintField(nil, 1, p.str(labels[i])), 2, p.str(labels[i+1]))
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, label)
+	}
+	// A numeric label, which is ignored
+	b = protowire.AppendTag(b, 3, protowire.BytesType)
+	b = protowire.AppendBytes(b, varintField(varintField(nil, 1, p.str("bytes")), 3, 42))
+	p.message(2, b)
+}
+
+// bytes returns the encoded profile, the string table last
+func (p *testProfile) bytes() []byte {
+	b := p.buf
+	for _, s := range p.tab {
+		b = protowire.AppendTag(b, 6, protowire.BytesType)
+		b = protowire.AppendString(b, s)
+	}
+	return b
+}
+
+// workerProfile has 3 goroutines in main.worker, 2 labeled job=a, 1 job=b,
+// and one unlabeled goroutine in main.main
+func workerProfile() []byte {
+	p := newTestProfile()
+	p.function(1, "runtime.gopark")
+	p.function(2, "main.worker")
+	p.function(3, "main.loop")
+	p.function(4, "main.main")
+	p.location(10, 1)
+	p.location(11, 2, 3) // main.worker inlined into main.loop
+	p.location(12, 4)
+	p.sample(2, true, []uint64{10, 11}, "job", "a")
+	p.sample(1, false, []uint64{10, 11}, "job", "b", "user", "x")
+	p.sample(1, true, []uint64{12})
+	return p.bytes()
+}
+
+func TestDecodeLabeledSamples(t *testing.T) {
+	want := []labeledSample{
+		{Funcs: []string{"runtime.gopark", "main.worker", "main.loop"}, Labels: map[string]string{"job": "a"}, Count: 2},
+		{Funcs: []string{"runtime.gopark", "main.worker", "main.loop"}, Labels: map[string]string{"job": "b", "user": "x"}, Count: 1},
+		{Funcs: []string{"main.main"}, Count: 1},
+	}
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(workerProfile())
+	gw.Close()
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"plain", workerProfile()},
+		{"gzipped", gz.Bytes()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readLabeledSamples(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+
+	if _, err := decodeLabeledSamples([]byte{0x12, 0x05, 0x08}); err == nil {
+		t.Error("decoded a truncated profile")
+	}
+}
+
+func TestApplyLabels(t *testing.T) {
+	worker := func() *parsedGoroutine {
+		// The text dump elides runtime.gopark
+		return &parsedGoroutine{frames: []string{"main.worker", "main.loop"}}
+	}
+	goros := map[int64]*parsedGoroutine{
+		1:  {frames: []string{"main.main"}},
+		9:  worker(),
+		5:  worker(),
+		7:  worker(),
+		12: worker(), // more goroutines in the dump than in the profile
+	}
+
+	counts, err := applyLabels(workerProfile(), goros)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantLabels := map[int64]map[string]string{
+		1:  nil,
+		5:  {"job": "a"},
+		7:  {"job": "a"},
+		9:  {"job": "b", "user": "x"},
+		12: nil,
+	}
+	for id, want := range wantLabels {
+		if got := goros[id].labels; !reflect.DeepEqual(got, want) {
+			t.Errorf("goroutine %d: labels %v, want %v", id, got, want)
+		}
+	}
+
+	wantCounts := map[string]map[string]int{"job": {"a": 2, "b": 1}, "user": {"x": 1}}
+	if !reflect.DeepEqual(counts, wantCounts) {
+		t.Errorf("counts %v, want %v", counts, wantCounts)
+	}
+}
+
+func TestStackSignature(t *testing.T) {
+	tests := []struct {
+		name  string
+		funcs []string
+		want  string
+	}{
+		{"runtime frames skipped", []string{"runtime.gopark", "runtime.selectgo", "main.f", "main.main"}, "main.f\nmain.main"},
+		{"empty", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stackSignature(tt.funcs); got != tt.want {
+				t.Errorf("stackSignature(%q) = %q, want %q", tt.funcs, got, tt.want)
+			}
+		})
+	}
+
+	deep := make([]string, labelSigDepth+5)
+	for i := range deep {
+		deep[i] = "main.f"
+	}
+	if got := stackSignature(deep); got != stackSignature(deep[:labelSigDepth]) {
+		t.Errorf("signature of a deep stack not cut at %d frames", labelSigDepth)
+	}
+}
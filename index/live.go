@@ -0,0 +1,463 @@
+package index
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+
+	"gscrape/snapshot"
+)
+
+// Indexer keeps an open database up to date with an output directory that is
+// still being written. Each Update looks at the hosts that changed since the
+// previous one, found by the modification time of the host directory (new,
+// removed and archived snapshot files) and the size of its journal, and
+// appends their new snapshots to what is indexed. Snapshots removed by
+// retention are taken out of what is indexed the same way. Only a host that
+// got snapshots older than the ones indexed (imported from logs) is dropped
+// and indexed again whole, so its data is briefly missing while that runs.
+type Indexer struct {
+	db       *pebble.DB
+	inputDir string
+	workers  int
+	series   []string
+
+	seen  map[string]hostVersion // by host, as of its last indexing
+	hosts map[string]*hostIndex  // what is indexed of each host
+}
+
+// hostVersion tells whether a host directory changed
+type hostVersion struct {
+	modTime     time.Time
+	journalSize int64
+}
+
+// NewIndexer returns an Indexer writing to db, which should start out empty
+// (see Create)
+func NewIndexer(db *pebble.DB, inputDir string, numWorkers int, series []string) *Indexer {
+	return &Indexer{
+		db:       db,
+		inputDir: inputDir,
+		workers:  numWorkers,
+		series:   series,
+		seen:     make(map[string]hostVersion),
+		hosts:    make(map[string]*hostIndex),
+	}
+}
+
+// Update indexes the hosts that changed since the last call and returns how
+// many there were
+func (ix *Indexer) Update() (int, error) {
+	hosts, err := findHosts(ix.inputDir)
+	if err != nil {
+		return 0, err
+	}
+
+	present := make(map[string]bool, len(hosts))
+	changed := 0
+	for _, host := range hosts {
+		present[host] = true
+		version, err := ix.version(host)
+		if err != nil {
+			log.Printf("Failed to check %s: %v", host, err)
+			continue
+		}
+		if last, ok := ix.seen[host]; ok && last == version {
+			continue
+		}
+
+		log.Printf("Processing host: %s", host)
+		hi := ix.hosts[host]
+		if hi == nil {
+			hi = newHostIndex()
+		}
+		if !processHost(ix.db, ix.inputDir, host, ix.workers, ix.series, hi) {
+			log.Printf("Older snapshots of %s changed, indexing it again", host)
+			ix.dropHost(host)
+			hi = newHostIndex()
+			processHost(ix.db, ix.inputDir, host, ix.workers, ix.series, hi)
+		}
+		ix.hosts[host] = hi
+		ix.seen[host] = version
+		changed++
+	}
+	for host := range ix.seen {
+		if !present[host] {
+			log.Printf("Dropping removed host: %s", host)
+			ix.dropHost(host)
+			delete(ix.seen, host)
+			delete(ix.hosts, host)
+			changed++
+		}
+	}
+	if changed == 0 {
+		return 0, nil
+	}
+
+	hostsJSON, _ := json.Marshal(hosts)
+	if err := ix.db.Set([]byte("m:hosts"), hostsJSON, pebble.Sync); err != nil {
+		return changed, err
+	}
+	allFuncs := make(map[string]struct{})
+	for _, hi := range ix.hosts {
+		for f := range hi.funcs {
+			allFuncs[f] = struct{}{}
+		}
+	}
+	funcList := make([]string, 0, len(allFuncs))
+	for f := range allFuncs {
+		funcList = append(funcList, f)
+	}
+	sort.Strings(funcList)
+	funcsJSON, _ := json.Marshal(funcList)
+	return changed, ix.db.Set([]byte("m:funcs"), funcsJSON, pebble.Sync)
+}
+
+func (ix *Indexer) version(host string) (hostVersion, error) {
+	hostDir := filepath.Join(ix.inputDir, host)
+	info, err := os.Stat(hostDir)
+	if err != nil {
+		return hostVersion{}, err
+	}
+	v := hostVersion{modTime: info.ModTime()}
+	if journal, err := os.Stat(filepath.Join(hostDir, snapshot.JournalFile)); err == nil {
+		v.journalSize = journal.Size()
+	}
+	return v, nil
+}
+
+// dropHost deletes everything indexed for a host, including its occurrences
+// in the function index
+func (ix *Indexer) dropHost(host string) {
+	for _, prefix := range []string{"g:", "c:", "p:", "t:"} {
+		start := []byte(prefix + host + ":")
+		end := []byte(prefix + host + ";") // ':' + 1
+		if err := ix.db.DeleteRange(start, end, pebble.NoSync); err != nil {
+			log.Printf("Error dropping %s%s: %v", prefix, host, err)
+		}
+	}
+	for _, prefix := range []string{"s:", "l:", "j:", "h:", "r:"} {
+		if err := ix.db.Delete([]byte(prefix+host), pebble.NoSync); err != nil {
+			log.Printf("Error dropping %s%s: %v", prefix, host, err)
+		}
+	}
+
+	hi := ix.hosts[host]
+	if hi == nil {
+		return
+	}
+	for funcName := range hi.funcs {
+		key := []byte("f:" + funcName)
+		var existing FuncIndex
+		val, closer, err := ix.db.Get(key)
+		if err != nil {
+			continue
+		}
+		decompressJSON(val, &existing)
+		closer.Close()
+
+		kept := existing.Occurrences[:0]
+		for _, occ := range existing.Occurrences {
+			if occ.Host != host {
+				kept = append(kept, occ)
+			}
+		}
+		if len(kept) == 0 {
+			err = ix.db.Delete(key, pebble.NoSync)
+		} else if value, cerr := compressJSON(&FuncIndex{Occurrences: kept}); cerr == nil {
+			err = ix.db.Set(key, value, pebble.NoSync)
+		}
+		if err != nil {
+			log.Printf("Error updating func index: %v", err)
+		}
+	}
+}
+
+// removeSnapshots removes snapshots of a host that were indexed and are gone
+// since (retention) from everything stored about the host, so that it ends
+// up as if they had never been indexed. gone holds their prefixes.
+func removeSnapshots(db *pebble.DB, host string, hi *hostIndex, gone map[string]bool) {
+	times := make(map[int64]bool, len(gone))
+	for prefix := range gone {
+		if ts, err := snapshotTime(prefix, hi.metas[prefix]); err == nil {
+			times[ts.UnixMilli()] = true
+		}
+		delete(hi.metas, prefix)
+	}
+	for name := range hi.files {
+		if gone[snapshot.Prefix(name)] {
+			delete(hi.files, name)
+		}
+	}
+
+	var stats HostStats
+	if getCompressed(db, "s:"+host, &stats) {
+		stats.remove(times)
+		setCompressed(db, "s:"+host, &stats)
+	}
+	var labels LabelStats
+	if getCompressed(db, "l:"+host, &labels) {
+		if labels.remove(times) {
+			setCompressed(db, "l:"+host, &labels)
+		} else {
+			db.Delete([]byte("l:"+host), pebble.NoSync)
+		}
+	}
+	var runtime RuntimeStats
+	if getCompressed(db, "r:"+host, &runtime) {
+		if runtime.remove(times) {
+			setCompressed(db, "r:"+host, &runtime)
+		} else {
+			db.Delete([]byte("r:"+host), pebble.NoSync)
+		}
+	}
+	for ts := range times {
+		db.DeleteRange([]byte(fmt.Sprintf("p:%s:%d:", host, ts)), []byte(fmt.Sprintf("p:%s:%d;", host, ts)), pebble.NoSync)
+		db.Delete([]byte(fmt.Sprintf("t:%s:%d", host, ts)), pebble.NoSync)
+	}
+
+	removeGoroutineEntries(db, host, hi, times)
+	log.Printf("  Removed %d snapshots of %s", len(gone), host)
+}
+
+// removeGoroutineEntries drops the entries of the given timestamps from the
+// goroutine series of a host and updates the function and children indexes
+// of the goroutines that had any
+func removeGoroutineEntries(db *pebble.DB, host string, hi *hostIndex, times map[int64]bool) {
+	prefix := "g:" + host + ":"
+	iter, err := db.NewIter(&pebble.IterOptions{LowerBound: []byte(prefix), UpperBound: []byte("g:" + host + ";")})
+	if err != nil {
+		log.Printf("Error reading goroutines of %s: %v", host, err)
+		return
+	}
+
+	// Changed function occurrences (nil to remove) and children (nil to
+	// remove), by function and by parent goroutine
+	occs := make(map[string]map[int64]*FuncOccurrence)
+	children := make(map[int64]map[int64]*ChildInfo)
+	setOcc := func(fn string, id int64, occ *FuncOccurrence) {
+		if occs[fn] == nil {
+			occs[fn] = make(map[int64]*FuncOccurrence)
+		}
+		occs[fn][id] = occ
+	}
+	setChild := func(parent, id int64, c *ChildInfo) {
+		if children[parent] == nil {
+			children[parent] = make(map[int64]*ChildInfo)
+		}
+		children[parent][id] = c
+	}
+
+	batch := db.NewBatch()
+	for iter.First(); iter.Valid(); iter.Next() {
+		id, err := strconv.ParseInt(strings.TrimPrefix(string(iter.Key()), prefix), 10, 64)
+		if err != nil {
+			continue
+		}
+		var series GoroutineTimeSeries
+		if err := decompressJSON(iter.Value(), &series); err != nil {
+			continue
+		}
+		kept := make([]StackEntry, 0, len(series.Entries))
+		for _, e := range series.Entries {
+			if !times[e.Timestamp] {
+				kept = append(kept, e)
+			}
+		}
+		if len(kept) == len(series.Entries) {
+			continue
+		}
+
+		key := append([]byte(nil), iter.Key()...)
+		if len(kept) == 0 {
+			batch.Delete(key, nil)
+		} else if value, err := compressJSON(&GoroutineTimeSeries{Entries: kept}); err == nil {
+			batch.Set(key, value, nil)
+		}
+
+		// Functions the goroutine no longer shows lose its occurrence
+		funcs := make(map[string]bool)
+		for _, e := range kept {
+			for _, fn := range extractFuncsFromStack(e.Stack) {
+				funcs[fn] = true
+			}
+		}
+		for _, e := range series.Entries {
+			for _, fn := range extractFuncsFromStack(e.Stack) {
+				if !funcs[fn] {
+					setOcc(fn, id, nil)
+				}
+			}
+		}
+		for fn := range funcs {
+			setOcc(fn, id, &FuncOccurrence{Host: host, GoroutineID: id, FirstSeen: kept[0].Timestamp, LastSeen: kept[len(kept)-1].Timestamp})
+		}
+
+		if parent, _ := childInfo(id, series.Entries); parent != 0 {
+			setChild(parent, id, nil)
+		}
+		if parent, c := childInfo(id, kept); parent != 0 {
+			setChild(parent, id, c)
+		}
+	}
+	iter.Close()
+	if err := batch.Commit(pebble.Sync); err != nil {
+		log.Printf("Error writing goroutine series: %v", err)
+	}
+
+	for fn, changed := range occs {
+		key := "f:" + fn
+		var idx FuncIndex
+		getCompressed(db, key, &idx)
+		kept := idx.Occurrences[:0]
+		onHost := false
+		for _, occ := range idx.Occurrences {
+			if occ.Host == host {
+				if c, ok := changed[occ.GoroutineID]; ok {
+					if c == nil {
+						continue
+					}
+					occ = *c
+				}
+				onHost = true
+			}
+			kept = append(kept, occ)
+		}
+		if !onHost {
+			delete(hi.funcs, fn)
+		}
+		if len(kept) == 0 {
+			db.Delete([]byte(key), pebble.NoSync)
+		} else {
+			setCompressed(db, key, &FuncIndex{Occurrences: kept})
+		}
+	}
+
+	for parent, changed := range children {
+		key := fmt.Sprintf("c:%s:%d", host, parent)
+		var stored []ChildInfo
+		getCompressed(db, key, &stored)
+		kept := stored[:0]
+		for _, c := range stored {
+			if _, ok := changed[c.ID]; !ok {
+				kept = append(kept, c)
+			}
+		}
+		for _, c := range changed {
+			if c != nil {
+				kept = append(kept, *c)
+			}
+		}
+		if len(kept) == 0 {
+			db.Delete([]byte(key), pebble.NoSync)
+		} else {
+			setCompressed(db, key, kept)
+		}
+	}
+}
+
+// childInfo returns the parent of a goroutine and what the children index
+// holds about it, from its entries, like processHost builds it
+func childInfo(id int64, entries []StackEntry) (int64, *ChildInfo) {
+	for _, e := range entries {
+		if e.CreatedBy != 0 {
+			return e.CreatedBy, &ChildInfo{
+				ID:        id,
+				Funcs:     extractFirstTwoFuncs(e.Stack),
+				FirstSeen: entries[0].Timestamp,
+				LastSeen:  entries[len(entries)-1].Timestamp,
+			}
+		}
+	}
+	return 0, nil
+}
+
+// setCompressed stores v as gzip-compressed JSON under key
+func setCompressed(db *pebble.DB, key string, v interface{}) {
+	value, err := compressJSON(v)
+	if err == nil {
+		err = db.Set([]byte(key), value, pebble.NoSync)
+	}
+	if err != nil {
+		log.Printf("Error writing %s: %v", key, err)
+	}
+}
+
+// remove drops the snapshots taken at the given times
+func (h *HostStats) remove(times map[int64]bool) {
+	keep := keptIndexes(h.Timestamps, times)
+	h.Timestamps, h.Counts = filterAt(h.Timestamps, keep), filterAt(h.Counts, keep)
+	h.Grouped = filterAt(h.Grouped, keptIndexes(h.Grouped, times))
+	for t := range times {
+		delete(h.Profiles, t)
+		delete(h.Triggers, t)
+		delete(h.Redactions, t)
+		delete(h.Traces, t)
+	}
+}
+
+// remove drops the snapshots taken at the given times and the label values
+// only they had. It returns false if no snapshot is left.
+func (l *LabelStats) remove(times map[int64]bool) bool {
+	keep := keptIndexes(l.Timestamps, times)
+	l.Timestamps = filterAt(l.Timestamps, keep)
+	for key, values := range l.Values {
+		for value, counts := range values {
+			counts = filterAt(counts, keep)
+			values[value] = counts
+			if slices.Max(append(counts, 0)) == 0 {
+				delete(values, value)
+			}
+		}
+		if len(values) == 0 {
+			delete(l.Values, key)
+		}
+	}
+	return len(l.Timestamps) > 0
+}
+
+// remove drops the snapshots taken at the given times and the series only
+// they had. It returns false if no snapshot is left.
+func (r *RuntimeStats) remove(times map[int64]bool) bool {
+	keep := keptIndexes(r.Timestamps, times)
+	r.Timestamps = filterAt(r.Timestamps, keep)
+	for name, values := range r.Values {
+		values = filterAt(values, keep)
+		r.Values[name] = values
+		if !slices.ContainsFunc(values, func(v *float64) bool { return v != nil }) {
+			delete(r.Values, name)
+		}
+	}
+	return len(r.Timestamps) > 0
+}
+
+// keptIndexes returns the indexes of the timestamps not in times
+func keptIndexes(timestamps []int64, times map[int64]bool) []int {
+	var keep []int
+	for i, t := range timestamps {
+		if !times[t] {
+			keep = append(keep, i)
+		}
+	}
+	return keep
+}
+
+// filterAt returns the elements of s at the given indexes
+func filterAt[T any](s []T, indexes []int) []T {
+	var out []T
+	for _, i := range indexes {
+		if i < len(s) {
+			out = append(out, s[i])
+		}
+	}
+	return out
+}
@@ -0,0 +1,200 @@
+package index
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+
+	"gscrape/snapshot"
+)
+
+// testDump returns a debug=2 dump with goroutine 1 and the given workers,
+// created by goroutine 1, blocked in the given function
+func testDump(fn string, workers ...int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("goroutine 1 [running]:\nmain.main()\n\t/src/main.go:10 +0x1d\n")
+	for _, id := range workers {
+		fmt.Fprintf(&buf, "\ngoroutine %d [select]:\nmain.%s()\n\t/src/main.go:20 +0x2a\ncreated by main.main in goroutine 1\n\t/src/main.go:12 +0x3b\n", id, fn)
+	}
+	return buf.Bytes()
+}
+
+// writeTestSnapshot stores a dump the way gscrape does, as a delta against
+// base if base is not empty
+func writeTestSnapshot(t *testing.T, hostDir string, at time.Time, dump []byte, baseName string, base []byte) string {
+	t.Helper()
+	w, err := snapshot.Reserve(hostDir, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, suffix := dump, ".goroutines.txt.gz"
+	if baseName != "" {
+		data, _ = snapshot.EncodeDelta(baseName, base, dump)
+		suffix = ".goroutines.delta.gz"
+	}
+	if _, err := w.WriteGzipped(suffix, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteMeta(map[string]any{"time": at}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return w.Name()
+}
+
+// dumpIndex returns the decoded values of a host's keys, with the
+// occurrences of the function index and the children of each goroutine in
+// a stable order
+func dumpIndex(t *testing.T, db *pebble.DB) map[string]string {
+	t.Helper()
+	iter, err := db.NewIter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+
+	values := make(map[string]string)
+	for iter.First(); iter.Valid(); iter.Next() {
+		key := string(iter.Key())
+		var v any
+		switch key[:2] {
+		case "f:":
+			var idx FuncIndex
+			if err := decompressJSON(iter.Value(), &idx); err != nil {
+				t.Fatal(err)
+			}
+			sort.Slice(idx.Occurrences, func(i, j int) bool {
+				return idx.Occurrences[i].GoroutineID < idx.Occurrences[j].GoroutineID
+			})
+			v = idx
+		case "c:":
+			var children []ChildInfo
+			if err := decompressJSON(iter.Value(), &children); err != nil {
+				t.Fatal(err)
+			}
+			sort.Slice(children, func(i, j int) bool { return children[i].ID < children[j].ID })
+			v = children
+		case "m:", "h:":
+			continue
+		default:
+			if err := decompressJSON(iter.Value(), &v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		data, _ := json.Marshal(v)
+		values[key] = string(data)
+	}
+	return values
+}
+
+func TestIndexerAppendsLikeBuild(t *testing.T) {
+	base := time.Date(2026, 1, 17, 10, 0, 0, 0, time.UTC)
+	dumps := [][]byte{
+		testDump("wait", 7),
+		testDump("wait", 7, 8),
+		testDump("read", 8, 9),  // 7 exited, 8 changed stack
+		testDump("read", 8, 10), // keyframe
+		testDump("read", 10),
+	}
+	keyframe := map[int]bool{0: true, 3: true}
+
+	tests := []struct {
+		name    string
+		batches []int // snapshots written before each Update
+		removed []int // snapshots removed before the second Update
+	}{
+		{"one pass", []int{5}, nil},
+		{"delta chain continues", []int{2, 1, 2}, nil},
+		{"every snapshot", []int{1, 1, 1, 1, 1}, nil},
+		{"retention", []int{3, 2}, []int{0, 1, 2}},
+		{"retention of indexed snapshots", []int{4, 1}, []int{0, 1, 2}},
+		{"everything removed", []int{5, 0}, []int{0, 1, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inputDir := t.TempDir()
+			hostDir := filepath.Join(inputDir, "host")
+			if err := os.Mkdir(hostDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			db, err := Create(filepath.Join(t.TempDir(), "live.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			ix := NewIndexer(db, inputDir, 2, nil)
+
+			var names []string
+			var indexed *hostIndex
+			n := 0
+			for i, batch := range tt.batches {
+				for ; batch > 0; batch-- {
+					var baseName string
+					var baseDump []byte
+					if !keyframe[n] {
+						baseName, baseDump = names[n-1], dumps[n-1]
+					}
+					names = append(names, writeTestSnapshot(t, hostDir, base.Add(time.Duration(n)*time.Minute), dumps[n], baseName, baseDump))
+					n++
+				}
+				if i == 1 {
+					// Retention removes whole chains
+					for _, n := range tt.removed {
+						name := names[n]
+						matches, _ := filepath.Glob(filepath.Join(hostDir, name+".*"))
+						for _, f := range matches {
+							os.Remove(f)
+						}
+						snapshot.AppendManifest(hostDir, &snapshot.ManifestEntry{Snapshot: name, Removed: true})
+					}
+				}
+				// The directory's modification time may not tick between passes
+				ix.seen = make(map[string]hostVersion)
+				if _, err := ix.Update(); err != nil {
+					t.Fatal(err)
+				}
+				// Removed snapshots are applied without indexing the host again
+				if i == 0 {
+					indexed = ix.hosts["host"]
+				} else if ix.hosts["host"] != indexed {
+					t.Fatal("host indexed again")
+				}
+			}
+
+			dbPath := filepath.Join(t.TempDir(), "full.db")
+			if err := Build(inputDir, dbPath, 2, nil); err != nil {
+				t.Fatal(err)
+			}
+			full, err := pebble.Open(dbPath, &pebble.Options{Logger: &quietLogger{}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer full.Close()
+
+			got, want := dumpIndex(t, db), dumpIndex(t, full)
+			if len(want) == 0 {
+				t.Fatal("nothing indexed")
+			}
+			for key, v := range want {
+				if got[key] != v {
+					t.Errorf("%s: got %s, want %s", key, got[key], v)
+				}
+			}
+			for key := range got {
+				if _, ok := want[key]; !ok {
+					t.Errorf("%s: stale key", key)
+				}
+			}
+		})
+	}
+}
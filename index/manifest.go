@@ -0,0 +1,225 @@
+package index
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"gscrape/snapshot"
+)
+
+// gscrape lists every completed snapshot in <host>/manifest.jsonl, with the
+// size and checksum of each of its files. Hosts with a manifest are indexed
+// from it, so half-written snapshots are never read and damaged files are
+// reported instead of silently skipped. Hosts without one (older output,
+// hand-copied dumps) are indexed from the files on disk, and so are files
+// older than the first manifest entry, written before gscrape kept one.
+//
+// Files rolled up into an archive are found and read as if they were still
+// loose in the host directory (see snapshot.Dir).
+
+var (
+	errMissing  = errors.New("missing")
+	errCorrupt  = snapshot.ErrCorrupt
+	errUnlisted = errors.New("not in manifest")
+)
+
+// manifest is the replayed manifest of a host: the files of its live
+// snapshots by name. files is nil if the host has no manifest.
+type manifest struct {
+	files map[string]snapshot.ManifestFile
+	start time.Time // time of the first entry; unlisted files before it are legacy
+	dir   *snapshot.Dir
+}
+
+// readManifest replays a host's manifest.jsonl and reads the offset tables of
+// its archives. On error the returned manifest still reads the files on disk.
+func readManifest(hostDir string) (*manifest, error) {
+	dir, err := snapshot.OpenDir(hostDir)
+	if err != nil {
+		return &manifest{dir: dir}, err
+	}
+
+	f, err := os.Open(filepath.Join(hostDir, snapshot.ManifestName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &manifest{dir: dir}, nil
+		}
+		return &manifest{dir: dir}, err
+	}
+	defer f.Close()
+
+	live := make(map[string]*snapshot.ManifestEntry)
+	var start time.Time
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e snapshot.ManifestEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue // torn last line of a running scraper
+		}
+		if e.Removed {
+			delete(live, e.Snapshot)
+			continue
+		}
+		if start.IsZero() {
+			start = e.Time
+		}
+		live[e.Snapshot] = &e
+	}
+	if err := scanner.Err(); err != nil {
+		return &manifest{dir: dir}, err
+	}
+
+	m := &manifest{files: make(map[string]snapshot.ManifestFile), start: start, dir: dir}
+	for _, e := range live {
+		for _, file := range e.Files {
+			m.files[file.Name] = file
+		}
+	}
+	return m, nil
+}
+
+// listed reports whether the host has a manifest
+func (m *manifest) listed() bool {
+	return m != nil && m.files != nil
+}
+
+// onDisk lists the snapshot files of a host matching pattern, loose or archived
+func (m *manifest) onDisk(hostDir, pattern string) ([]string, error) {
+	names, err := m.dir.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, len(names))
+	for i, name := range names {
+		files[i] = filepath.Join(hostDir, name)
+	}
+	return files, nil
+}
+
+// glob lists the snapshot files of a host matching pattern: the completed
+// ones from the manifest, whether or not they are on disk, plus legacy files
+func (m *manifest) glob(hostDir, pattern string) ([]string, error) {
+	onDisk, err := m.onDisk(hostDir, pattern)
+	if !m.listed() || err != nil {
+		return onDisk, err
+	}
+
+	var files []string
+	for name := range m.files {
+		if ok, err := filepath.Match(pattern, name); err != nil {
+			return nil, err
+		} else if ok {
+			files = append(files, filepath.Join(hostDir, name))
+		}
+	}
+	for _, file := range onDisk {
+		if m.legacy(file) {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// covers reports whether a file belongs to a completed snapshot. Without a
+// manifest every file does.
+func (m *manifest) covers(path string) bool {
+	if !m.listed() {
+		return true
+	}
+	_, ok := m.files[filepath.Base(path)]
+	return ok || m.legacy(path)
+}
+
+// legacy reports whether an unlisted file predates the manifest
+func (m *manifest) legacy(path string) bool {
+	name := filepath.Base(path)
+	if _, ok := m.files[name]; ok {
+		return false
+	}
+	ts, err := snapshot.ParseName(snapshot.Prefix(name))
+	return err == nil && ts.Before(m.start)
+}
+
+// readFile reads a snapshot file, loose or out of its archive, and checks it
+// against the manifest
+func (m *manifest) readFile(path string) ([]byte, error) {
+	data, err := m.dir.ReadFile(filepath.Base(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", errMissing, path)
+		}
+		return nil, err
+	}
+	if !m.listed() {
+		return data, nil
+	}
+
+	want, ok := m.files[filepath.Base(path)]
+	if !ok {
+		if m.legacy(path) {
+			return data, nil
+		}
+		return nil, fmt.Errorf("%w: %s", errUnlisted, path)
+	}
+	sum := sha256.Sum256(data)
+	if int64(len(data)) != want.Size || hex.EncodeToString(sum[:]) != want.SHA256 {
+		return nil, fmt.Errorf("%w: %s: checksum mismatch (%d bytes, manifest says %d)", errCorrupt, path, len(data), want.Size)
+	}
+	return data, nil
+}
+
+// readCompressedFile reads, checks and decompresses a whole gzip or zstd
+// snapshot file
+func (m *manifest) readCompressedFile(path string) ([]byte, error) {
+	data, err := m.readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	out, err := m.dir.Decompress(filepath.Base(path), data)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s: %v", errMissing, path, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return out, nil
+}
+
+// integrityReport counts the damaged files of a host while it is indexed
+type integrityReport struct {
+	missing atomic.Int64
+	corrupt atomic.Int64
+}
+
+// record logs a failed read and counts it if the file is missing or corrupt
+func (r *integrityReport) record(err error) {
+	switch {
+	case errors.Is(err, errMissing):
+		r.missing.Add(1)
+		log.Printf("  %v", err)
+	case errors.Is(err, errCorrupt):
+		r.corrupt.Add(1)
+		log.Printf("  %v", err)
+	default:
+		log.Printf("  Failed to read: %v", err)
+	}
+}
+
+func (r *integrityReport) log(host string) {
+	if missing, corrupt := r.missing.Load(), r.corrupt.Load(); missing > 0 || corrupt > 0 {
+		log.Printf("  %d missing and %d corrupt files for %s", missing, corrupt, host)
+	}
+}
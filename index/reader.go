@@ -0,0 +1,187 @@
+package index
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/cockroachdb/pebble"
+
+	"gscrape/snapshot"
+)
+
+// ErrNotFound is returned by Reader for data the database doesn't have
+var ErrNotFound = errors.New("not found")
+
+// Reader reads an index database: what Build or an Indexer wrote, decoded
+// into the types they wrote it from
+type Reader struct {
+	db *pebble.DB
+}
+
+// Open opens the database at dbPath read-only
+func Open(dbPath string) (*Reader, error) {
+	db, err := pebble.Open(dbPath, &pebble.Options{ReadOnly: true, Logger: &quietLogger{}})
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{db: db}, nil
+}
+
+// NewReader returns a Reader of an open database, e.g. one an Indexer is
+// still writing
+func NewReader(db *pebble.DB) *Reader {
+	return &Reader{db: db}
+}
+
+// Close closes the database
+func (r *Reader) Close() error {
+	return r.db.Close()
+}
+
+// get returns a copy of the value of key
+func (r *Reader) get(key string) ([]byte, error) {
+	val, closer, err := r.db.Get([]byte(key))
+	if errors.Is(err, pebble.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	return bytes.Clone(val), nil
+}
+
+// getJSON decodes the plain JSON value of key into v
+func (r *Reader) getJSON(key string, v interface{}) error {
+	val, err := r.get(key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(val, v)
+}
+
+// getCompressed decodes the gzip-compressed JSON value of key into v
+func (r *Reader) getCompressed(key string, v interface{}) error {
+	val, err := r.get(key)
+	if err != nil {
+		return err
+	}
+	return decompressJSON(val, v)
+}
+
+// Hosts returns all indexed hosts
+func (r *Reader) Hosts() ([]string, error) {
+	var hosts []string
+	err := r.getJSON("m:hosts", &hosts)
+	return hosts, err
+}
+
+// Funcs returns all indexed function names, sorted
+func (r *Reader) Funcs() ([]string, error) {
+	var funcs []string
+	err := r.getJSON("m:funcs", &funcs)
+	return funcs, err
+}
+
+// HostInfo returns the alias, URL and labels of a host's target
+func (r *Reader) HostInfo(host string) (*snapshot.HostInfo, error) {
+	var info snapshot.HostInfo
+	return &info, r.getJSON("h:"+host, &info)
+}
+
+// Stats returns a host's snapshots with their goroutine counts
+func (r *Reader) Stats(host string) (*HostStats, error) {
+	var stats HostStats
+	return &stats, r.getCompressed("s:"+host, &stats)
+}
+
+// Labels returns a host's goroutine counts per pprof label value
+func (r *Reader) Labels(host string) (*LabelStats, error) {
+	var stats LabelStats
+	return &stats, r.getCompressed("l:"+host, &stats)
+}
+
+// Runtime returns a host's runtime metric series
+func (r *Reader) Runtime(host string) (*RuntimeStats, error) {
+	var stats RuntimeStats
+	return &stats, r.getCompressed("r:"+host, &stats)
+}
+
+// Journal returns the summary of a host's scrape journal
+func (r *Reader) Journal(host string) (*JournalStats, error) {
+	var stats JournalStats
+	return &stats, r.getCompressed("j:"+host, &stats)
+}
+
+// Goroutine returns the time series of one goroutine of a host
+func (r *Reader) Goroutine(host string, id int64) (*GoroutineTimeSeries, error) {
+	var series GoroutineTimeSeries
+	return &series, r.getCompressed(fmt.Sprintf("g:%s:%d", host, id), &series)
+}
+
+// Goroutines calls fn with every goroutine of a host, in the order of their
+// IDs as text, until fn returns false. Series that fail to decode are skipped.
+func (r *Reader) Goroutines(host string, fn func(id int64, series *GoroutineTimeSeries) bool) error {
+	prefix := fmt.Sprintf("g:%s:", host)
+	iter, err := r.db.NewIter(&pebble.IterOptions{
+		LowerBound: []byte(prefix),
+		UpperBound: []byte(prefix + "\xff"),
+	})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		id, err := strconv.ParseInt(string(iter.Key()[len(prefix):]), 10, 64)
+		if err != nil {
+			continue
+		}
+		var series GoroutineTimeSeries
+		if err := decompressJSON(iter.Value(), &series); err != nil {
+			continue
+		}
+		if !fn(id, &series) {
+			break
+		}
+	}
+	return iter.Error()
+}
+
+// Children returns the goroutines a goroutine of a host created
+func (r *Reader) Children(host string, id int64) ([]ChildInfo, error) {
+	var children []ChildInfo
+	err := r.getCompressed(fmt.Sprintf("c:%s:%d", host, id), &children)
+	return children, err
+}
+
+// FuncIndex returns the goroutines a function was seen in
+func (r *Reader) FuncIndex(funcName string) (*FuncIndex, error) {
+	var idx FuncIndex
+	return &idx, r.getCompressed("f:"+funcName, &idx)
+}
+
+// Profile returns an extra pprof profile of a snapshot, gzipped protobuf as
+// scraped
+func (r *Reader) Profile(host string, ts int64, name string) ([]byte, error) {
+	return r.get(fmt.Sprintf("p:%s:%d:%s", host, ts, name))
+}
+
+// Trace returns the execution trace captured with a snapshot, decompressed
+// for `go tool trace`
+func (r *Reader) Trace(host string, ts int64) (io.ReadCloser, error) {
+	val, err := r.get(fmt.Sprintf("t:%s:%d", host, ts))
+	if err != nil {
+		return nil, err
+	}
+	gr, err := gzip.NewReader(bytes.NewReader(val))
+	if err != nil {
+		return nil, fmt.Errorf("corrupt trace: %w", err)
+	}
+	return gr, nil
+}
@@ -0,0 +1,182 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/pebble"
+
+	"gscrape/snapshot"
+)
+
+// gscrape stores the runtime metrics of a target next to its dumps, as
+// <timestamp>.vars.json.gz (expvar) or <timestamp>.metrics.txt.gz
+// (Prometheus text format). Only the selected series are indexed:
+// dotted names are paths into the expvar JSON (memstats.HeapAlloc), others
+// are Prometheus metric names, whose samples are summed over their labels.
+
+// DefaultSeries are the runtime metric series indexed unless told otherwise
+var DefaultSeries = []string{
+	"memstats.HeapAlloc", "memstats.HeapInuse", "memstats.Sys", "memstats.NextGC",
+	"memstats.NumGC", "memstats.PauseTotalNs", "memstats.GCCPUFraction",
+	"go_memstats_heap_alloc_bytes", "go_memstats_heap_inuse_bytes", "go_memstats_sys_bytes", "go_memstats_next_gc_bytes",
+	"go_gc_duration_seconds_count", "go_gc_duration_seconds_sum", "go_threads",
+	"process_cpu_seconds_total", "process_resident_memory_bytes",
+}
+
+// RuntimeStats holds the selected runtime metric series of a host. A series
+// has null where a snapshot's metrics lack it.
+type RuntimeStats struct {
+	Timestamps []int64               `json:"t"`
+	Values     map[string][]*float64 `json:"v"` // series -> values aligned with Timestamps
+}
+
+// indexRuntimeMetrics reads the given runtime metrics files of a host and
+// stores the selected series, appended to the stored ones if appending
+func indexRuntimeMetrics(db *pebble.DB, host string, files []string, series []string, metas map[string]*snapshot.Meta, m *manifest, report *integrityReport, appending bool) {
+	if len(series) == 0 || len(files) == 0 {
+		return
+	}
+	sort.Strings(files)
+
+	stats := RuntimeStats{Values: make(map[string][]*float64)}
+	if appending {
+		getCompressed(db, "r:"+host, &stats)
+		if stats.Values == nil {
+			stats.Values = make(map[string][]*float64)
+		}
+	}
+	for _, file := range files {
+		prefix, kind, _ := strings.Cut(filepath.Base(file), ".")
+		ts, err := snapshotTime(prefix, metas[prefix])
+		if err != nil {
+			log.Printf("Failed to parse timestamp from %s: %v", file, err)
+			continue
+		}
+		data, err := m.readCompressedFile(file)
+		if err != nil {
+			report.record(err)
+			continue
+		}
+
+		var values map[string]float64
+		if kind == "vars.json.gz" {
+			values, err = expvarValues(data, series)
+		} else {
+			values, err = prometheusValues(data, series)
+		}
+		if err != nil {
+			log.Printf("Failed to parse %s: %v", file, err)
+			continue
+		}
+		stats.add(ts.UnixMilli(), values)
+	}
+	if len(stats.Timestamps) == 0 {
+		return
+	}
+
+	if value, err := compressJSON(&stats); err == nil {
+		if err := db.Set([]byte("r:"+host), value, pebble.NoSync); err != nil {
+			log.Printf("Error writing runtime metrics: %v", err)
+		}
+	}
+	log.Printf("  Indexed %d runtime metric series over %d snapshots for %s", len(stats.Values), len(stats.Timestamps), host)
+}
+
+// add appends one snapshot's values, padding series that are missing from
+// either side with nulls
+func (r *RuntimeStats) add(ts int64, values map[string]float64) {
+	n := len(r.Timestamps)
+	r.Timestamps = append(r.Timestamps, ts)
+	for name, v := range values {
+		if _, ok := r.Values[name]; !ok {
+			r.Values[name] = make([]*float64, n)
+		}
+		r.Values[name] = append(r.Values[name], &v)
+	}
+	for name, vs := range r.Values {
+		if len(vs) == n {
+			r.Values[name] = append(vs, nil)
+		}
+	}
+}
+
+// expvarValues picks the dotted-path series out of a /debug/vars response
+func expvarValues(data []byte, series []string) (map[string]float64, error) {
+	var vars map[string]any
+	if err := json.Unmarshal(data, &vars); err != nil {
+		return nil, err
+	}
+	values := make(map[string]float64)
+	for _, name := range series {
+		if !strings.Contains(name, ".") {
+			continue
+		}
+		var v any = vars
+		for _, key := range strings.Split(name, ".") {
+			obj, ok := v.(map[string]any)
+			if !ok {
+				v = nil
+				break
+			}
+			v = obj[key]
+		}
+		if f, ok := v.(float64); ok {
+			values[name] = f
+		}
+	}
+	return values, nil
+}
+
+// prometheusValues picks the named series out of a /metrics response in the
+// Prometheus text format, summing the samples of each over their labels
+func prometheusValues(data []byte, series []string) (map[string]float64, error) {
+	wanted := make(map[string]bool)
+	for _, name := range series {
+		if !strings.Contains(name, ".") {
+			wanted[name] = true
+		}
+	}
+	values := make(map[string]float64)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		// name{labels} value [timestamp]
+		name, rest := line, ""
+		if i := strings.IndexAny(line, "{ \t"); i >= 0 {
+			name, rest = line[:i], line[i:]
+		}
+		if !wanted[name] {
+			continue
+		}
+		if strings.HasPrefix(rest, "{") {
+			end := strings.LastIndexByte(rest, '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated labels: %s", line)
+			}
+			rest = rest[end+1:]
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("sample without value: %s", line)
+		}
+		v, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		values[name] += v
+	}
+	return values, scanner.Err()
+}
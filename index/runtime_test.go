@@ -0,0 +1,97 @@
+package index
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPrometheusValues(t *testing.T) {
+	data := []byte(`# HELP go_gc_duration_seconds A summary of the pause duration of garbage collection cycles.
+# TYPE go_gc_duration_seconds summary
+go_gc_duration_seconds{quantile="0.5"} 0.0001
+go_gc_duration_seconds_sum 0.25
+go_gc_duration_seconds_count 42
+# TYPE go_memstats_heap_alloc_bytes gauge
+go_memstats_heap_alloc_bytes 1.048576e+06
+# TYPE process_cpu_seconds_total counter
+process_cpu_seconds_total{mode="user"} 10.5
+process_cpu_seconds_total{mode="system"} 2.5 1768651200000
+go_threads NaN
+http_requests_total{path="/a"} 7
+`)
+	series := []string{
+		"go_gc_duration_seconds_count", "go_gc_duration_seconds_sum", // summary counters
+		"go_memstats_heap_alloc_bytes",  // gauge
+		"process_cpu_seconds_total",     // counter summed over its labels
+		"go_threads",                    // NaN is no value
+		"process_resident_memory_bytes", // missing
+		"memstats.HeapAlloc",            // expvar only
+	}
+	want := map[string]float64{
+		"go_gc_duration_seconds_count": 42,
+		"go_gc_duration_seconds_sum":   0.25,
+		"go_memstats_heap_alloc_bytes": 1 << 20,
+		"process_cpu_seconds_total":    13,
+	}
+
+	got, err := prometheusValues(data, series)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := prometheusValues([]byte("go_threads{a=\"b\" 5\n"), series); err == nil {
+		t.Error("parsed unterminated labels")
+	}
+}
+
+func TestExpvarValues(t *testing.T) {
+	data := []byte(`{"cmdline": ["app"], "memstats": {"HeapAlloc": 1024, "NumGC": 3, "PauseNs": [1, 2], "BySize": {"Size": "x"}}, "requests": 5}`)
+	series := []string{
+		"memstats.HeapAlloc", "memstats.NumGC",
+		"memstats.PauseNs",      // not a number
+		"memstats.Sys",          // missing
+		"cmdline.x",             // not an object
+		"go_memstats_sys_bytes", // Prometheus only
+	}
+	want := map[string]float64{"memstats.HeapAlloc": 1024, "memstats.NumGC": 3}
+
+	got, err := expvarValues(data, series)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := expvarValues([]byte("{"), series); err == nil {
+		t.Error("parsed truncated JSON")
+	}
+}
+
+func TestRuntimeStatsAdd(t *testing.T) {
+	r := RuntimeStats{Values: make(map[string][]*float64)}
+	r.add(1, map[string]float64{"a": 1})
+	r.add(2, map[string]float64{"b": 2})
+	r.add(3, map[string]float64{"a": 3, "b": 4})
+
+	// values returns the series as numbers, -1 for null
+	values := func(vs []*float64) []float64 {
+		out := make([]float64, len(vs))
+		for i, v := range vs {
+			out[i] = -1
+			if v != nil {
+				out[i] = *v
+			}
+		}
+		return out
+	}
+	if got := values(r.Values["a"]); !reflect.DeepEqual(got, []float64{1, -1, 3}) {
+		t.Errorf("a = %v", got)
+	}
+	if got := values(r.Values["b"]); !reflect.DeepEqual(got, []float64{-1, 2, 4}) {
+		t.Errorf("b = %v", got)
+	}
+}
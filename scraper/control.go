@@ -0,0 +1,273 @@
+package scraper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TargetStatus is the state of a target as reported by the control API
+type TargetStatus struct {
+	URL         string            `json:"url"`
+	Alias       string            `json:"alias,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Interval    string            `json:"interval"`         // current, including bursts and adaptation
+	Pinned      bool              `json:"pinned,omitempty"` // interval set through the API, budgets don't adapt it
+	Paused      bool              `json:"paused"`
+	Running     bool              `json:"running"` // a scrape is in progress
+	Burst       string            `json:"burst,omitempty"`
+	Next        time.Time         `json:"next"`
+	LastAttempt *time.Time        `json:"last_attempt,omitempty"`
+	LastSuccess *time.Time        `json:"last_success,omitempty"`
+	LastError   string            `json:"last_error,omitempty"`
+	Goroutines  int               `json:"goroutines,omitempty"` // in the last dump
+	Missed      int               `json:"missed"`               // scrapes skipped while the previous one ran
+}
+
+// recordAttempt keeps the outcome of the last scrape for the status. Like
+// gscrape_last_success_timestamp_seconds, a partial dump is not a success.
+func (st *targetState) recordAttempt(e *JournalEntry) {
+	st.last = e
+	if e.Error == "" && e.Skipped == "" && e.Partial == "" {
+		st.lastSuccess = e.Start
+	}
+}
+
+// publish copies the loop-owned state into the status read by the API
+func (st *targetState) publish() {
+	status := TargetStatus{
+		URL:      st.target.URL,
+		Alias:    st.target.Alias,
+		Labels:   st.target.Labels,
+		Interval: st.currentInterval().String(),
+		Pinned:   st.pinned,
+		Paused:   st.paused,
+		Running:  st.running,
+		Burst:    st.burstReason,
+		Next:     st.next,
+		Missed:   st.totalMissed,
+	}
+	if st.last != nil {
+		start := st.last.Start
+		status.LastAttempt = &start
+		status.LastError = st.last.Error
+		switch {
+		case st.last.Skipped != "":
+			status.LastError = "skipped: " + st.last.Skipped
+		case st.last.Error == "" && st.last.Partial != "":
+			status.LastError = "partial dump: " + st.last.Partial
+		}
+	}
+	if !st.lastSuccess.IsZero() {
+		success := st.lastSuccess
+		status.LastSuccess = &success
+	}
+	if st.lastCount > 0 {
+		status.Goroutines = st.lastCount
+	}
+
+	st.statusMu.Lock()
+	st.status = status
+	st.statusMu.Unlock()
+}
+
+// Status returns the last published status
+func (st *targetState) Status() TargetStatus {
+	st.statusMu.Lock()
+	defer st.statusMu.Unlock()
+	return st.status
+}
+
+// send queues a command for the target's loop
+func (st *targetState) send(cmd func(*targetState)) bool {
+	select {
+	case st.cmds <- cmd:
+		return true
+	default:
+		return false // loop is busy and the queue is full
+	}
+}
+
+// ServeControl runs the control API until ctx is cancelled:
+//
+//	GET    /targets                           list targets with status
+//	POST   /targets                           add a target (Target JSON body)
+//	DELETE /targets?url=<url>                 remove a target
+//	POST   /targets/interval?url=<url>&d=30s  pin the interval, d=auto unpins
+//	POST   /targets/pause?url=<url>           stop scraping, keep the target
+//	POST   /targets/resume?url=<url>          resume and scrape right away
+//	POST   /targets/scrape?url=<url>          one-off scrape now
+//
+// Commands are applied by the target's own loop, after any scrape in progress,
+// and answered with 202 Accepted.
+//
+// With tokens (token -> client name, see LoadTokens), requests need one of
+// them as bearer token. Without, addr must be a loopback address.
+func (s *Scraper) ServeControl(ctx context.Context, addr string, tokens map[string]string) error {
+	if len(tokens) == 0 && !isLoopback(addr) {
+		return fmt.Errorf("%s is not a loopback address, the control API needs tokens to listen on it", addr)
+	}
+
+	srv := &http.Server{Addr: addr, Handler: s.controlHandler(tokens)}
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Control API on %s", addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	// Shutdown returns once the requests in flight are done
+	<-shutdown
+	return nil
+}
+
+// controlHandler routes the control API, behind bearer authentication if
+// there are tokens
+func (s *Scraper) controlHandler(tokens map[string]string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/targets", s.handleTargets)
+	mux.HandleFunc("/targets/", s.handleTargetCommand)
+	if len(tokens) == 0 {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := bearerSender(r, tokens); !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// isLoopback reports whether the listen address addr only accepts local
+// connections
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (s *Scraper) handleTargets(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.targetsMu.Lock()
+		statuses := make([]TargetStatus, 0, len(s.targets))
+		for _, st := range s.targets {
+			statuses = append(statuses, st.Status())
+		}
+		s.targetsMu.Unlock()
+		sort.Slice(statuses, func(i, j int) bool { return statuses[i].URL < statuses[j].URL })
+		writeJSON(w, http.StatusOK, statuses)
+
+	case http.MethodPost:
+		var t Target
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&t); err != nil {
+			http.Error(w, "invalid target: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		st, err := s.AddTarget(&t)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("[control] added target %s", t.URL)
+		writeJSON(w, http.StatusCreated, st.Status())
+
+	case http.MethodDelete:
+		u := r.URL.Query().Get("url")
+		if !s.RemoveTarget(u) {
+			http.Error(w, "unknown target", http.StatusNotFound)
+			return
+		}
+		log.Printf("[control] removed target %s", u)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Scraper) handleTargetCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	u := r.URL.Query().Get("url")
+	st := s.target(u)
+	if st == nil {
+		http.Error(w, "unknown target", http.StatusNotFound)
+		return
+	}
+
+	var cmd func(*targetState)
+	action := strings.TrimPrefix(r.URL.Path, "/targets/")
+	switch action {
+	case "interval":
+		if r.URL.Query().Get("d") == "auto" {
+			// Back to the configured interval, adapted again from there
+			cmd = func(st *targetState) {
+				st.pinned = false
+				st.interval = s.configuredInterval(st.target)
+			}
+			break
+		}
+		d, err := time.ParseDuration(r.URL.Query().Get("d"))
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid interval d", http.StatusBadRequest)
+			return
+		}
+		cmd = func(st *targetState) {
+			// Budgets would override it with the next scrape
+			st.interval = d
+			st.pinned = true
+			if next := time.Now().Add(d); next.Before(st.next) {
+				st.next = next
+			}
+		}
+	case "pause":
+		cmd = func(st *targetState) { st.paused = true }
+	case "resume":
+		cmd = func(st *targetState) {
+			st.paused = false
+			st.next = time.Now()
+		}
+	case "scrape":
+		cmd = func(st *targetState) { st.scrapeNow = true }
+	default:
+		http.Error(w, "unknown command", http.StatusNotFound)
+		return
+	}
+
+	if !st.send(cmd) {
+		http.Error(w, "target busy, try again", http.StatusServiceUnavailable)
+		return
+	}
+	log.Printf("[control] %s %s", action, u)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,153 @@
+package scraper
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runningScraper returns a scraper that accepts targets, as if Run had
+// started with none
+func runningScraper(t *testing.T) *Scraper {
+	t.Helper()
+	s := New(Options{OutDir: t.TempDir(), Interval: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	s.runCtx = ctx
+	s.targets = make(map[string]*targetState)
+	t.Cleanup(func() {
+		cancel()
+		s.targetsWg.Wait()
+	})
+	return s
+}
+
+// controlRequest sends a request to the control API and returns the response
+func controlRequest(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return w
+}
+
+func TestControlTargets(t *testing.T) {
+	h := runningScraper(t).controlHandler(nil)
+	const target = "http://127.0.0.1:1/debug/pprof/goroutine"
+	q := "?url=" + url.QueryEscape(target)
+
+	// In order: each step sees the targets left by the ones before
+	steps := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"add", http.MethodPost, "/targets", `{"url":"` + target + `","interval":"1h"}`, http.StatusCreated},
+		{"duplicate", http.MethodPost, "/targets", `{"url":"` + target + `","interval":"1m"}`, http.StatusBadRequest},
+		{"same directory", http.MethodPost, "/targets", `{"url":"http://127.0.0.1:1/debug/pprof/other"}`, http.StatusBadRequest},
+		{"invalid body", http.MethodPost, "/targets", `{"url":`, http.StatusBadRequest},
+		{"list", http.MethodGet, "/targets", "", http.StatusOK},
+		{"interval", http.MethodPost, "/targets/interval" + q + "&d=5s", "", http.StatusAccepted},
+		{"invalid interval", http.MethodPost, "/targets/interval" + q + "&d=-5s", "", http.StatusBadRequest},
+		{"unknown command", http.MethodPost, "/targets/restart" + q, "", http.StatusNotFound},
+		{"command on unknown target", http.MethodPost, "/targets/pause?url=http://10.0.0.1:6060", "", http.StatusNotFound},
+		{"remove unknown target", http.MethodDelete, "/targets?url=http://10.0.0.1:6060", "", http.StatusNotFound},
+		{"remove", http.MethodDelete, "/targets" + q, "", http.StatusNoContent},
+		{"removed", http.MethodPost, "/targets/scrape" + q, "", http.StatusNotFound},
+		{"add again", http.MethodPost, "/targets", `{"url":"` + target + `","interval":"1h"}`, http.StatusCreated},
+	}
+	for _, tt := range steps {
+		if w := controlRequest(h, tt.method, tt.path, tt.body); w.Code != tt.status {
+			t.Fatalf("%s: status %d, want %d: %s", tt.name, w.Code, tt.status, w.Body)
+		}
+	}
+}
+
+func TestControlAuth(t *testing.T) {
+	s := runningScraper(t)
+	if err := s.ServeControl(context.Background(), "0.0.0.0:0", nil); err == nil {
+		t.Error("served on a non-loopback address without tokens")
+	}
+
+	h := s.controlHandler(map[string]string{"secret": "ops"})
+	tests := []struct {
+		auth   string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/targets", nil)
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%q: status %d, want %d", tt.auth, w.Code, tt.status)
+		}
+	}
+}
+
+func TestControlBusy(t *testing.T) {
+	s := runningScraper(t)
+	const target = "http://10.0.0.1:6060/debug/pprof/goroutine"
+	// No loop draining the commands
+	st := s.newTargetState(&Target{URL: target})
+	s.targets[target] = st
+	h := s.controlHandler(nil)
+
+	for i := 0; i < cap(st.cmds); i++ {
+		if w := controlRequest(h, http.MethodPost, "/targets/scrape?url="+target, ""); w.Code != http.StatusAccepted {
+			t.Fatalf("command %d: status %d, want 202", i+1, w.Code)
+		}
+	}
+	if w := controlRequest(h, http.MethodPost, "/targets/scrape?url="+target, ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("full queue: status %d, want 503", w.Code)
+	}
+}
+
+func TestControlIntervalPinned(t *testing.T) {
+	s := runningScraper(t)
+	const target = "http://10.0.0.1:6060/debug/pprof/goroutine"
+	st := s.newTargetState(&Target{URL: target, Interval: Duration(time.Minute), BudgetMBPerHour: 1})
+	s.targets[target] = st
+	h := s.controlHandler(nil)
+
+	// 10 MB per scrape: the budget asks for 10h, capped at 1h
+	stats := &HostStats{}
+	stats.Record(10<<20, time.Second)
+
+	// command sends one to the target and applies it like its loop would
+	command := func(path string) {
+		t.Helper()
+		if w := controlRequest(h, http.MethodPost, path, ""); w.Code != http.StatusAccepted {
+			t.Fatalf("%s: status %d, want 202", path, w.Code)
+		}
+		(<-st.cmds)(st)
+		st.publish()
+	}
+
+	command("/targets/interval?url=" + target + "&d=5s")
+	s.adaptInterval(st, stats)
+	if st.interval != 5*time.Second || !st.Status().Pinned {
+		t.Errorf("pinned interval %s adapted to %s", 5*time.Second, st.interval)
+	}
+	if st.target.Interval != Duration(time.Minute) {
+		t.Errorf("configured interval changed to %s", time.Duration(st.target.Interval))
+	}
+
+	command("/targets/interval?url=" + target + "&d=auto")
+	if st.interval != time.Minute {
+		t.Errorf("unpinned interval %s, want the configured %s", st.interval, time.Minute)
+	}
+	s.adaptInterval(st, stats)
+	if st.interval != defaultMaxInterval {
+		t.Errorf("unpinned interval adapted to %s, want %s", st.interval, defaultMaxInterval)
+	}
+}
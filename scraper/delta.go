@@ -0,0 +1,49 @@
+package scraper
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"gscrape/snapshot"
+)
+
+// Dumps between keyframes are stored as deltas against the previous
+// snapshot of the target, see snapshot.EncodeDelta.
+
+// keyframeDue reports whether the next dump of a target in hostDir must be
+// stored in full: deltas are disabled, there is no base, the chain is long
+// enough, or its base is gone from disk (removed by retention)
+func (st *targetState) keyframeDue(hostDir string) bool {
+	every := st.target.KeyframeEvery
+	if every <= 1 || st.deltaBase == nil || st.sinceKeyframe+1 >= every {
+		return true
+	}
+	for _, suffix := range snapshot.DumpSuffixes {
+		if _, err := os.Stat(filepath.Join(hostDir, st.deltaBaseName+suffix)); err == nil {
+			return false
+		}
+	}
+	// Rolled up with its keyframe's hour
+	dir, _ := snapshot.OpenDir(hostDir)
+	if _, err := dir.DumpFile(st.deltaBaseName); err == nil {
+		return false
+	}
+	log.Printf("[%s] delta base %s is gone, storing a keyframe", st.target.displayName(), st.deltaBaseName)
+	return true
+}
+
+// recordDumpStored remembers a stored debug=2 dump as the base of the next delta
+func (st *targetState) recordDumpStored(name string, dump []byte, keyframe bool) {
+	if st.target.KeyframeEvery <= 1 {
+		return
+	}
+	if keyframe {
+		st.keyframe = name
+		st.sinceKeyframe = 0
+	} else {
+		st.sinceKeyframe++
+	}
+	st.deltaBase = dump
+	st.deltaBaseName = name
+}
@@ -0,0 +1,98 @@
+package scraper
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// costProbeEvery is how many rounds a skipped target waits before it is
+// probed again with a debug=1 dump, so its cost estimate can recover
+const costProbeEvery = 10
+
+// dumpCost is what a debug=2 dump is expected to cost the target: response
+// size and latency scale with the goroutine count, and the latency is roughly
+// how long the world is stopped.
+type dumpCost struct {
+	bytesPerGoroutine   float64
+	latencyPerGoroutine time.Duration
+	debug1Latency       time.Duration // last debug=1 dump, 0 if none yet
+}
+
+// observe updates the per-goroutine cost from a finished dump
+func (c *dumpCost) observe(debug int, size int, latency time.Duration, goroutines int) {
+	if debug == 1 {
+		c.debug1Latency = latency
+		return
+	}
+	if goroutines > 0 {
+		c.bytesPerGoroutine = float64(size) / float64(goroutines)
+		c.latencyPerGoroutine = latency / time.Duration(goroutines)
+	}
+}
+
+// chooseDump picks the dump mode for the next scrape of a target: 2 (full),
+// 1 (grouped counts) or 0 (skip). reason explains a downgrade or skip.
+func (s *Scraper) chooseDump(st *targetState) (debug int, reason string) {
+	t := st.target
+	if t.MaxDumpMB <= 0 && t.MaxDumpLatency <= 0 {
+		return 2, ""
+	}
+	if st.lastCount <= 0 || st.cost.bytesPerGoroutine == 0 {
+		return 2, "" // nothing to estimate from yet
+	}
+
+	estBytes := st.cost.bytesPerGoroutine * float64(st.lastCount)
+	estLatency := st.cost.latencyPerGoroutine * time.Duration(st.lastCount)
+
+	switch {
+	case t.MaxDumpMB > 0 && estBytes > t.MaxDumpMB*1024*1024:
+		reason = fmt.Sprintf("estimated debug=2 dump %.3f MB above %.3f MB", estBytes/1024/1024, t.MaxDumpMB)
+	case t.MaxDumpLatency > 0 && estLatency > time.Duration(t.MaxDumpLatency):
+		reason = fmt.Sprintf("estimated debug=2 dump %s above %s", estLatency.Round(time.Millisecond), time.Duration(t.MaxDumpLatency))
+	default:
+		st.skipped = 0
+		return 2, ""
+	}
+
+	// Even the grouped dump is too slow: skip, probing again now and then
+	if t.MaxDumpLatency > 0 && st.cost.debug1Latency > time.Duration(t.MaxDumpLatency) {
+		if st.skipped < costProbeEvery {
+			st.skipped++
+			return 0, fmt.Sprintf("last debug=1 dump took %s, above %s", st.cost.debug1Latency.Round(time.Millisecond), time.Duration(t.MaxDumpLatency))
+		}
+		st.skipped = 0
+	}
+	return 1, reason
+}
+
+// goroutineURL builds the goroutine dump URL for an endpoint. The endpoint may
+// be a bare base URL or point at the goroutine handler.
+func goroutineURL(endpoint string, debug int) string {
+	if !strings.Contains(endpoint, "/debug/pprof/goroutine") {
+		return strings.TrimSuffix(endpoint, "/") + "/debug/pprof/goroutine?debug=" + strconv.Itoa(debug)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint
+	}
+	q := u.Query()
+	q.Set("debug", strconv.Itoa(debug))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// countGroupedGoroutines reads the total from a debug=1 dump header
+// ("goroutine profile: total 1234")
+func countGroupedGoroutines(dump []byte) int {
+	line, _, _ := bytes.Cut(dump, []byte("\n"))
+	total, ok := bytes.CutPrefix(line, []byte("goroutine profile: total "))
+	if !ok {
+		return 0
+	}
+	n, _ := strconv.Atoi(string(bytes.TrimSpace(total)))
+	return n
+}
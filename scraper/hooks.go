@@ -0,0 +1,28 @@
+package scraper
+
+// Hooks let a program embedding the Scraper follow what it stores, e.g. to
+// index snapshots as they arrive or alert on failures. Any hook may be nil.
+// They are called on the goroutine that scraped the target or handled the
+// push, so a slow hook delays the next scrape of that target.
+type Hooks struct {
+	// Attempt is called after every scrape attempt with its journal entry,
+	// as appended to the host's journal.jsonl
+	Attempt func(host string, entry *JournalEntry)
+
+	// Snapshot is called once a scraped or pushed snapshot is committed, with
+	// the path of its dump and its metadata. Trigger and Trace in the metadata
+	// tell whether a trigger fired.
+	Snapshot func(host, dumpPath string, meta *SnapshotMeta)
+}
+
+func (h *Hooks) attempt(host string, entry *JournalEntry) {
+	if h.Attempt != nil {
+		h.Attempt(host, entry)
+	}
+}
+
+func (h *Hooks) snapshot(host, dumpPath string, meta *SnapshotMeta) {
+	if h.Snapshot != nil {
+		h.Snapshot(host, dumpPath, meta)
+	}
+}
@@ -0,0 +1,84 @@
+package scraper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gscrape/snapshot"
+)
+
+// The data of a target lives in output/<dir>/, where dir is the target's alias
+// or else its host:port with colons replaced. The directory also holds
+// host.json with the alias, URL and labels of the target, which gindex keeps
+// as the host's metadata.
+const hostInfoFile = snapshot.HostInfoFile
+
+// HostInfo is the layout of host.json, see snapshot.HostInfo
+type HostInfo = snapshot.HostInfo
+
+// targetDir returns the name of a target's output directory
+func targetDir(t *Target) (string, error) {
+	if t.Alias != "" {
+		return pushHostDir(t.Alias)
+	}
+	parsed, err := url.Parse(t.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid url: %w", err)
+	}
+	return sanitizeHost(parsed.Host), nil
+}
+
+// displayName is how a target appears in logs: its alias, or else its host:port
+func (t *Target) displayName() string {
+	if t.Alias != "" {
+		return t.Alias
+	}
+	if parsed, err := url.Parse(t.URL); err == nil {
+		return parsed.Host
+	}
+	return t.URL
+}
+
+// parseEndpoint splits a command line endpoint of the form [alias=]url
+func parseEndpoint(s string) (alias, endpoint string) {
+	if alias, endpoint, ok := strings.Cut(s, "="); ok && !strings.ContainsAny(alias, ":/?") {
+		return alias, endpoint
+	}
+	return "", s
+}
+
+// writeHostInfo records the identity of a target or pushed host in its
+// output directory, unless host.json already holds it
+func (s *Scraper) writeHostInfo(dir string, info *HostInfo) error {
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	path := filepath.Join(s.outDir, dir)
+	if stored, err := os.ReadFile(filepath.Join(path, hostInfoFile)); err == nil && bytes.Equal(stored, data) {
+		return nil
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	return snapshot.WriteFileAtomic(filepath.Join(path, "."+hostInfoFile+".tmp"), filepath.Join(path, hostInfoFile), data)
+}
+
+// scrapesInto reports whether a running target writes to the output
+// directory dir
+func (s *Scraper) scrapesInto(dir string) bool {
+	s.targetsMu.Lock()
+	defer s.targetsMu.Unlock()
+	for _, st := range s.targets {
+		if st.hostDir == dir {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package scraper
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gscrape/snapshot"
+)
+
+// journalFile is appended to in every host directory, one JSON line per scrape attempt
+const journalFile = snapshot.JournalFile
+
+// JournalEntry records one scrape attempt, successful or not, see
+// snapshot.JournalEntry
+type JournalEntry = snapshot.JournalEntry
+
+// journalMu serializes journal appends and trimming within the process
+var journalMu sync.Mutex
+
+// appendJournal appends an entry to output/<host>/journal.jsonl
+func (s *Scraper) appendJournal(hostDir string, entry *JournalEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("[journal] ERROR: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	journalMu.Lock()
+	defer journalMu.Unlock()
+
+	dir := filepath.Join(s.outDir, hostDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("[journal] ERROR: %v", err)
+		return
+	}
+	f, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("[journal] ERROR: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(line); err != nil {
+		log.Printf("[journal] ERROR: %v", err)
+	}
+}
+
+// trimJournal drops the entries of a host's journal from before cutoff, once
+// they make up half of it
+func trimJournal(hostDir string, cutoff time.Time) error {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+
+	path := filepath.Join(hostDir, journalFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var kept bytes.Buffer
+	lines, old := 0, 0
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		lines++
+		var e JournalEntry
+		if json.Unmarshal(line, &e) != nil || e.Start.Before(cutoff) {
+			old++
+			continue
+		}
+		kept.Write(line)
+		kept.WriteByte('\n')
+	}
+	if old == 0 || 2*old < lines {
+		return nil
+	}
+	return snapshot.WriteFileAtomic(filepath.Join(hostDir, "."+journalFile+".tmp"), path, kept.Bytes())
+}
@@ -0,0 +1,154 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics are the Prometheus metrics of the scraper itself, derived from the
+// journal entry of every scrape attempt
+type Metrics struct {
+	registry *prometheus.Registry
+
+	scrapes     *prometheus.CounterVec
+	misses      *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+	bytes       *prometheus.CounterVec
+	lastSuccess *prometheus.GaugeVec
+	goroutines  *prometheus.GaugeVec
+}
+
+func newMetrics(s *Scraper) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		scrapes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gscrape_scrapes_total",
+			Help: "Scrape attempts by target and result (success, partial, failure, skipped).",
+		}, []string{"target", "result"}),
+		misses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gscrape_scrape_misses_total",
+			Help: "Scheduled scrapes skipped because the previous scrape of the target was still running.",
+		}, []string{"target"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "gscrape_scrape_duration_seconds",
+			Help:    "Duration of scrape attempts, including extra profiles and writing.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 2, 14), // 10ms .. ~80s
+		}, []string{"target"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gscrape_bytes_written_total",
+			Help: "Compressed bytes written, including extra profiles.",
+		}, []string{"target"}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "gscrape_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful scrape of the target. Partial dumps don't count.",
+		}, []string{"target"}),
+		goroutines: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "gscrape_goroutines",
+			Help: "Goroutine count of the target's last dump.",
+		}, []string{"target"}),
+	}
+
+	m.registry.MustRegister(
+		m.scrapes, m.misses, m.duration, m.bytes, m.lastSuccess, m.goroutines,
+		&hourlyRateCollector{scraper: s},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// observe records a finished scrape attempt. m may be nil when metrics are disabled.
+func (m *Metrics) observe(e *JournalEntry) {
+	if m == nil {
+		return
+	}
+
+	if e.Missed > 0 {
+		m.misses.WithLabelValues(e.URL).Add(float64(e.Missed))
+	}
+
+	switch {
+	case e.Skipped != "":
+		m.scrapes.WithLabelValues(e.URL, "skipped").Inc()
+		return
+	case e.Error != "":
+		m.scrapes.WithLabelValues(e.URL, "failure").Inc()
+	case e.Partial != "":
+		m.scrapes.WithLabelValues(e.URL, "partial").Inc()
+	default:
+		m.scrapes.WithLabelValues(e.URL, "success").Inc()
+		m.lastSuccess.WithLabelValues(e.URL).SetToCurrentTime()
+	}
+
+	m.duration.WithLabelValues(e.URL).Observe(float64(e.DurationMS) / 1000)
+	m.bytes.WithLabelValues(e.URL).Add(float64(e.CompressedBytes))
+	if e.Goroutines > 0 {
+		m.goroutines.WithLabelValues(e.URL).Set(float64(e.Goroutines))
+	}
+}
+
+// forget deletes the series of a removed target, so it stops being
+// exported. m may be nil when metrics are disabled.
+func (m *Metrics) forget(url string) {
+	if m == nil {
+		return
+	}
+	m.scrapes.DeletePartialMatch(prometheus.Labels{"target": url})
+	m.misses.DeleteLabelValues(url)
+	m.duration.DeleteLabelValues(url)
+	m.bytes.DeleteLabelValues(url)
+	m.lastSuccess.DeleteLabelValues(url)
+	m.goroutines.DeleteLabelValues(url)
+}
+
+// Serve runs the /metrics endpoint until ctx is cancelled
+func (m *Metrics) Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Serving metrics on %s/metrics", addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// hourlyRateCollector exports the HostStats moving average of every host,
+// scraped or pushed, at collection time
+type hourlyRateCollector struct {
+	scraper *Scraper
+}
+
+var hourlyRateDesc = prometheus.NewDesc(
+	"gscrape_host_bytes_per_hour",
+	"Moving average of compressed bytes written per hour for the host.",
+	[]string{"host"}, nil,
+)
+
+func (c *hourlyRateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- hourlyRateDesc
+}
+
+func (c *hourlyRateCollector) Collect(ch chan<- prometheus.Metric) {
+	c.scraper.statsMu.RLock()
+	defer c.scraper.statsMu.RUnlock()
+
+	for host, st := range c.scraper.stats {
+		ch <- prometheus.MustNewConstMetric(hourlyRateDesc, prometheus.GaugeValue, st.HourlyRate(), host)
+	}
+}
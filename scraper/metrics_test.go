@@ -0,0 +1,55 @@
+package scraper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsObserve(t *testing.T) {
+	const url = "http://10.0.0.1:6060/debug/pprof/goroutine"
+	tests := []struct {
+		name    string
+		entry   JournalEntry
+		result  string
+		success bool
+	}{
+		{"success", JournalEntry{Goroutines: 10}, "success", true},
+		{"partial", JournalEntry{Partial: "response limit", Goroutines: 10}, "partial", false},
+		{"failure", JournalEntry{Error: "timeout"}, "failure", false},
+		{"skipped", JournalEntry{Skipped: "too large"}, "skipped", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMetrics(New(Options{}))
+			e := tt.entry
+			e.URL, e.Start = url, time.Now()
+			m.observe(&e)
+
+			if got := testutil.ToFloat64(m.scrapes.WithLabelValues(url, tt.result)); got != 1 {
+				t.Errorf("%s scrapes = %v, want 1", tt.result, got)
+			}
+			if got := testutil.CollectAndCount(m.lastSuccess) == 1; got != tt.success {
+				t.Errorf("last success set = %v, want %v", got, tt.success)
+			}
+
+			// The control API agrees with the metric
+			st := &targetState{target: &Target{URL: url}}
+			st.recordAttempt(&e)
+			if got := !st.lastSuccess.IsZero(); got != tt.success {
+				t.Errorf("status last success set = %v, want %v", got, tt.success)
+			}
+
+			m.forget(url)
+			n := 0
+			for _, c := range []prometheus.Collector{m.scrapes, m.misses, m.duration, m.bytes, m.lastSuccess, m.goroutines} {
+				n += testutil.CollectAndCount(c)
+			}
+			if n != 0 {
+				t.Errorf("%d series left after the target was removed", n)
+			}
+		})
+	}
+}
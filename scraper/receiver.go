@@ -0,0 +1,322 @@
+package scraper
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gscrape/snapshot"
+)
+
+// Receiver accepts goroutine dumps pushed by processes gscrape can't reach and
+// stores them in the same layout as scraped dumps
+type Receiver struct {
+	scraper  *Scraper
+	tokens   map[string]string // token -> sender name
+	limiter  *rateLimiter
+	maxBytes int64
+}
+
+// NewReceiver returns a Receiver storing dumps through s, for senders
+// authenticating with one of tokens (token -> sender name), each allowed
+// perMinute pushes with bursts of burst, of at most maxBytes uncompressed
+func NewReceiver(s *Scraper, tokens map[string]string, perMinute float64, burst int, maxBytes int64) *Receiver {
+	return &Receiver{
+		scraper:  s,
+		tokens:   tokens,
+		limiter:  newRateLimiter(perMinute, burst),
+		maxBytes: maxBytes,
+	}
+}
+
+// LoadTokens reads a tokens file with one "<sender> <token>" pair per line.
+// Empty lines and lines starting with # are ignored.
+func LoadTokens(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tokens := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<sender> <token>\"", path, lineNo)
+		}
+		tokens[fields[1]] = fields[0]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%s: no tokens", path)
+	}
+	return tokens, nil
+}
+
+// Serve runs the receiver HTTP server until ctx is cancelled and the pushes
+// in flight are stored
+func (rc *Receiver) Serve(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/push", rc.handlePush)
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Receiving pushed dumps on %s", addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	// Shutdown returns once the requests in flight are done
+	<-shutdown
+	return nil
+}
+
+// authenticate returns the sender name for the request's bearer token
+func (rc *Receiver) authenticate(r *http.Request) (string, bool) {
+	return bearerSender(r, rc.tokens)
+}
+
+// bearerSender returns the name tokens (token -> name) give the request's
+// bearer token, comparing in constant time
+func bearerSender(r *http.Request, tokens map[string]string) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+	for known, sender := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+			return sender, true
+		}
+	}
+	return "", false
+}
+
+// handlePush accepts POST /push?host=<name>&t=<timestamp> with a raw or
+// gzipped debug=2 goroutine dump as body. The timestamp is optional and may
+// be RFC 3339 or unix seconds.
+func (rc *Receiver) handlePush(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sender, ok := rc.authenticate(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !rc.limiter.Allow(sender) {
+		log.Printf("[push:%s] rate limited", sender)
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	host := r.URL.Query().Get("host")
+	hostDir, err := pushHostDir(host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Pushed dumps would interleave with the chain of a scraped target
+	if rc.scraper.scrapesInto(hostDir) {
+		http.Error(w, fmt.Sprintf("host %q is a scraped target", host), http.StatusConflict)
+		return
+	}
+
+	ts := time.Now()
+	if t := r.URL.Query().Get("t"); t != "" {
+		ts, err = parsePushTime(t)
+		if err != nil {
+			http.Error(w, "invalid t: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	body, err := readPushBody(r, rc.maxBytes)
+	if err != nil {
+		log.Printf("[push:%s] ERROR: %s: %v", sender, host, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !bytes.HasPrefix(body, []byte("goroutine ")) {
+		http.Error(w, "body is not a goroutine dump", http.StatusBadRequest)
+		return
+	}
+
+	redact := rc.scraper.redaction[rc.scraper.defaultRedact]
+	if redact != nil {
+		body = redact.redactDump(body)
+	}
+
+	outPath := filepath.Join(rc.scraper.outDir, hostDir)
+	if err := os.MkdirAll(outPath, 0755); err != nil {
+		log.Printf("[push:%s] ERROR: failed to create output dir: %v", sender, err)
+		http.Error(w, "storage error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := rc.scraper.writeHostInfo(hostDir, &HostInfo{Alias: host, Labels: map[string]string{"pushed_by": sender}}); err != nil {
+		log.Printf("[push:%s] ERROR: %s: %v", sender, hostInfoFile, err)
+	}
+
+	// Same naming, metadata and manifest as scraped dumps
+	snap, err := snapshot.Reserve(outPath, ts)
+	if err != nil {
+		log.Printf("[push:%s] ERROR: failed to create file: %v", sender, err)
+		http.Error(w, "storage error", http.StatusInternalServerError)
+		return
+	}
+	filename, compressedSize, err := rc.scraper.writeDump(snap, ".goroutines.txt", body, body)
+	if err != nil {
+		snap.Abort()
+		log.Printf("[push:%s] ERROR: failed to write file: %v", sender, err)
+		http.Error(w, "storage error", http.StatusInternalServerError)
+		return
+	}
+	meta := &SnapshotMeta{Time: snap.Time().UTC(), Labels: map[string]string{"pushed_by": sender}}
+	if redact != nil {
+		meta.Redaction, meta.RedactionDigest = redact.name, redact.digest
+	}
+	if err := snap.WriteMeta(meta); err != nil {
+		log.Printf("[push:%s] ERROR: failed to write metadata: %v", sender, err)
+	}
+	if err := snap.Commit(); err != nil {
+		log.Printf("[push:%s] ERROR: failed to update manifest: %v", sender, err)
+	}
+	rc.scraper.hooks.snapshot(hostDir, filename, meta)
+
+	hostStats := rc.scraper.getStats(hostDir)
+	hostStats.Record(compressedSize, time.Since(start))
+
+	log.Printf("[push:%s] OK: %s %.3f MB (%.3f MB %s) in %s, ~%.1f MB/hr -> %s",
+		sender, host, float64(len(body))/1024/1024, float64(compressedSize)/1024/1024, filepath.Ext(filename)[1:],
+		time.Since(start).Round(time.Millisecond), hostStats.HourlyRate()/1024/1024, filename)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pushHostDir validates a pushed host/app name and returns its directory name
+func pushHostDir(host string) (string, error) {
+	if host == "" {
+		return "", fmt.Errorf("host parameter required")
+	}
+	// Hidden names are reserved for temporary and bookkeeping files
+	if strings.HasPrefix(host, ".") || strings.ContainsAny(host, `/\`) {
+		return "", fmt.Errorf("invalid host %q", host)
+	}
+	return sanitizeHost(host), nil
+}
+
+// parsePushTime accepts RFC 3339 (with optional fractional seconds) or unix seconds
+func parsePushTime(s string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// readPushBody reads a request body of at most maxBytes, transparently
+// decompressing gzip (by Content-Encoding or magic bytes)
+func readPushBody(r *http.Request, maxBytes int64) ([]byte, error) {
+	br := bufio.NewReader(http.MaxBytesReader(nil, r.Body, maxBytes))
+
+	var reader io.Reader = br
+	magic, _ := br.Peek(2)
+	if r.Header.Get("Content-Encoding") == "gzip" || bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		defer gr.Close()
+		// Bound the decompressed size too
+		reader = io.LimitReader(gr, maxBytes+1)
+	}
+
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+	if int64(len(body)) > maxBytes {
+		return nil, fmt.Errorf("dump larger than %d bytes", maxBytes)
+	}
+	return body, nil
+}
+
+// rateLimiter is a per-key token bucket
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perMinute float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    perMinute / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow consumes a token for key if one is available
+func (l *rateLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,179 @@
+package scraper
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadPushBody(t *testing.T) {
+	const maxBytes = 1024
+	dump := []byte("goroutine 1 [running]:\nmain.main()\n\t/src/main.go:10 +0x1d\n")
+
+	tests := []struct {
+		name     string
+		body     []byte
+		encoding string
+		want     []byte // nil if an error is expected
+	}{
+		{"plain", dump, "", dump},
+		{"gzip by header", gzipped(t, dump), "gzip", dump},
+		{"gzip by magic", gzipped(t, dump), "", dump},
+		{"at limit", bytes.Repeat([]byte("x"), maxBytes), "", bytes.Repeat([]byte("x"), maxBytes)},
+		{"oversized", bytes.Repeat([]byte("x"), maxBytes+1), "", nil},
+		{"gzip bomb", gzipped(t, make([]byte, 1<<20)), "gzip", nil},
+		{"gzip header on plain body", dump, "gzip", nil},
+		{"truncated gzip", gzipped(t, dump)[:20], "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/push", bytes.NewReader(tt.body))
+			if tt.encoding != "" {
+				r.Header.Set("Content-Encoding", tt.encoding)
+			}
+			got, err := readPushBody(r, maxBytes)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("read %d bytes, want an error", len(got))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("read %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReceiverAuth(t *testing.T) {
+	outDir := t.TempDir()
+	rc := NewReceiver(New(Options{OutDir: outDir}), map[string]string{"secret-a": "alice", "secret-b": "bob"}, 60, 10, 1<<20)
+	dump := "goroutine 1 [running]:\nmain.main()\n\t/src/main.go:10 +0x1d\n"
+
+	tests := []struct {
+		name   string
+		auth   string
+		status int
+	}{
+		{"no header", "", http.StatusUnauthorized},
+		{"unknown token", "Bearer secret-c", http.StatusUnauthorized},
+		{"token prefix", "Bearer secret", http.StatusUnauthorized},
+		{"empty token", "Bearer ", http.StatusUnauthorized},
+		{"basic auth", "Basic secret-a", http.StatusUnauthorized},
+		{"valid", "Bearer secret-a", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/push?host=app&t=1768600000", strings.NewReader(dump))
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			rc.handlePush(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(outDir, "app", "*.goroutines.txt*"))
+	if len(matches) != 1 {
+		t.Errorf("stored %d dumps, want 1", len(matches))
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(6, 2) // one token every 10s
+
+	for i, want := range []bool{true, true, false} {
+		if got := l.Allow("alice"); got != want {
+			t.Fatalf("push %d allowed = %v, want %v", i+1, got, want)
+		}
+	}
+	if !l.Allow("bob") {
+		t.Fatal("other sender limited")
+	}
+
+	// Refill over time, up to the burst
+	l.buckets["alice"].last = l.buckets["alice"].last.Add(-10 * time.Second)
+	if !l.Allow("alice") {
+		t.Fatal("not allowed after refill")
+	}
+	if l.Allow("alice") {
+		t.Fatal("allowed beyond the refilled tokens")
+	}
+	l.buckets["alice"].last = l.buckets["alice"].last.Add(-time.Hour)
+	for i, want := range []bool{true, true, false} {
+		if got := l.Allow("alice"); got != want {
+			t.Fatalf("push %d after an hour allowed = %v, want %v", i+1, got, want)
+		}
+	}
+}
+
+func TestReceiverRateLimited(t *testing.T) {
+	rc := NewReceiver(New(Options{OutDir: t.TempDir()}), map[string]string{"secret": "alice"}, 1, 1, 1<<20)
+
+	var codes []int
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/push?host=app", strings.NewReader("goroutine 1 [running]:\n"))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		rc.handlePush(w, r)
+		codes = append(codes, w.Code)
+	}
+	if codes[0] != http.StatusNoContent || codes[1] != http.StatusTooManyRequests {
+		t.Errorf("statuses %v, want [204 429]", codes)
+	}
+}
+
+func TestReceiverHosts(t *testing.T) {
+	outDir := t.TempDir()
+	s := New(Options{OutDir: outDir})
+	s.targets = map[string]*targetState{"http://10.0.0.1:6060/debug/pprof/goroutine": {hostDir: "scraped"}}
+	rc := NewReceiver(s, map[string]string{"secret": "alice"}, 60, 10, 1<<20)
+
+	tests := []struct {
+		host   string
+		status int
+	}{
+		{"app", http.StatusNoContent},
+		{".dicts", http.StatusBadRequest},
+		{"..", http.StatusBadRequest},
+		{"a/b", http.StatusBadRequest},
+		{"scraped", http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/push?host="+url.QueryEscape(tt.host), strings.NewReader("goroutine 1 [running]:\n"))
+			r.Header.Set("Authorization", "Bearer secret")
+			w := httptest.NewRecorder()
+			rc.handlePush(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+
+	data, err := os.ReadFile(filepath.Join(outDir, "app", hostInfoFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info HostInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		t.Fatal(err)
+	}
+	if want := (HostInfo{Alias: "app", Labels: map[string]string{"pushed_by": "alice"}}); !reflect.DeepEqual(info, want) {
+		t.Errorf("host.json %+v, want %+v", info, want)
+	}
+}
@@ -0,0 +1,182 @@
+package scraper
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// Redaction profiles are named sets of rules, defined under "redaction" in the
+// -config file, that rewrite goroutine dumps line by line before they are
+// written. A target picks one with "redact", -redact sets the default for all
+// targets and pushed dumps. The snapshot metadata records the profile and a
+// digest of its rules.
+//
+// Only the text dumps are redacted: targets with a redaction profile can't
+// collect pprof profiles or traces, which would carry the same names and
+// paths, or runtime metrics, whose expvar cmdline and labels are passed
+// through as is.
+
+// RedactionProfile is one entry under "redaction" in the -config file
+type RedactionProfile struct {
+	DropArgs bool          `json:"drop_args,omitempty"` // replace function argument values with "..."
+	Paths    []PathRewrite `json:"paths,omitempty"`
+	Packages []PackageMask `json:"packages,omitempty"`
+
+	name   string
+	digest string // of the rules, so a profile changed under the same name can be told apart
+}
+
+// PathRewrite replaces the prefix of source file paths
+type PathRewrite struct {
+	Prefix  string `json:"prefix"`
+	Replace string `json:"replace"`
+}
+
+// PackageMask rewrites function names matching a regular expression, e.g. to
+// hide internal package paths. Replace may refer to submatches as $1.
+type PackageMask struct {
+	Match   string `json:"match"`
+	Replace string `json:"replace"`
+
+	re *regexp.Regexp
+}
+
+// redactionProfile returns the redaction profile named by a target, or nil
+func (s *Scraper) redactionProfile(t *Target) (*RedactionProfile, error) {
+	if t.Redact == "" {
+		return nil, nil
+	}
+	p := s.redaction[t.Redact]
+	if p == nil {
+		return nil, fmt.Errorf("unknown redaction profile %q", t.Redact)
+	}
+	if len(t.Profiles) > 0 {
+		return nil, fmt.Errorf("pprof profiles can't be redacted, remove them or the redaction profile")
+	}
+	if t.RuntimeMetrics != "" {
+		return nil, fmt.Errorf("runtime metrics can't be redacted, remove them or the redaction profile")
+	}
+	if t.Trace != nil {
+		return nil, fmt.Errorf("traces can't be redacted, remove the trace capture or the redaction profile")
+	}
+	return p, nil
+}
+
+// argsRe matches the argument list at the end of a function line
+var argsRe = regexp.MustCompile(`\([^()]+\)$`)
+
+// compile checks the rules of a profile and prepares it for use
+func (p *RedactionProfile) compile(name string) error {
+	for i := range p.Packages {
+		re, err := regexp.Compile(p.Packages[i].Match)
+		if err != nil {
+			return fmt.Errorf("redaction %q: %w", name, err)
+		}
+		p.Packages[i].re = re
+	}
+	for _, pr := range p.Paths {
+		if pr.Prefix == "" {
+			return fmt.Errorf("redaction %q: path rewrite without prefix", name)
+		}
+	}
+
+	rules, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(rules)
+	p.name, p.digest = name, hex.EncodeToString(sum[:6])
+	return nil
+}
+
+// redactLine applies the profile to one line of a debug=2 or debug=1 dump
+func (p *RedactionProfile) redactLine(line []byte) []byte {
+	switch {
+	case len(line) == 0 || bytes.HasPrefix(line, []byte("goroutine ")):
+		return line
+	case line[0] == '\t':
+		// "\t/src/app/main.go:12 +0x1f"
+		return append([]byte{'\t'}, p.rewritePath(line[1:])...)
+	case bytes.HasPrefix(line, []byte("#\t")):
+		// debug=1: "#\t0x4a1b2c\tmain.f+0x12\t/src/app/main.go:12"
+		fields := bytes.Split(line, []byte{'\t'})
+		if len(fields) == 4 {
+			fields[2] = p.maskFunc(fields[2])
+			fields[3] = p.rewritePath(fields[3])
+		}
+		return bytes.Join(fields, []byte{'\t'})
+	case bytes.HasPrefix(line, []byte("created by ")):
+		return append([]byte("created by "), p.maskFunc(line[len("created by "):])...)
+	default:
+		// "main.(*T).f(0xc000123456, 0x3)"
+		if p.DropArgs {
+			line = argsRe.ReplaceAllLiteral(line, []byte("(...)"))
+		}
+		return p.maskFunc(line)
+	}
+}
+
+func (p *RedactionProfile) rewritePath(path []byte) []byte {
+	for _, pr := range p.Paths {
+		if rest, ok := bytes.CutPrefix(path, []byte(pr.Prefix)); ok {
+			return append([]byte(pr.Replace), rest...)
+		}
+	}
+	return path
+}
+
+func (p *RedactionProfile) maskFunc(fn []byte) []byte {
+	for _, pm := range p.Packages {
+		fn = pm.re.ReplaceAll(fn, []byte(pm.Replace))
+	}
+	return fn
+}
+
+// redactWriter applies a redaction profile to a dump on its way to w
+type redactWriter struct {
+	p    *RedactionProfile
+	w    io.Writer
+	line []byte // incomplete line carried over to the next write
+}
+
+func (r *redactWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			r.line = append(r.line, p...)
+			break
+		}
+		r.line = append(r.line, p[:i]...)
+		if _, err := r.w.Write(append(r.p.redactLine(r.line), '\n')); err != nil {
+			return 0, err
+		}
+		r.line = r.line[:0]
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// flush writes the last line of a dump without a trailing newline
+func (r *redactWriter) flush() error {
+	if len(r.line) == 0 {
+		return nil
+	}
+	_, err := r.w.Write(r.p.redactLine(r.line))
+	r.line = r.line[:0]
+	return err
+}
+
+// redactDump applies a redaction profile to a whole dump
+func (p *RedactionProfile) redactDump(dump []byte) []byte {
+	var buf bytes.Buffer
+	rw := &redactWriter{p: p, w: &buf}
+	rw.Write(dump)
+	rw.flush()
+	return buf.Bytes()
+}
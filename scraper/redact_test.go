@@ -0,0 +1,102 @@
+package scraper
+
+import (
+	"bytes"
+	"testing"
+)
+
+// testProfile returns a compiled profile dropping arguments, rewriting /src/app/
+// and masking the example.com/internal packages
+func testProfile(t *testing.T) *RedactionProfile {
+	t.Helper()
+	p := &RedactionProfile{
+		DropArgs: true,
+		Paths:    []PathRewrite{{Prefix: "/src/app/", Replace: "app/"}, {Prefix: "/src/", Replace: ""}},
+		Packages: []PackageMask{{Match: `example\.com/internal/(\w+)`, Replace: "internal/$1"}},
+	}
+	if err := p.compile("test"); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestRedactLine(t *testing.T) {
+	p := testProfile(t)
+
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"header", "goroutine 7 [select, 3 minutes]:", "goroutine 7 [select, 3 minutes]:"},
+		{"empty", "", ""},
+		{"function with args", "example.com/internal/billing.(*Worker).run(0xc000123456, 0x3)", "internal/billing.(*Worker).run(...)"},
+		{"function without args", "main.main()", "main.main()"},
+		{"struct args", "main.f({0xc0001, 0x2}, 0x3)", "main.f(...)"},
+		{"method without args", "main.(*T).f()", "main.(*T).f()"},
+		{"file line", "\t/src/app/main.go:12 +0x1f", "\tapp/main.go:12 +0x1f"},
+		{"first matching prefix", "\t/src/lib/x.go:3", "\tlib/x.go:3"},
+		{"other path", "\t/usr/local/go/src/runtime/proc.go:402 +0xce", "\t/usr/local/go/src/runtime/proc.go:402 +0xce"},
+		{"created by", "created by example.com/internal/billing.Start in goroutine 1", "created by internal/billing.Start in goroutine 1"},
+		{"debug=1 frame", "#\t0x4a1b2c\texample.com/internal/billing.run+0x12\t/src/app/run.go:12", "#\t0x4a1b2c\tinternal/billing.run+0x12\tapp/run.go:12"},
+		{"debug=1 short frame", "#\t0x4a1b2c", "#\t0x4a1b2c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(p.redactLine([]byte(tt.line))); got != tt.want {
+				t.Errorf("redactLine(%q) = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactDumpAcrossWrites(t *testing.T) {
+	p := testProfile(t)
+	dump := []byte("goroutine 1 [running]:\nexample.com/internal/billing.run(0x1)\n\t/src/app/run.go:12 +0x1f\n\ngoroutine 2 [select]:\nmain.f()\n\t/src/app/f.go:3")
+	want := p.redactDump(dump)
+
+	// Lines split between writes are redacted whole
+	for _, size := range []int{1, 7, 64} {
+		var buf bytes.Buffer
+		rw := &redactWriter{p: p, w: &buf}
+		for rest := dump; len(rest) > 0; {
+			n := min(size, len(rest))
+			rw.Write(rest[:n])
+			rest = rest[n:]
+		}
+		if err := rw.flush(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("writes of %d bytes: got %q, want %q", size, buf.Bytes(), want)
+		}
+	}
+	if !bytes.Contains(want, []byte("\tapp/f.go:3")) || bytes.HasSuffix(want, []byte("\n")) {
+		t.Errorf("last line without newline redacted as %q", want)
+	}
+}
+
+func TestRedactionDigest(t *testing.T) {
+	a, b := testProfile(t), testProfile(t)
+	if a.digest == "" || a.digest != b.digest {
+		t.Fatalf("digests of the same rules: %q, %q", a.digest, b.digest)
+	}
+
+	changed := &RedactionProfile{DropArgs: false, Paths: a.Paths, Packages: a.Packages}
+	if err := changed.compile("test"); err != nil {
+		t.Fatal(err)
+	}
+	if changed.digest == a.digest {
+		t.Error("digest unchanged after changing the rules")
+	}
+
+	invalid := []*RedactionProfile{
+		{Packages: []PackageMask{{Match: "("}}},
+		{Paths: []PathRewrite{{Replace: "x"}}},
+	}
+	for _, p := range invalid {
+		if err := p.compile("bad"); err == nil {
+			t.Errorf("compiled invalid profile %+v", p)
+		}
+	}
+}
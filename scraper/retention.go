@@ -0,0 +1,378 @@
+package scraper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gscrape/snapshot"
+)
+
+// RetentionPolicy bounds how much scraped data is kept on disk. Zero values
+// disable the corresponding limit.
+type RetentionPolicy struct {
+	MaxAge        time.Duration // delete snapshots older than this
+	MaxHostBytes  int64         // per host directory
+	MaxTotalBytes int64         // across all hosts
+	ThinAfter     time.Duration // snapshots older than this are thinned...
+	ThinKeep      int           // ...keeping 1 in ThinKeep
+	ThinInterval  time.Duration // scrape interval of snapshots whose metadata doesn't record one
+
+	// Roll up hours that ended this long ago into one archive per host and
+	// hour before the limits are applied (0 = never), see rollup.go
+	CompactAfter time.Duration
+}
+
+// Enabled reports whether the policy limits anything
+func (p RetentionPolicy) Enabled() bool {
+	return p.MaxAge > 0 || p.MaxHostBytes > 0 || p.MaxTotalBytes > 0 || (p.ThinAfter > 0 && p.ThinKeep > 1) || p.CompactAfter > 0
+}
+
+// snapshotFiles groups all files of one snapshot: the dump plus any files
+// sharing its timestamp prefix (extra profiles etc.). A keyframe also carries
+// the delta snapshots encoded against it, which can't outlive it. An archive
+// of rolled-up snapshots is removed as a whole, like one snapshot.
+type snapshotFiles struct {
+	host    string
+	prefix  string
+	ts      time.Time // newest snapshot of an archive
+	last    time.Time // newest snapshot of a chain
+	files   []string
+	size    int64
+	members []string // prefixes of the other snapshots removed with it
+	archive bool
+	removed bool
+}
+
+// RunRetention enforces the policy every interval until ctx is cancelled
+func RunRetention(ctx context.Context, outDir string, policy RetentionPolicy, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if policy.CompactAfter > 0 {
+			CompactAll(outDir, policy.CompactAfter, time.Now())
+		}
+		enforceRetention(outDir, policy, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// enforceRetention applies the policy once, deleting the oldest snapshots first
+func enforceRetention(outDir string, policy RetentionPolicy, now time.Time) {
+	byHost, err := listSnapshots(outDir)
+	if err != nil {
+		log.Printf("[retention] ERROR: %v", err)
+		return
+	}
+
+	// The scraper may still be appending deltas to the newest chain of each
+	// host, against a base it keeps in memory. Only max age removes it, and
+	// the scraper then stores a keyframe next (see keyframeDue).
+	open := make(map[*snapshotFiles]bool)
+	for _, snaps := range byHost {
+		if n := len(snaps); n > 0 && !snaps[n-1].archive {
+			open[snaps[n-1]] = true
+		}
+	}
+
+	for host, snaps := range byHost {
+		var kept []*snapshotFiles
+
+		// Age limit and thinning
+		var lastKept *snapshotFiles
+		for _, snap := range snaps {
+			age := now.Sub(snap.ts)
+			switch {
+			case policy.MaxAge > 0 && age > policy.MaxAge:
+				removeSnapshot(snap, "max-age")
+				continue
+			case policy.ThinAfter > 0 && policy.ThinKeep > 1 && age > policy.ThinAfter && !snap.archive && !open[snap] && lastKept != nil:
+				// Keep one snapshot in each bucket of ThinKeep of the host's
+				// scrape intervals. A chain is removed whole, so only if all
+				// of it falls in the bucket of the newest snapshot kept.
+				// Buckets are aligned to wall time so repeated passes agree.
+				if width := snap.interval(policy.ThinInterval) * time.Duration(policy.ThinKeep); width > 0 &&
+					snap.last.Truncate(width).Equal(lastKept.last.Truncate(width)) {
+					removeSnapshot(snap, "thinning")
+					continue
+				}
+			}
+			kept = append(kept, snap)
+			lastKept = snap
+		}
+
+		// Per-host size limit
+		if policy.MaxHostBytes > 0 {
+			var total int64
+			for _, snap := range kept {
+				total += snap.size
+			}
+			for len(kept) > 0 && total > policy.MaxHostBytes && !open[kept[0]] {
+				total -= kept[0].size
+				removeSnapshot(kept[0], "max-host-bytes")
+				kept = kept[1:]
+			}
+		}
+
+		byHost[host] = kept
+	}
+
+	// Global size limit: merge hosts and drop the globally oldest
+	if policy.MaxTotalBytes > 0 {
+		var all []*snapshotFiles
+		var total int64
+		for _, snaps := range byHost {
+			for _, snap := range snaps {
+				all = append(all, snap)
+				total += snap.size
+			}
+		}
+		sort.Slice(all, func(i, j int) bool { return all[i].ts.Before(all[j].ts) })
+		for len(all) > 0 && total > policy.MaxTotalBytes {
+			if !open[all[0]] {
+				total -= all[0].size
+				removeSnapshot(all[0], "max-total-bytes")
+			}
+			all = all[1:]
+		}
+	}
+
+	for host, snaps := range byHost {
+		hostDir := filepath.Join(outDir, host)
+		pruneDicts(hostDir)
+		compactLogs(hostDir, snaps, policy, now)
+	}
+}
+
+// compactLogs keeps the manifest and journal of a host from outgrowing its
+// snapshots. The manifest drops the snapshots removed, the journal the scrape
+// attempts from before the oldest snapshot left, or from before the max age
+// if none is left.
+func compactLogs(hostDir string, snaps []*snapshotFiles, policy RetentionPolicy, now time.Time) {
+	if err := snapshot.CompactManifest(hostDir); err != nil {
+		log.Printf("[retention] ERROR: manifest: %v", err)
+	}
+
+	var oldest time.Time
+	for _, snap := range snaps {
+		if ts, err := snapshot.ParseName(snap.prefix); err == nil && !snap.removed && (oldest.IsZero() || ts.Before(oldest)) {
+			oldest = ts
+		}
+	}
+	if oldest.IsZero() && policy.MaxAge > 0 {
+		oldest = now.Add(-policy.MaxAge)
+	}
+	if oldest.IsZero() {
+		return
+	}
+	if err := trimJournal(hostDir, oldest); err != nil {
+		log.Printf("[retention] ERROR: %s: %v", journalFile, err)
+	}
+}
+
+// listSnapshots scans the output directory and returns each host's snapshots
+// sorted oldest first
+func listSnapshots(outDir string) (map[string][]*snapshotFiles, error) {
+	hostEntries, err := os.ReadDir(outDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	byHost := make(map[string][]*snapshotFiles)
+	for _, he := range hostEntries {
+		if !he.IsDir() {
+			continue
+		}
+		host := he.Name()
+
+		entries, err := os.ReadDir(filepath.Join(outDir, host))
+		if err != nil {
+			log.Printf("[retention] ERROR: %s: %v", host, err)
+			continue
+		}
+
+		byPrefix := make(map[string]*snapshotFiles)
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			prefix, _, ok := strings.Cut(e.Name(), ".")
+			if !ok {
+				continue
+			}
+			ts, err := snapshot.ParseName(prefix)
+			if err != nil {
+				continue // not a snapshot file
+			}
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+
+			snap := byPrefix[prefix]
+			if snap == nil {
+				snap = &snapshotFiles{host: host, prefix: prefix, ts: ts, last: ts}
+				byPrefix[prefix] = snap
+			}
+			snap.files = append(snap.files, filepath.Join(outDir, host, e.Name()))
+			snap.size += info.Size()
+		}
+
+		// Archives of rolled-up hours, each removed as a whole
+		archives, err := listArchives(outDir, host)
+		if err != nil {
+			log.Printf("[retention] ERROR: %s: %v", host, err)
+			continue
+		}
+		archived := make(map[string]*snapshotFiles)
+		for _, archive := range archives {
+			archived[archive.prefix] = archive
+			for _, prefix := range archive.members {
+				archived[prefix] = archive
+			}
+		}
+
+		// Fold delta snapshots into their keyframe
+		for prefix, snap := range byPrefix {
+			name := deltaKeyframe(snap)
+			keyframe := byPrefix[name]
+			if keyframe == nil {
+				keyframe = archived[name]
+			}
+			if keyframe == nil || keyframe == snap {
+				continue
+			}
+			keyframe.files = append(keyframe.files, snap.files...)
+			keyframe.size += snap.size
+			if snap.ts.After(keyframe.last) {
+				keyframe.last = snap.ts
+			}
+			keyframe.members = append(keyframe.members, prefix)
+			delete(byPrefix, prefix)
+		}
+
+		snaps := make([]*snapshotFiles, 0, len(byPrefix)+len(archives))
+		for _, snap := range byPrefix {
+			snaps = append(snaps, snap)
+		}
+		snaps = append(snaps, archives...)
+		sort.Slice(snaps, func(i, j int) bool { return snaps[i].ts.Before(snaps[j].ts) })
+		byHost[host] = snaps
+	}
+
+	return byHost, nil
+}
+
+func removeSnapshot(snap *snapshotFiles, reason string) {
+	snap.removed = true
+	for _, f := range snap.files {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			log.Printf("[retention] ERROR: %v", err)
+		}
+	}
+	// Tell gindex the files are gone on purpose
+	hostDir := filepath.Dir(snap.files[0])
+	if snap.archive {
+		hostDir = filepath.Dir(hostDir)
+	}
+	for _, prefix := range append([]string{snap.prefix}, snap.members...) {
+		if err := snapshot.AppendManifest(hostDir, &snapshot.ManifestEntry{Snapshot: prefix, Removed: true}); err != nil {
+			log.Printf("[retention] ERROR: manifest: %v", err)
+		}
+	}
+
+	what := snap.prefix
+	switch {
+	case snap.archive:
+		what = fmt.Sprintf("archive %s (%d snapshots)", snap.prefix, 1+len(snap.members))
+	case len(snap.members) > 0:
+		what += fmt.Sprintf(" with %d deltas", len(snap.members))
+	}
+	log.Printf("[retention] %s: removed %s (%.3f MB, %d files): %s",
+		snap.host, what, float64(snap.size)/1024/1024, len(snap.files), reason)
+}
+
+// listArchives returns the archives of a host as retention units
+func listArchives(outDir, host string) ([]*snapshotFiles, error) {
+	hostDir := filepath.Join(outDir, host)
+	indexes, err := snapshot.ReadRollupIndexes(hostDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var archives []*snapshotFiles
+	for name, index := range indexes {
+		newest, err := snapshot.ParseName(index.Snapshots[len(index.Snapshots)-1])
+		if err != nil {
+			continue
+		}
+		tarPath := filepath.Join(hostDir, snapshot.RollupDir, name+".tar")
+		indexPath := filepath.Join(hostDir, snapshot.RollupDir, name+".index.json")
+		archive := &snapshotFiles{host: host, prefix: index.Snapshots[0], ts: newest, last: newest, files: []string{tarPath, indexPath}, members: index.Snapshots[1:], archive: true}
+		for _, path := range archive.files {
+			if info, err := os.Stat(path); err == nil {
+				archive.size += info.Size()
+			}
+		}
+		archives = append(archives, archive)
+	}
+	return archives, nil
+}
+
+// deltaKeyframe returns the keyframe of a delta snapshot from its metadata,
+// or "" if the snapshot is stored in full
+func deltaKeyframe(snap *snapshotFiles) string {
+	for _, f := range snap.files {
+		if snapshot.IsDelta(f) {
+			if meta := snap.meta(); meta != nil {
+				return meta.Keyframe
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// interval returns the scrape interval a snapshot was taken at, from its
+// metadata, or fallback if it doesn't record one (pushed or older snapshots)
+func (snap *snapshotFiles) interval(fallback time.Duration) time.Duration {
+	if meta := snap.meta(); meta != nil {
+		if d, err := time.ParseDuration(meta.Interval); err == nil && d > 0 {
+			return d
+		}
+	}
+	return fallback
+}
+
+// meta reads the snapshot's .meta.json, or returns nil
+func (snap *snapshotFiles) meta() *SnapshotMeta {
+	for _, f := range snap.files {
+		if strings.HasSuffix(f, snap.prefix+".meta.json") {
+			data, err := os.ReadFile(f)
+			if err != nil {
+				return nil
+			}
+			var meta SnapshotMeta
+			if json.Unmarshal(data, &meta) != nil {
+				return nil
+			}
+			return &meta
+		}
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package scraper
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"gscrape/snapshot"
+)
+
+// testSnap is a snapshot written for a retention test
+type testSnap struct {
+	at       time.Duration // after the test's base time
+	delta    bool          // a delta against the snapshot before it
+	interval time.Duration // recorded in the metadata if set
+}
+
+// writeRetentionSnaps writes snapshots with dumps of size bytes into
+// outDir/host and returns their names
+func writeRetentionSnaps(t *testing.T, outDir, host string, base time.Time, size int, snaps []testSnap) []string {
+	t.Helper()
+	hostDir := filepath.Join(outDir, host)
+	if err := os.MkdirAll(hostDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	dump := make([]byte, size)
+	var names []string
+	var keyframe string
+	for _, s := range snaps {
+		w, err := snapshot.Reserve(hostDir, base.Add(s.at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta := &SnapshotMeta{Time: w.Time()}
+		if s.interval > 0 {
+			meta.Interval = s.interval.String()
+		}
+		suffix := ".goroutines.txt.gz"
+		if s.delta {
+			suffix = ".goroutines.delta.gz"
+			meta.Base, meta.Keyframe = names[len(names)-1], keyframe
+		} else {
+			keyframe = w.Name()
+		}
+		// Stored uncompressed: only the file sizes matter here
+		if err := w.WriteFile(suffix, dump); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteMeta(meta); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, w.Name())
+	}
+	return names
+}
+
+// remaining returns the snapshots of a host still on disk, by index into names
+func remaining(t *testing.T, hostDir string, names []string) []int {
+	t.Helper()
+	var left []int
+	for i, name := range names {
+		if matches, _ := filepath.Glob(filepath.Join(hostDir, name+".goroutines.*")); len(matches) > 0 {
+			left = append(left, i)
+		}
+	}
+	sort.Ints(left)
+	return left
+}
+
+func TestRetentionKeepsOpenChain(t *testing.T) {
+	now := time.Date(2026, 1, 17, 12, 0, 0, 0, time.UTC)
+	base := now.Add(-time.Hour)
+	snaps := []testSnap{
+		{at: 0}, {at: time.Minute, delta: true},
+		{at: 2 * time.Minute}, {at: 3 * time.Minute, delta: true}, {at: 4 * time.Minute, delta: true},
+	}
+
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		want   []int
+	}{
+		{"host bytes", RetentionPolicy{MaxHostBytes: 1}, []int{2, 3, 4}},
+		{"total bytes", RetentionPolicy{MaxTotalBytes: 1}, []int{2, 3, 4}},
+		{"max age", RetentionPolicy{MaxAge: time.Minute}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outDir := t.TempDir()
+			names := writeRetentionSnaps(t, outDir, "host", base, 1000, snaps)
+
+			enforceRetention(outDir, tt.policy, now)
+
+			got := remaining(t, filepath.Join(outDir, "host"), names)
+			if len(got) != len(tt.want) {
+				t.Fatalf("kept snapshots %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("kept snapshots %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyframeDueWhenBaseRemoved(t *testing.T) {
+	outDir := t.TempDir()
+	names := writeRetentionSnaps(t, outDir, "host", time.Now().Add(-time.Hour), 100, []testSnap{{at: 0}})
+	hostDir := filepath.Join(outDir, "host")
+
+	st := &targetState{target: &Target{KeyframeEvery: 10}}
+	st.recordDumpStored(names[0], []byte("goroutine 1 [running]:\n"), true)
+	if st.keyframeDue(hostDir) {
+		t.Fatal("keyframe due with the base on disk")
+	}
+
+	enforceRetention(outDir, RetentionPolicy{MaxAge: time.Minute}, time.Now())
+	if !st.keyframeDue(hostDir) {
+		t.Fatal("no keyframe due after retention removed the base")
+	}
+}
+
+func TestRetentionThinning(t *testing.T) {
+	now := time.Date(2026, 1, 17, 12, 0, 0, 0, time.UTC)
+	base := now.Add(-24 * time.Hour)
+
+	// every returns n snapshots spaced by interval, a keyframe every chain
+	// snapshots, recording interval in their metadata if record is set
+	every := func(n int, interval time.Duration, chain int, record bool) []testSnap {
+		snaps := make([]testSnap, n)
+		for i := range snaps {
+			snaps[i] = testSnap{at: time.Duration(i) * interval, delta: i%chain != 0}
+			if record {
+				snaps[i].interval = interval
+			}
+		}
+		return snaps
+	}
+	policy := func(keep int) RetentionPolicy {
+		return RetentionPolicy{ThinAfter: time.Hour, ThinKeep: keep, ThinInterval: time.Minute}
+	}
+
+	tests := []struct {
+		name   string
+		snaps  []testSnap
+		policy RetentionPolicy
+		want   []int
+	}{
+		{"global interval", every(10, time.Minute, 1, false), policy(5), []int{0, 5, 9}},
+		{"host interval", every(12, 10*time.Second, 1, true), policy(3), []int{0, 3, 6, 9, 11}},
+		{"slower host", every(4, 5*time.Minute, 1, true), policy(2), []int{0, 2, 3}},
+		{"whole chains", every(9, time.Minute, 3, true), policy(10), []int{0, 1, 2, 6, 7, 8}},
+		{"chain spans buckets", every(9, time.Minute, 3, true), policy(2), []int{0, 1, 2, 3, 4, 5, 6, 7, 8}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outDir := t.TempDir()
+			names := writeRetentionSnaps(t, outDir, "host", base, 100, tt.snaps)
+
+			enforceRetention(outDir, tt.policy, now)
+
+			got := remaining(t, filepath.Join(outDir, "host"), names)
+			if len(got) != len(tt.want) {
+				t.Fatalf("kept snapshots %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("kept snapshots %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRetentionCompactsLogs(t *testing.T) {
+	now := time.Date(2026, 1, 17, 12, 0, 0, 0, time.UTC)
+	base := now.Add(-10 * time.Minute)
+	var snaps []testSnap
+	for i := 0; i < 10; i++ {
+		snaps = append(snaps, testSnap{at: time.Duration(i) * time.Minute})
+	}
+	outDir := t.TempDir()
+	names := writeRetentionSnaps(t, outDir, "host", base, 100, snaps)
+	hostDir := filepath.Join(outDir, "host")
+
+	var journal []byte
+	for i := range snaps {
+		line, _ := json.Marshal(&JournalEntry{Start: base.Add(snaps[i].at), File: names[i]})
+		journal = append(append(journal, line...), '\n')
+	}
+	if err := os.WriteFile(filepath.Join(hostDir, journalFile), journal, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keeps the last two snapshots
+	enforceRetention(outDir, RetentionPolicy{MaxAge: 150 * time.Second}, now)
+
+	var listed, removed []string
+	for _, line := range readLines(t, filepath.Join(hostDir, snapshot.ManifestName)) {
+		var e snapshot.ManifestEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Removed {
+			removed = append(removed, e.Snapshot)
+		} else {
+			listed = append(listed, e.Snapshot)
+		}
+	}
+	// The first listing and its removal date the manifest
+	if want := []string{names[0], names[8], names[9]}; !reflect.DeepEqual(listed, want) {
+		t.Errorf("manifest lists %v, want %v", listed, want)
+	}
+	if want := []string{names[0]}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("manifest removes %v, want %v", removed, want)
+	}
+
+	var files []string
+	for _, line := range readLines(t, filepath.Join(hostDir, journalFile)) {
+		var e JournalEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, e.File)
+	}
+	if want := names[8:]; !reflect.DeepEqual(files, want) {
+		t.Errorf("journal has attempts of %v, want %v", files, want)
+	}
+
+	// Nothing more to drop: the files are left alone
+	before, _ := os.Stat(filepath.Join(hostDir, snapshot.ManifestName))
+	enforceRetention(outDir, RetentionPolicy{MaxAge: 150 * time.Second}, now)
+	if after, _ := os.Stat(filepath.Join(hostDir, snapshot.ManifestName)); !os.SameFile(before, after) {
+		t.Error("manifest rewritten without removals")
+	}
+}
+
+// readLines returns the lines of a file
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Fields(string(data))
+}
@@ -0,0 +1,240 @@
+package scraper
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gscrape/snapshot"
+)
+
+// Completed hours of snapshots are rolled up into one tar per host and hour
+// with an offset table next to it, see snapshot/rollup.go. An archive always
+// starts at a full dump: deltas are rolled up with the hour of their
+// keyframe, so a chain never spans two archives.
+
+// rollupBucket is the run of snapshots that goes into one archive
+type rollupBucket struct {
+	hour     time.Time
+	prefixes []string
+	files    map[string][]string // by prefix, file names
+	newest   time.Time
+}
+
+// CompactAll rolls up the completed hours of every host directory
+func CompactAll(outDir string, after time.Duration, now time.Time) {
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("[compact] ERROR: %v", err)
+		}
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			compactHost(filepath.Join(outDir, e.Name()), after, now)
+		}
+	}
+}
+
+// compactHost rolls up every hour of a host directory that ended at least
+// after ago, once its last delta chain is complete
+func compactHost(hostDir string, after time.Duration, now time.Time) {
+	host := filepath.Base(hostDir)
+	buckets, err := rollupBuckets(hostDir)
+	if err != nil {
+		log.Printf("[compact] ERROR: %s: %v", host, err)
+		return
+	}
+
+	for i, b := range buckets {
+		if b.hour.Add(time.Hour+after).After(now) || b.newest.Add(after).After(now) {
+			break
+		}
+		// The chain of the last bucket may still grow
+		if i == len(buckets)-1 && b.newest.Add(time.Hour+after).After(now) {
+			break
+		}
+		if err := writeRollup(hostDir, b); err != nil {
+			log.Printf("[compact] ERROR: %s: %v", host, err)
+			return
+		}
+	}
+}
+
+// rollupBuckets groups the loose snapshots of a host directory by the UTC
+// hour of their keyframe, oldest first
+func rollupBuckets(hostDir string) ([]*rollupBucket, error) {
+	entries, err := os.ReadDir(hostDir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]string)
+	times := make(map[string]time.Time)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		prefix, _, ok := strings.Cut(e.Name(), ".")
+		if !ok {
+			continue
+		}
+		ts, err := snapshot.ParseName(prefix)
+		if err != nil {
+			continue // not a snapshot file
+		}
+		files[prefix] = append(files[prefix], e.Name())
+		times[prefix] = ts
+	}
+
+	prefixes := make([]string, 0, len(files))
+	for prefix := range files {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return times[prefixes[i]].Before(times[prefixes[j]]) })
+
+	var buckets []*rollupBucket
+	for _, prefix := range prefixes {
+		delta := false
+		for _, name := range files[prefix] {
+			delta = delta || snapshot.IsDelta(name)
+		}
+		hour := times[prefix].UTC().Truncate(time.Hour)
+
+		var b *rollupBucket
+		if len(buckets) > 0 {
+			b = buckets[len(buckets)-1]
+		}
+		if b == nil || (!delta && !hour.Equal(b.hour)) {
+			b = &rollupBucket{hour: hour, files: make(map[string][]string)}
+			buckets = append(buckets, b)
+		}
+		b.prefixes = append(b.prefixes, prefix)
+		b.files[prefix] = files[prefix]
+		b.newest = times[prefix]
+	}
+	return buckets, nil
+}
+
+// writeRollup archives the snapshots of a bucket and deletes the loose files.
+// If an earlier run wrote the archive but was interrupted before deleting
+// them, only the deletion is redone.
+func writeRollup(hostDir string, b *rollupBucket) error {
+	dir := filepath.Join(hostDir, snapshot.RollupDir)
+	name := b.prefixes[0]
+	tarPath := filepath.Join(dir, name+".tar")
+	indexPath := filepath.Join(dir, name+".index.json")
+
+	if data, err := os.ReadFile(indexPath); err == nil {
+		var index snapshot.RollupIndex
+		if err := json.Unmarshal(data, &index); err != nil {
+			return fmt.Errorf("%s: %w", indexPath, err)
+		}
+		removeRolledUp(hostDir, index.Files)
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	index, size, err := writeRollupTar(hostDir, filepath.Join(dir, "."+name+".tar.tmp"), tarPath, b)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if err := snapshot.WriteFileAtomic(filepath.Join(dir, "."+name+".index.json.tmp"), indexPath, data); err != nil {
+		return err
+	}
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	removeRolledUp(hostDir, index.Files)
+	log.Printf("[compact] %s: rolled up %d snapshots (%d files, %.3f MB) into %s/%s.tar",
+		filepath.Base(hostDir), len(index.Snapshots), len(index.Files), float64(size)/1024/1024, snapshot.RollupDir, name)
+	return nil
+}
+
+// writeRollupTar writes the files of a bucket to a tar through a temp file
+// and returns the offset table and tar size
+func writeRollupTar(hostDir, tmp, path string, b *rollupBucket) (*snapshot.RollupIndex, int64, error) {
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	cw := &countingWriter{w: f}
+	tw := tar.NewWriter(cw)
+	index := &snapshot.RollupIndex{Snapshots: b.prefixes}
+	for _, prefix := range b.prefixes {
+		names := b.files[prefix]
+		sort.Strings(names)
+		for _, name := range names {
+			path := filepath.Join(hostDir, name)
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, 0, err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, 0, err
+			}
+			hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: info.ModTime()}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return nil, 0, err
+			}
+			index.Files = append(index.Files, snapshot.RollupFile{Name: name, Offset: cw.n, Size: int64(len(data))})
+			if _, err := tw.Write(data); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, 0, err
+	}
+	if err := f.Sync(); err != nil {
+		return nil, 0, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, 0, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, 0, err
+	}
+	return index, cw.n, nil
+}
+
+// removeRolledUp deletes the loose copies of archived files
+func removeRolledUp(hostDir string, files []snapshot.RollupFile) {
+	for _, file := range files {
+		if err := os.Remove(filepath.Join(hostDir, file.Name)); err != nil && !os.IsNotExist(err) {
+			log.Printf("[compact] ERROR: %v", err)
+		}
+	}
+}
+
+// countingWriter tracks the offset reached in the tar
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
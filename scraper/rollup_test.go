@@ -0,0 +1,149 @@
+package scraper
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gscrape/snapshot"
+)
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRollupIndexRoundTrip(t *testing.T) {
+	base := time.Date(2026, 1, 17, 10, 0, 0, 0, time.UTC)
+	dump1 := "goroutine 1 [running]:\nmain.main()\n\t/src/main.go:10 +0x1d\n\ngoroutine 7 [select]:\nmain.worker()\n\t/src/main.go:20 +0x2a\n"
+	dump2 := "goroutine 1 [running]:\nmain.main()\n\t/src/main.go:11 +0x1d\n\ngoroutine 7 [select]:\nmain.worker()\n\t/src/main.go:20 +0x2a\n"
+
+	type snap struct {
+		at    time.Duration // after base
+		dump  string
+		delta bool // stored as a delta against the previous snapshot
+	}
+	tests := []struct {
+		name     string
+		snaps    []snap
+		archives int
+	}{
+		{"single dump", []snap{{at: 5 * time.Minute, dump: dump1}}, 1},
+		{"two hours", []snap{{at: 5 * time.Minute, dump: dump1}, {at: 65 * time.Minute, dump: dump2}}, 2},
+		{"delta chain spans the hour", []snap{
+			{at: 58 * time.Minute, dump: dump1},
+			{at: 62 * time.Minute, dump: dump2, delta: true},
+			{at: 64 * time.Minute, dump: dump1, delta: true},
+		}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hostDir := t.TempDir()
+			want := make(map[string]string) // dump file name -> dump
+			var prevName, prevDump string
+			for _, s := range tt.snaps {
+				name := snapshot.Name(base.Add(s.at))
+				data, file := []byte(s.dump), name+".goroutines.txt.gz"
+				if s.delta {
+					data, _ = snapshot.EncodeDelta(prevName, []byte(prevDump), []byte(s.dump))
+					file = name + ".goroutines.delta.gz"
+				}
+				if err := os.WriteFile(filepath.Join(hostDir, file), gzipped(t, data), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(hostDir, name+".meta.json"), []byte(`{}`), 0644); err != nil {
+					t.Fatal(err)
+				}
+				want[file] = s.dump
+				prevName, prevDump = name, s.dump
+			}
+
+			compactHost(hostDir, time.Hour, base.Add(24*time.Hour))
+
+			tars, _ := filepath.Glob(filepath.Join(hostDir, snapshot.RollupDir, "*.tar"))
+			if len(tars) != tt.archives {
+				t.Fatalf("got %d archives, want %d", len(tars), tt.archives)
+			}
+			if loose, _ := filepath.Glob(filepath.Join(hostDir, "*.gz")); len(loose) != 0 {
+				t.Errorf("loose files left after rollup: %v", loose)
+			}
+
+			// Every offset in the index points at the data of its tar member
+			indexes, err := snapshot.ReadRollupIndexes(hostDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, index := range indexes {
+				members := readTar(t, filepath.Join(hostDir, snapshot.RollupDir, name+".tar"))
+				if len(index.Files) != len(members) {
+					t.Errorf("%s: index lists %d files, tar has %d", name, len(index.Files), len(members))
+				}
+				raw, err := os.ReadFile(filepath.Join(hostDir, snapshot.RollupDir, name+".tar"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, f := range index.Files {
+					if got := raw[f.Offset : f.Offset+f.Size]; !bytes.Equal(got, members[f.Name]) {
+						t.Errorf("%s: %s at offset %d does not match the tar member", name, f.Name, f.Offset)
+					}
+				}
+			}
+
+			// The dumps read back through the index, deltas rebuilt
+			dir, err := snapshot.OpenDir(hostDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for file, dump := range want {
+				got, err := dir.ReadDump(file)
+				if err != nil {
+					t.Errorf("%s: %v", file, err)
+					continue
+				}
+				if string(got) != dump {
+					t.Errorf("%s: got %q, want %q", file, got, dump)
+				}
+			}
+		})
+	}
+}
+
+// readTar returns the members of a tar by name
+func readTar(t *testing.T, path string) map[string][]byte {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	members := make(map[string][]byte)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return members
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		members[hdr.Name] = data
+	}
+}
@@ -0,0 +1,57 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Runtime metrics are fetched from the target at the same time as the
+// goroutine dump, so GC and heap growth can be lined up with goroutine counts.
+// A target's "runtime_metrics" is "expvar" for /debug/vars (including
+// memstats) or "prometheus" for /metrics; "runtime_metrics_url" overrides the
+// URL, e.g. when metrics are served on another port. The response is stored
+// as it came, gzipped; gindex picks the series it keeps.
+
+// runtimeMetricsFiles is the snapshot file suffix of each kind of runtime metrics
+var runtimeMetricsFiles = map[string]string{
+	"expvar":     ".vars.json.gz",
+	"prometheus": ".metrics.txt.gz",
+}
+
+// runtimeMetricsURL is where a target's runtime metrics are fetched from
+func runtimeMetricsURL(t *Target) string {
+	if t.RuntimeMetricsURL != "" {
+		return t.RuntimeMetricsURL
+	}
+	base := t.URL
+	if idx := strings.Index(base, "/debug/pprof"); idx >= 0 {
+		base = base[:idx]
+	}
+	base = strings.TrimSuffix(base, "/")
+	if t.RuntimeMetrics == "prometheus" {
+		return base + "/metrics"
+	}
+	return base + "/debug/vars"
+}
+
+// validateRuntimeMetrics checks the runtime metrics settings of a target
+func validateRuntimeMetrics(t *Target) error {
+	if t.RuntimeMetrics == "" {
+		if t.RuntimeMetricsURL != "" {
+			return fmt.Errorf("runtime_metrics_url without runtime_metrics")
+		}
+		return nil
+	}
+	if runtimeMetricsFiles[t.RuntimeMetrics] == "" {
+		return fmt.Errorf("runtime_metrics must be expvar or prometheus, not %q", t.RuntimeMetrics)
+	}
+	return nil
+}
+
+// fetchRuntimeMetrics fetches a target's runtime metrics and sends the result
+// on ch, named by the snapshot file suffix it is stored under
+func (s *Scraper) fetchRuntimeMetrics(ctx context.Context, t *Target, ch chan<- profileResult) {
+	res, err := s.fetch(ctx, runtimeMetricsURL(t))
+	ch <- profileResult{name: runtimeMetricsFiles[t.RuntimeMetrics], data: res.body, err: err}
+}
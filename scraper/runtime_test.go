@@ -0,0 +1,100 @@
+package scraper
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gscrape/snapshot"
+)
+
+func TestRuntimeMetricsURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		target Target
+		want   string
+	}{
+		{"expvar", Target{URL: "http://app:6060/debug/pprof/goroutine", RuntimeMetrics: "expvar"}, "http://app:6060/debug/vars"},
+		{"prometheus", Target{URL: "http://app:6060/debug/pprof/goroutine", RuntimeMetrics: "prometheus"}, "http://app:6060/metrics"},
+		{"without pprof path", Target{URL: "http://app:6060/", RuntimeMetrics: "expvar"}, "http://app:6060/debug/vars"},
+		{"override", Target{URL: "http://app:6060/debug/pprof/goroutine", RuntimeMetrics: "prometheus", RuntimeMetricsURL: "http://app:9090/metrics"}, "http://app:9090/metrics"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runtimeMetricsURL(&tt.target); got != tt.want {
+				t.Errorf("runtimeMetricsURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScrapeRuntimeMetrics(t *testing.T) {
+	dump := []byte("goroutine 1 [running]:\nmain.main()\n\t/src/main.go:10 +0x1d\n")
+	vars := []byte(`{"memstats": {"HeapAlloc": 1024}}`)
+	metrics := []byte("go_threads 8\n")
+
+	tests := []struct {
+		name   string
+		kind   string
+		served map[string][]byte // by path
+		file   string            // stored runtime metrics file, "" for none
+		want   []byte
+	}{
+		{"expvar", "expvar", map[string][]byte{"/debug/vars": vars}, ".vars.json.gz", vars},
+		{"prometheus", "prometheus", map[string][]byte{"/metrics": metrics}, ".metrics.txt.gz", metrics},
+		{"not served", "expvar", nil, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/debug/pprof/goroutine" {
+					w.Write(dump)
+					return
+				}
+				if data, ok := tt.served[r.URL.Path]; ok {
+					w.Write(data)
+					return
+				}
+				http.NotFound(w, r)
+			}))
+			defer srv.Close()
+
+			outDir := t.TempDir()
+			s := New(Options{OutDir: outDir})
+			st := s.newTargetState(&Target{URL: srv.URL + "/debug/pprof/goroutine", RuntimeMetrics: tt.kind, KeyframeEvery: 1})
+			st.hostDir = "app"
+			s.scrapeOne(context.Background(), st)
+
+			// The dump is stored either way
+			dir, err := snapshot.OpenDir(filepath.Join(outDir, "app"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			dumps, _ := dir.Glob("*.goroutines.txt.gz")
+			if len(dumps) != 1 {
+				t.Fatalf("stored %d dumps, want 1", len(dumps))
+			}
+			prefix := snapshot.Prefix(dumps[0])
+
+			for _, suffix := range []string{".vars.json.gz", ".metrics.txt.gz"} {
+				data, err := os.ReadFile(filepath.Join(outDir, "app", prefix+suffix))
+				if suffix != tt.file {
+					if err == nil {
+						t.Errorf("stored %s", suffix)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, err := dir.Decompress(prefix+suffix, data); err != nil || !bytes.Equal(got, tt.want) {
+					t.Errorf("stored %q (%v), want %q", got, err, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,369 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// defaultMaxInterval caps adaptive intervals when a target sets no max_interval
+const defaultMaxInterval = time.Hour
+
+// targetState is the scheduling state of one target
+type targetState struct {
+	target   *Target
+	hostDir  string        // output directory name, see targetDir
+	interval time.Duration // current interval, adapted when the target has a budget
+	pinned   bool          // interval set through the control API, not adapted
+	next     time.Time     // when the next scrape is due
+	missed   int           // scheduled scrapes skipped while the previous one ran, reported with the next snapshot
+
+	// Burst state, see checkTriggers
+	lastCount     int       // goroutines in the previous dump, -1 before the first
+	burstReason   string    // trigger that started the current burst
+	burstUntil    time.Time // end of the current burst
+	cooldownUntil time.Time // no new burst before this
+
+	traceCooldownUntil time.Time // no trace captured before this, see checkTrace
+
+	// Dump cost guard, see chooseDump
+	cost    dumpCost
+	skipped int // consecutive rounds skipped for cost
+
+	// Delta storage, see delta.go
+	deltaBase     []byte // last stored debug=2 dump, nil to store the next one in full
+	deltaBaseName string // its snapshot name
+	keyframe      string // snapshot name of the chain's keyframe
+	sinceKeyframe int    // deltas stored since the keyframe
+
+	redact *RedactionProfile // nil if dumps are stored as scraped
+
+	// Runtime control, see control.go
+	cmds        chan func(*targetState) // applied by the target's own loop
+	cancel      context.CancelFunc
+	done        chan struct{} // closed when the loop has exited
+	paused      bool
+	scrapeNow   bool
+	running     bool
+	totalMissed int
+	last        *JournalEntry // last attempt
+	lastSuccess time.Time
+
+	statusMu sync.Mutex
+	status   TargetStatus // published copy of the above for other goroutines
+}
+
+func (s *Scraper) newTargetState(t *Target) *targetState {
+	return &targetState{target: t, interval: s.configuredInterval(t), lastCount: -1, cmds: make(chan func(*targetState), 16), done: make(chan struct{})}
+}
+
+// configuredInterval is the interval a target starts at
+func (s *Scraper) configuredInterval(t *Target) time.Duration {
+	if interval := time.Duration(t.Interval); interval > 0 {
+		return interval
+	}
+	return s.interval
+}
+
+// burstInterval is the target's interval while a trigger is active
+func (st *targetState) burstInterval() time.Duration {
+	if iv := time.Duration(st.target.BurstInterval); iv > 0 {
+		return iv
+	}
+	return defaultBurstInterval
+}
+
+// currentInterval is the interval to the next scrape, shortened during a burst
+func (st *targetState) currentInterval() time.Duration {
+	if st.burstReason != "" && st.burstInterval() < st.interval {
+		return st.burstInterval()
+	}
+	return st.interval
+}
+
+// Run scrapes every target on its own schedule until ctx is cancelled. A
+// target is never scraped twice at once: slots that pass while its previous
+// scrape is still running are skipped and counted as misses. At most
+// s.maxConcurrent scrapes run at the same time. Targets can be added and
+// removed while running (see control.go).
+func (s *Scraper) Run(ctx context.Context, targets []*Target) {
+	if s.maxConcurrent > 0 {
+		s.sem = make(chan struct{}, s.maxConcurrent)
+	}
+
+	s.targetsMu.Lock()
+	s.runCtx = ctx
+	s.targets = make(map[string]*targetState)
+	s.targetsMu.Unlock()
+
+	for _, t := range targets {
+		if _, err := s.AddTarget(t); err != nil {
+			log.Printf("[%s] ERROR: %v", t.URL, err)
+		}
+	}
+
+	<-ctx.Done()
+	s.targetsWg.Wait()
+}
+
+// AddTarget starts scraping a target and returns its state
+func (s *Scraper) AddTarget(t *Target) (*targetState, error) {
+	if err := validateTarget(t); err != nil {
+		return nil, err
+	}
+	if t.MaxDumpMB == 0 {
+		t.MaxDumpMB = s.maxDumpMB
+	}
+	if t.MaxDumpLatency == 0 {
+		t.MaxDumpLatency = Duration(s.maxDumpLatency)
+	}
+	if t.KeyframeEvery == 0 {
+		t.KeyframeEvery = s.keyframeEvery
+	}
+	if t.MaxResponseMB == 0 {
+		t.MaxResponseMB = s.maxResponseMB
+	}
+	if t.Redact == "" {
+		t.Redact = s.defaultRedact
+	}
+	redact, err := s.redactionProfile(t)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := targetDir(t)
+	if err != nil {
+		return nil, err
+	}
+
+	s.targetsMu.Lock()
+	defer s.targetsMu.Unlock()
+
+	if s.runCtx == nil || s.runCtx.Err() != nil {
+		return nil, fmt.Errorf("scraper not running")
+	}
+	if _, ok := s.targets[t.URL]; ok {
+		return nil, fmt.Errorf("duplicate target")
+	}
+	for _, other := range s.targets {
+		if other.hostDir == dir {
+			return nil, fmt.Errorf("output directory %s already used by %s, give one of them an alias", dir, other.target.URL)
+		}
+	}
+	if err := s.writeHostInfo(dir, &HostInfo{Alias: t.Alias, URL: t.URL, Labels: t.Labels}); err != nil {
+		log.Printf("[%s] ERROR: %s: %v", t.displayName(), hostInfoFile, err)
+	}
+
+	st := s.newTargetState(t)
+	st.redact = redact
+	st.hostDir = dir
+	ctx, cancel := context.WithCancel(s.runCtx)
+	st.cancel = cancel
+	// Spread the first scrapes of targets started together
+	st.next = time.Now().Add(time.Duration(rand.Float64() * s.jitter * float64(st.currentInterval())))
+	s.targets[t.URL] = st
+	st.publish()
+
+	s.targetsWg.Add(1)
+	go func() {
+		defer s.targetsWg.Done()
+		defer close(st.done)
+		s.runTarget(ctx, st)
+	}()
+	return st, nil
+}
+
+// RemoveTarget stops scraping a target. A scrape in progress is cancelled,
+// and RemoveTarget returns once it has ended, so the target's output
+// directory is free for a new target.
+func (s *Scraper) RemoveTarget(url string) bool {
+	s.targetsMu.Lock()
+	st, ok := s.targets[url]
+	s.targetsMu.Unlock()
+	if !ok {
+		return false
+	}
+	st.cancel()
+	// The target stays listed until its loop exits, so AddTarget refuses
+	// its URL and directory meanwhile
+	<-st.done
+
+	s.targetsMu.Lock()
+	defer s.targetsMu.Unlock()
+	if s.targets[url] == st {
+		delete(s.targets, url)
+		s.metrics.forget(url)
+	}
+	return true
+}
+
+// target returns the state of a running target, or nil
+func (s *Scraper) target(url string) *targetState {
+	s.targetsMu.Lock()
+	defer s.targetsMu.Unlock()
+	return s.targets[url]
+}
+
+// runTarget is the scheduling loop of a single target. It owns st; other
+// goroutines change it only through st.cmds and read it through st.Status.
+func (s *Scraper) runTarget(ctx context.Context, st *targetState) {
+	timer := time.NewTimer(time.Until(st.next))
+	defer timer.Stop()
+
+	for {
+		scheduled := false
+		select {
+		case <-ctx.Done():
+			return
+		case cmd := <-st.cmds:
+			cmd(st)
+		case <-timer.C:
+			scheduled = true
+		}
+
+		switch {
+		case st.scrapeNow:
+			// One-off scrape, the schedule is left alone
+			st.scrapeNow = false
+			if !s.scrapeLimited(ctx, st) {
+				return
+			}
+		case scheduled && !st.paused:
+			start := time.Now()
+			if !s.scrapeLimited(ctx, st) {
+				return
+			}
+
+			now := time.Now()
+			if missed := s.scheduleNext(st, now); missed > 0 {
+				log.Printf("[%s] MISS: skipped %d scrape(s), previous scrape still running after %s",
+					st.target.URL, missed, now.Sub(start).Round(time.Millisecond))
+			}
+		case scheduled:
+			// Paused: keep the schedule ticking without scraping
+			st.next = time.Now().Add(st.currentInterval())
+		}
+
+		st.publish()
+		timer.Reset(time.Until(st.next))
+	}
+}
+
+// scheduleNext moves st.next to the first slot after now, skipping and
+// counting as missed the slots that passed while the last scrape ran
+func (s *Scraper) scheduleNext(st *targetState, now time.Time) int {
+	missed := 0
+	for {
+		st.next = st.next.Add(s.jittered(st.currentInterval()))
+		if st.next.After(now) {
+			break
+		}
+		missed++
+	}
+	st.missed += missed
+	st.totalMissed += missed
+	return missed
+}
+
+// scrapeLimited scrapes under the global concurrency limit. It returns false
+// if ctx was cancelled while waiting.
+func (s *Scraper) scrapeLimited(ctx context.Context, st *targetState) bool {
+	if !s.acquire(ctx) {
+		return false
+	}
+	defer s.release()
+
+	st.running = true
+	st.publish()
+	s.scrapeOne(ctx, st)
+	st.running = false
+	return true
+}
+
+// acquire takes a slot of the global concurrency limit
+func (s *Scraper) acquire(ctx context.Context) bool {
+	if s.sem == nil {
+		return ctx.Err() == nil
+	}
+	select {
+	case s.sem <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s *Scraper) release() {
+	if s.sem != nil {
+		<-s.sem
+	}
+}
+
+// jittered randomizes an interval by up to ±s.jitter so targets started
+// together drift apart
+func (s *Scraper) jittered(d time.Duration) time.Duration {
+	if s.jitter <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + s.jitter*(2*rand.Float64()-1)))
+}
+
+// adaptInterval picks a target's interval from its recent scrape sizes and
+// durations so that it stays within its bandwidth and wall-time budgets. An
+// interval pinned through the control API is left alone.
+func (s *Scraper) adaptInterval(st *targetState, stats *HostStats) {
+	t := st.target
+	if st.pinned || t.BudgetMBPerHour <= 0 && t.MaxScrapeShare <= 0 {
+		return
+	}
+
+	minInterval := time.Duration(t.MinInterval)
+	if minInterval <= 0 {
+		minInterval = time.Duration(t.Interval)
+	}
+	if minInterval <= 0 {
+		minInterval = s.interval
+	}
+	maxInterval := time.Duration(t.MaxInterval)
+	if maxInterval <= 0 {
+		maxInterval = defaultMaxInterval
+	}
+
+	avgBytes, avgDuration := stats.Averages()
+
+	want := minInterval
+	var reason string
+	if t.BudgetMBPerHour > 0 {
+		// scrapes/hour * bytes/scrape <= budget
+		iv := time.Duration(avgBytes / (t.BudgetMBPerHour * 1024 * 1024) * float64(time.Hour))
+		if iv > want {
+			want = iv
+			reason = "bandwidth budget"
+		}
+	}
+	if t.MaxScrapeShare > 0 {
+		iv := time.Duration(float64(avgDuration) / t.MaxScrapeShare)
+		if iv > want {
+			want = iv
+			reason = "scrape time budget"
+		}
+	}
+	if want > maxInterval {
+		want = maxInterval
+		reason = "max interval"
+	}
+	want = want.Round(time.Second)
+
+	if want == st.interval {
+		return
+	}
+	if reason == "" {
+		reason = "within budget"
+	}
+
+	log.Printf("[%s] interval %s -> %s (%s: %.3f MB and %s per scrape)",
+		t.URL, st.interval, want, reason, avgBytes/1024/1024, avgDuration.Round(time.Millisecond))
+	st.interval = want
+}
@@ -0,0 +1,83 @@
+package scraper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdaptInterval(t *testing.T) {
+	const mb = 1 << 20
+	tests := []struct {
+		name     string
+		target   Target
+		bytes    int64 // per scrape
+		duration time.Duration
+		pinned   bool
+		want     time.Duration
+	}{
+		{"no budget", Target{Interval: Duration(time.Minute)}, 100 * mb, time.Minute, false, 5 * time.Second},
+		{"within budget", Target{Interval: Duration(time.Minute), BudgetMBPerHour: 100}, mb, time.Second, false, time.Minute},
+		{"within budget at min interval", Target{Interval: Duration(time.Minute), MinInterval: Duration(30 * time.Second), BudgetMBPerHour: 100}, mb / 10, time.Second, false, 30 * time.Second},
+		{"bandwidth", Target{Interval: Duration(time.Minute), BudgetMBPerHour: 60}, 10 * mb, time.Second, false, 10 * time.Minute},
+		{"scrape share", Target{Interval: Duration(time.Second), MaxScrapeShare: 0.1}, mb, 2 * time.Second, false, 20 * time.Second},
+		{"larger of both", Target{Interval: Duration(time.Second), BudgetMBPerHour: 60, MaxScrapeShare: 0.1}, 10 * mb, 2 * time.Minute, false, 20 * time.Minute},
+		{"max interval", Target{Interval: Duration(time.Minute), MaxInterval: Duration(5 * time.Minute), BudgetMBPerHour: 60}, 10 * mb, time.Second, false, 5 * time.Minute},
+		{"default max interval", Target{Interval: Duration(time.Minute), BudgetMBPerHour: 1}, 10 * mb, time.Second, false, defaultMaxInterval},
+		{"rounded to seconds", Target{Interval: Duration(time.Second), MaxScrapeShare: 0.3}, mb, time.Second, false, 3 * time.Second},
+		{"pinned", Target{Interval: Duration(time.Minute), BudgetMBPerHour: 60}, 10 * mb, time.Second, true, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(Options{Interval: time.Minute})
+			target := tt.target
+			st := s.newTargetState(&target)
+			st.interval = 5 * time.Second
+			st.pinned = tt.pinned
+
+			stats := &HostStats{}
+			stats.Record(tt.bytes, tt.duration)
+			s.adaptInterval(st, stats)
+			if st.interval != tt.want {
+				t.Errorf("interval %s, want %s", st.interval, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	base := time.Date(2026, 1, 17, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		after    time.Duration // of now after the slot just scraped
+		burst    bool          // 2s burst interval over the 10s interval
+		wantNext time.Duration
+		missed   int
+	}{
+		{"in time", 5 * time.Second, false, 10 * time.Second, 0},
+		{"ended on the next slot", 10 * time.Second, false, 20 * time.Second, 1},
+		{"ran over three slots", 35 * time.Second, false, 40 * time.Second, 3},
+		{"burst", 5 * time.Second, true, 6 * time.Second, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(Options{})
+			st := s.newTargetState(&Target{Interval: Duration(10 * time.Second), BurstInterval: Duration(2 * time.Second)})
+			if tt.burst {
+				st.burstReason = "goroutines > 1000"
+			}
+			st.next = base
+			// One miss not yet reported with a snapshot
+			st.missed, st.totalMissed = 1, 5
+
+			if got := s.scheduleNext(st, base.Add(tt.after)); got != tt.missed {
+				t.Errorf("missed %d, want %d", got, tt.missed)
+			}
+			if got := st.next.Sub(base); got != tt.wantNext {
+				t.Errorf("next after %s, want %s", got, tt.wantNext)
+			}
+			if st.missed != 1+tt.missed || st.totalMissed != 5+tt.missed {
+				t.Errorf("missed %d (%d in total), want %d (%d)", st.missed, st.totalMissed, 1+tt.missed, 5+tt.missed)
+			}
+		})
+	}
+}
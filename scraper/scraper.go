@@ -0,0 +1,694 @@
+// Package scraper periodically fetches goroutine dumps, extra pprof profiles
+// and runtime metrics from Go processes and stores them as snapshots in an
+// output directory, one subdirectory per host. The Receiver stores pushed
+// dumps the same way, and Hooks let an embedding program follow along.
+package scraper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gscrape/snapshot"
+)
+
+// Target describes a single scrape endpoint and what to collect from it
+type Target struct {
+	URL      string   `json:"url"`
+	Alias    string   `json:"alias,omitempty"`    // names the output directory instead of host:port
+	Profiles []string `json:"profiles,omitempty"` // Extra pprof profiles fetched alongside each dump
+
+	// Runtime metrics fetched alongside each dump, see runtime.go
+	RuntimeMetrics    string `json:"runtime_metrics,omitempty"`     // expvar or prometheus
+	RuntimeMetricsURL string `json:"runtime_metrics_url,omitempty"` // default: /debug/vars or /metrics of the target
+
+	// Labels are copied into the metadata of every snapshot of the target
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Scheduling. Interval defaults to Options.Interval. With a budget the
+	// interval is adapted between MinInterval (default: Interval) and MaxInterval.
+	Interval        Duration `json:"interval,omitempty"`
+	MinInterval     Duration `json:"min_interval,omitempty"`
+	MaxInterval     Duration `json:"max_interval,omitempty"`       // default: 1h
+	BudgetMBPerHour float64  `json:"budget_mb_per_hour,omitempty"` // compressed output
+	MaxScrapeShare  float64  `json:"max_scrape_share,omitempty"`   // fraction of wall time spent scraping
+
+	// Burst scraping. When a trigger fires the target is scraped every
+	// BurstInterval for BurstWindow, then no trigger fires for BurstCooldown.
+	Triggers      []*Trigger `json:"triggers,omitempty"`
+	BurstInterval Duration   `json:"burst_interval,omitempty"` // default: 2s
+	BurstWindow   Duration   `json:"burst_window,omitempty"`   // default: 1m
+	BurstCooldown Duration   `json:"burst_cooldown,omitempty"` // default: 5m
+
+	// Execution trace captured once when one of its triggers fires, see trace.go
+	Trace *TraceCapture `json:"trace,omitempty"`
+
+	// Cost ceiling for a full debug=2 dump, estimated from the previous
+	// dump. Above it the target is scraped with debug=1 or skipped.
+	MaxDumpMB      float64  `json:"max_dump_mb,omitempty"`      // raw response size
+	MaxDumpLatency Duration `json:"max_dump_latency,omitempty"` // response time, ~ stop-the-world pause
+
+	// Responses above this many MB are cut off and stored as partial
+	MaxResponseMB float64 `json:"max_response_mb,omitempty"`
+
+	// Redaction profile applied before dumps are written, see redact.go
+	Redact string `json:"redact,omitempty"`
+
+	// Delta storage: every KeyframeEvery-th dump is stored in full, the ones
+	// in between as deltas against the previous snapshot
+	KeyframeEvery int `json:"keyframe_every,omitempty"`
+}
+
+// Duration is a time.Duration read from JSON strings like "30s"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Config is the layout of the -config file
+type Config struct {
+	Targets   []*Target                    `json:"targets"`
+	Redaction map[string]*RedactionProfile `json:"redaction,omitempty"` // by name
+}
+
+// knownProfiles are the pprof profiles that can be collected next to the goroutine dump.
+// "goroutine" fetches the protobuf goroutine profile (debug=0), which unlike
+// the debug=2 text carries pprof labels.
+var knownProfiles = map[string]bool{
+	"goroutine":    true,
+	"heap":         true,
+	"mutex":        true,
+	"block":        true,
+	"threadcreate": true,
+	"allocs":       true,
+}
+
+// LoadConfig reads the config file and merges its targets with endpoints
+// given on the command line. Command line endpoints get the default profile
+// list and runtime metrics.
+func LoadConfig(configPath string, endpoints []string, defaultProfiles []string, defaultRuntime string) (*Config, error) {
+	var cfg Config
+
+	if configPath != "" {
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", configPath, err)
+		}
+	}
+	for name, p := range cfg.Redaction {
+		if err := p.compile(name); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, ep := range endpoints {
+		alias, ep := parseEndpoint(ep)
+		cfg.Targets = append(cfg.Targets, &Target{URL: ep, Alias: alias, Profiles: defaultProfiles, RuntimeMetrics: defaultRuntime})
+	}
+
+	for _, t := range cfg.Targets {
+		if err := validateTarget(t); err != nil {
+			return nil, fmt.Errorf("[%s] %w", t.URL, err)
+		}
+		if t.Redact != "" && cfg.Redaction[t.Redact] == nil {
+			return nil, fmt.Errorf("[%s] unknown redaction profile %q", t.URL, t.Redact)
+		}
+	}
+
+	return &cfg, nil
+}
+
+// validateTarget checks a target from the config file, command line or control API
+func validateTarget(t *Target) error {
+	if t.URL == "" {
+		return fmt.Errorf("target without url")
+	}
+	if _, err := url.Parse(t.URL); err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	if t.Alias != "" {
+		if _, err := pushHostDir(t.Alias); err != nil {
+			return fmt.Errorf("alias: %w", err)
+		}
+	}
+	for _, p := range t.Profiles {
+		if !knownProfiles[p] {
+			return fmt.Errorf("unknown profile %q", p)
+		}
+	}
+	if err := validateRuntimeMetrics(t); err != nil {
+		return err
+	}
+	if t.MaxScrapeShare < 0 || t.MaxScrapeShare >= 1 {
+		return fmt.Errorf("max_scrape_share must be between 0 and 1")
+	}
+	if t.KeyframeEvery < 0 {
+		return fmt.Errorf("keyframe_every must not be negative")
+	}
+	for _, tr := range t.Triggers {
+		if err := tr.validate(); err != nil {
+			return err
+		}
+	}
+	if t.Trace != nil {
+		if err := t.Trace.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HostStats tracks data rate statistics for a single host
+type HostStats struct {
+	mu      sync.Mutex
+	samples []sample
+}
+
+type sample struct {
+	timestamp time.Time
+	bytes     int64
+	duration  time.Duration
+}
+
+// Record adds a new sample and prunes old ones (older than 1 hour)
+func (h *HostStats) Record(bytes int64, duration time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-time.Hour)
+
+	// Prune old samples
+	validIdx := 0
+	for _, s := range h.samples {
+		if s.timestamp.After(cutoff) {
+			h.samples[validIdx] = s
+			validIdx++
+		}
+	}
+	h.samples = h.samples[:validIdx]
+
+	// Add new sample
+	h.samples = append(h.samples, sample{timestamp: now, bytes: bytes, duration: duration})
+}
+
+// Averages returns the mean compressed size and duration of recent scrapes
+func (h *HostStats) Averages() (float64, time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.samples) == 0 {
+		return 0, 0
+	}
+
+	var totalBytes int64
+	var totalDuration time.Duration
+	for _, s := range h.samples {
+		totalBytes += s.bytes
+		totalDuration += s.duration
+	}
+	n := len(h.samples)
+	return float64(totalBytes) / float64(n), totalDuration / time.Duration(n)
+}
+
+// HourlyRate returns the moving average data rate in bytes per hour
+func (h *HostStats) HourlyRate() float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.samples) < 2 {
+		return 0
+	}
+
+	// Calculate total bytes and time span
+	var totalBytes int64
+	for _, s := range h.samples {
+		totalBytes += s.bytes
+	}
+
+	// Time span from first to last sample
+	timeSpan := h.samples[len(h.samples)-1].timestamp.Sub(h.samples[0].timestamp)
+	if timeSpan <= 0 {
+		return 0
+	}
+
+	// Extrapolate to hourly rate
+	hoursElapsed := timeSpan.Hours()
+	return float64(totalBytes) / hoursElapsed
+}
+
+// Options configure a Scraper. Zero values mean no limit unless noted.
+type Options struct {
+	Client        *http.Client // default: http.DefaultClient
+	OutDir        string
+	Interval      time.Duration // for targets that set none
+	Jitter        float64       // fraction of the interval each scrape is randomly shifted by
+	MaxConcurrent int           // scrapes in flight across all targets
+
+	// Defaults for targets that set no cost ceiling, keyframe interval or
+	// response limit
+	MaxDumpMB      float64
+	MaxDumpLatency time.Duration
+	KeyframeEvery  int
+	MaxResponseMB  float64
+
+	// Zstd compression with per-host dictionaries of DictSize bytes,
+	// retrained every DictRetrain, instead of gzip
+	Zstd        bool
+	DictSize    int
+	DictRetrain time.Duration
+
+	Redaction     map[string]*RedactionProfile // by name, see Config
+	DefaultRedact string                       // profile for targets that name none and pushed dumps
+
+	Metrics bool // collect Prometheus metrics, see Scraper.Metrics
+	Hooks   Hooks
+}
+
+// New returns a Scraper with the given options. Targets are added with Run or
+// AddTarget.
+func New(opts Options) *Scraper {
+	s := &Scraper{
+		client:        opts.Client,
+		outDir:        opts.OutDir,
+		interval:      opts.Interval,
+		jitter:        opts.Jitter,
+		maxConcurrent: opts.MaxConcurrent,
+
+		maxDumpMB:      opts.MaxDumpMB,
+		maxDumpLatency: opts.MaxDumpLatency,
+		keyframeEvery:  opts.KeyframeEvery,
+		maxResponseMB:  opts.MaxResponseMB,
+
+		redaction:     opts.Redaction,
+		defaultRedact: opts.DefaultRedact,
+		hooks:         opts.Hooks,
+
+		stats: make(map[string]*HostStats),
+	}
+	if s.client == nil {
+		s.client = http.DefaultClient
+	}
+	if opts.Zstd {
+		s.dicts = newDictStore(opts.DictSize, opts.DictRetrain)
+	}
+	if opts.Metrics {
+		s.metrics = newMetrics(s)
+	}
+	return s
+}
+
+// Metrics returns the scraper's Prometheus metrics, nil unless
+// Options.Metrics was set
+func (s *Scraper) Metrics() *Metrics {
+	return s.metrics
+}
+
+// Scraper scrapes targets on their schedules, see New
+type Scraper struct {
+	client        *http.Client
+	outDir        string
+	interval      time.Duration
+	jitter        float64 // fraction of the interval each scrape is randomly shifted by
+	maxConcurrent int     // scrapes in flight across all targets (0 = no limit)
+	sem           chan struct{}
+
+	// Defaults for targets that set no cost ceiling, keyframe interval or
+	// response limit
+	maxDumpMB      float64
+	maxDumpLatency time.Duration
+	keyframeEvery  int
+	maxResponseMB  float64
+
+	dicts *dictStore // zstd dictionaries, nil unless Options.Zstd
+
+	redaction     map[string]*RedactionProfile // by name
+	defaultRedact string                       // profile for targets that name none and pushed dumps
+
+	metrics *Metrics // nil unless Options.Metrics is set
+	hooks   Hooks
+
+	// Running targets by URL, see Run and control.go
+	targetsMu sync.Mutex
+	targets   map[string]*targetState
+	targetsWg sync.WaitGroup
+	runCtx    context.Context
+
+	statsMu sync.RWMutex
+	stats   map[string]*HostStats
+}
+
+func (s *Scraper) getStats(host string) *HostStats {
+	s.statsMu.RLock()
+	st, ok := s.stats[host]
+	s.statsMu.RUnlock()
+	if ok {
+		return st
+	}
+
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	// Double-check after acquiring write lock
+	if st, ok := s.stats[host]; ok {
+		return st
+	}
+	st = &HostStats{}
+	s.stats[host] = st
+	return st
+}
+
+// profileResult holds the outcome of fetching one extra pprof profile
+type profileResult struct {
+	name string
+	data []byte
+	err  error
+}
+
+func (s *Scraper) scrapeOne(ctx context.Context, st *targetState) {
+	start := time.Now()
+	target := st.target
+	endpoint := target.URL
+
+	hostDir := st.hostDir
+	name := target.displayName()
+
+	// Every attempt is journaled, whatever its outcome
+	entry := &JournalEntry{URL: endpoint, Start: start, Missed: st.missed}
+	st.missed = 0
+	defer func() {
+		entry.DurationMS = time.Since(start).Milliseconds()
+		s.appendJournal(hostDir, entry)
+		s.metrics.observe(entry)
+		st.recordAttempt(entry)
+		s.hooks.attempt(hostDir, entry)
+	}()
+
+	// Fall back to grouped counts, or skip, when a full dump would cost the target too much
+	debug, costReason := s.chooseDump(st)
+	if debug == 0 {
+		log.Printf("[%s] SKIP: %s", name, costReason)
+		entry.Skipped = costReason
+		return
+	}
+	if debug == 1 {
+		entry.Debug = 1
+		log.Printf("[%s] using debug=1: %s", name, costReason)
+	}
+
+	// Fetch extra profiles concurrently so they match the goroutine dump as closely as possible
+	profileCh := make(chan profileResult, len(target.Profiles))
+	for _, name := range target.Profiles {
+		go func(name string) {
+			res, err := s.fetch(ctx, profileURL(endpoint, name))
+			profileCh <- profileResult{name: name, data: res.body, err: err}
+		}(name)
+	}
+	var runtimeCh chan profileResult
+	if target.RuntimeMetrics != "" {
+		runtimeCh = make(chan profileResult, 1)
+		go s.fetchRuntimeMetrics(ctx, target, runtimeCh)
+	}
+
+	// Create output directory: output/<host>/
+	outPath := filepath.Join(s.outDir, hostDir)
+	if err := os.MkdirAll(outPath, 0755); err != nil {
+		log.Printf("[%s] ERROR: failed to create output dir: %v", endpoint, err)
+		entry.Error = err.Error()
+		return
+	}
+
+	// A full dump in a delta chain is needed in memory to encode the next
+	// delta against, all others are streamed to disk as they arrive
+	scan := dumpScanner{found: triggerSignatures(target)}
+	var buf bytes.Buffer
+	var stream *dumpFile
+	sink := io.MultiWriter(&scan, &buf)
+	if debug == 1 || target.KeyframeEvery <= 1 {
+		var err error
+		if stream, err = s.createDumpFile(outPath); err != nil {
+			log.Printf("[%s] ERROR: failed to create file: %v", endpoint, err)
+			entry.Error = err.Error()
+			return
+		}
+		if s.dicts != nil {
+			scan.counts = make(map[string]int)
+		}
+		sink = io.MultiWriter(&scan, stream)
+	}
+
+	var redactor *redactWriter
+	if st.redact != nil {
+		redactor = &redactWriter{p: st.redact, w: sink}
+		sink = redactor
+	}
+
+	fetchStart := time.Now()
+	res, err := s.fetchTo(ctx, goroutineURL(endpoint, debug), sink, int64(target.MaxResponseMB*1024*1024))
+	if err == nil && redactor != nil {
+		err = redactor.flush()
+	}
+	entry.Status = res.status
+	if err != nil {
+		if stream != nil {
+			stream.abort()
+		}
+		log.Printf("[%s] ERROR: %v", endpoint, err)
+		entry.Error = err.Error()
+		return
+	}
+	taken := time.Now()
+	fetchLatency := taken.Sub(fetchStart)
+	scan.flush()
+
+	count := scan.goroutines
+	if debug == 1 {
+		count = countGroupedGoroutines(scan.first)
+	}
+	st.cost.observe(debug, int(res.size), fetchLatency, count)
+	entry.RawBytes = res.size
+	entry.Goroutines = count
+	if res.partial != "" {
+		log.Printf("[%s] PARTIAL: %s", name, res.partial)
+		entry.Partial = res.partial
+	}
+
+	// Write to compressed file: output/<host>/<timestamp>.goroutines.txt.gz
+	// (.zst with -compression zstd), or .goroutines.delta.gz between keyframes
+	snap, err := snapshot.Reserve(outPath, taken)
+	if err != nil {
+		if stream != nil {
+			stream.abort()
+		}
+		log.Printf("[%s] ERROR: failed to create file: %v", endpoint, err)
+		entry.Error = err.Error()
+		return
+	}
+
+	// Partial dumps are stored in full and start a new chain
+	body := buf.Bytes()
+	keyframe := stream != nil || res.partial != "" || st.keyframeDue(outPath)
+	var deltaBase, deltaInfo string
+	var filename string
+	var compressedSize int64
+	if stream != nil {
+		filename, compressedSize, err = s.commitDump(snap, stream, ".goroutines.txt", scan.counts)
+	} else {
+		kind, data := ".goroutines.txt", body
+		if !keyframe {
+			var literal int
+			deltaBase = st.deltaBaseName
+			data, literal = snapshot.EncodeDelta(deltaBase, st.deltaBase, body)
+			kind = ".goroutines.delta"
+			deltaInfo = fmt.Sprintf(" (delta, %d blocks changed)", literal)
+		}
+		filename, compressedSize, err = s.writeDump(snap, kind, body, data)
+	}
+	if err != nil {
+		snap.Abort()
+		log.Printf("[%s] ERROR: failed to write file: %v", endpoint, err)
+		entry.Error = err.Error()
+		return
+	}
+	entry.File = filepath.Base(filename)
+
+	// Grouped and partial dumps can't be a delta base
+	if debug == 1 || res.partial != "" {
+		st.deltaBase = nil
+	} else {
+		st.recordDumpStored(snap.Name(), body, keyframe)
+	}
+
+	// Profiles are already gzipped protobuf, store them as-is next to the dump:
+	// output/<host>/<timestamp>.<profile>.pb.gz
+	var savedProfiles []string
+	for range target.Profiles {
+		r := <-profileCh
+		if r.err != nil {
+			log.Printf("[%s] ERROR: %s profile: %v", endpoint, r.name, r.err)
+			continue
+		}
+		if err := snap.WriteFile("."+r.name+".pb.gz", r.data); err != nil {
+			log.Printf("[%s] ERROR: failed to write %s profile: %v", endpoint, r.name, err)
+			continue
+		}
+		compressedSize += int64(len(r.data))
+		savedProfiles = append(savedProfiles, r.name)
+	}
+
+	// Runtime metrics: output/<host>/<timestamp>.vars.json.gz or .metrics.txt.gz
+	if runtimeCh != nil {
+		r := <-runtimeCh
+		if r.err != nil {
+			log.Printf("[%s] ERROR: %s runtime metrics: %v", endpoint, target.RuntimeMetrics, r.err)
+		} else if size, err := snap.WriteGzipped(r.name, r.data); err != nil {
+			log.Printf("[%s] ERROR: failed to write runtime metrics: %v", endpoint, err)
+		} else {
+			compressedSize += size
+			savedProfiles = append(savedProfiles, target.RuntimeMetrics)
+		}
+	}
+
+	duration := time.Since(start)
+	entry.CompressedBytes = compressedSize
+
+	// Record stats for this host
+	hostStats := s.getStats(hostDir)
+	hostStats.Record(compressedSize, duration)
+	hourlyRate := hostStats.HourlyRate()
+
+	now := time.Now()
+	traceReason := s.checkTrace(st, count, scan.found, now)
+	trigger := s.checkTriggers(st, count, scan.found, now)
+
+	// Execution trace, taken right after the dump that triggered it:
+	// output/<host>/<timestamp>.trace.gz
+	if traceReason != "" {
+		if size, err := s.captureTrace(ctx, st, snap); err != nil {
+			log.Printf("[%s] ERROR: trace: %v", endpoint, err)
+			traceReason = ""
+		} else {
+			compressedSize += size
+			savedProfiles = append(savedProfiles, "trace")
+		}
+	}
+
+	// Snapshot metadata: output/<host>/<timestamp>.meta.json
+	meta := &SnapshotMeta{
+		Time:       snap.Time().UTC(),
+		URL:        endpoint,
+		ServerDate: res.date,
+		LatencyMS:  fetchLatency.Milliseconds(),
+		Labels:     target.Labels,
+		Interval:   st.currentInterval().String(),
+		Trigger:    trigger,
+		Missed:     entry.Missed,
+		Partial:    res.partial,
+		Trace:      traceReason,
+	}
+	if st.redact != nil {
+		meta.Redaction, meta.RedactionDigest = st.redact.name, st.redact.digest
+	}
+	if debug == 1 {
+		meta.Debug = 1
+		meta.Downgrade = costReason
+	}
+	if !keyframe {
+		meta.Base = deltaBase
+		meta.Keyframe = st.keyframe
+	}
+	if err := snap.WriteMeta(meta); err != nil {
+		log.Printf("[%s] ERROR: failed to write metadata: %v", endpoint, err)
+	}
+	if err := snap.Commit(); err != nil {
+		log.Printf("[%s] ERROR: failed to update manifest: %v", endpoint, err)
+	}
+	s.hooks.snapshot(hostDir, filename, meta)
+
+	s.adaptInterval(st, hostStats)
+
+	rawMB := float64(res.size) / 1024 / 1024
+	compMB := float64(compressedSize) / 1024 / 1024
+	hourlyMB := hourlyRate / 1024 / 1024
+
+	extra := ""
+	if len(savedProfiles) > 0 {
+		extra = " +" + strings.Join(savedProfiles, ",")
+	}
+	if trigger != "" {
+		extra += " (triggered)"
+	}
+	if debug == 1 {
+		extra += " (debug=1)"
+	}
+	if res.partial != "" {
+		extra += " (partial)"
+	}
+	extra += deltaInfo
+
+	log.Printf("[%s] OK: %.3f MB (%.3f MB %s) in %s, ~%.1f MB/hr -> %s%s",
+		name, rawMB, compMB, filepath.Ext(filename)[1:], duration.Round(time.Millisecond), hourlyMB, filename, extra)
+}
+
+// fetchResult is what fetch got back, also on error
+type fetchResult struct {
+	body    []byte
+	size    int64  // bytes of the body read
+	partial string // why the body is incomplete, see fetchTo
+	status  int    // 0 if no response was received
+	date    string // Date header of the response
+}
+
+// fetch performs a GET request and returns the whole response body, HTTP
+// status and server date
+func (s *Scraper) fetch(ctx context.Context, u string) (fetchResult, error) {
+	var buf bytes.Buffer
+	res, err := s.fetchTo(ctx, u, &buf, 0)
+	if err == nil && res.partial != "" {
+		err = fmt.Errorf("failed to read response: %s", res.partial)
+	}
+	res.body = buf.Bytes()
+	return res, err
+}
+
+// profileURL builds the URL of a named pprof profile for an endpoint.
+// The endpoint may be a bare base URL or point at the goroutine handler.
+func profileURL(endpoint, name string) string {
+	base := endpoint
+	if idx := strings.Index(base, "/debug/pprof"); idx >= 0 {
+		base = base[:idx]
+	}
+	return strings.TrimSuffix(base, "/") + "/debug/pprof/" + name
+}
+
+// sanitizeHost converts a host:port string into a safe directory name
+func sanitizeHost(host string) string {
+	// Replace colons with underscores for Windows compatibility
+	return strings.ReplaceAll(host, ":", "_")
+}
@@ -0,0 +1,10 @@
+package scraper
+
+import "gscrape/snapshot"
+
+// Snapshots are written with snapshot.Writer: each file through a hidden temp
+// file and rename, then the snapshot is listed in the host's manifest.jsonl.
+
+// SnapshotMeta is the <timestamp>.meta.json sidecar stored next to each dump,
+// see snapshot.Meta
+type SnapshotMeta = snapshot.Meta
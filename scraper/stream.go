@@ -0,0 +1,251 @@
+package scraper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/DataDog/zstd"
+
+	"gscrape/snapshot"
+)
+
+// Goroutine dumps are streamed from the response through the compressor into
+// a hidden temp file, which is moved into place once the snapshot is
+// reserved, so a scrape never holds the whole dump in memory. Only dumps that
+// take part in a delta chain are still read into memory (see delta.go).
+//
+// A response larger than the target's MaxResponseMB, or one cut off by a read
+// error, is stored up to that point and marked partial in its metadata and
+// journal entry.
+
+// maxScanLine is how much of a line dumpScanner looks at
+const maxScanLine = 4 << 10
+
+// fetchTo performs a GET request and copies the response body to w, up to
+// maxBytes (0 = no limit). A body cut off by the limit or by a read error
+// after some data was copied is not an error: res.partial says why it is
+// incomplete.
+func (s *Scraper) fetchTo(ctx context.Context, u string, w io.Writer, maxBytes int64) (fetchResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return fetchResult{}, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fetchResult{}, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	res := fetchResult{status: resp.StatusCode, date: resp.Header.Get("Date")}
+	if resp.StatusCode != http.StatusOK {
+		return res, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	// Write errors (a full disk) fail the scrape, read errors may not
+	ew := &errWriter{w: w}
+	if maxBytes > 0 {
+		res.size, err = io.CopyN(ew, resp.Body, maxBytes)
+		if err == nil {
+			var more [1]byte
+			if n, _ := io.ReadFull(resp.Body, more[:]); n > 0 {
+				res.partial = fmt.Sprintf("response truncated at %.3f MB", float64(maxBytes)/1024/1024)
+			}
+		} else if err == io.EOF {
+			err = nil
+		}
+	} else {
+		res.size, err = io.Copy(ew, resp.Body)
+	}
+	switch {
+	case ew.err != nil:
+		return res, ew.err
+	case err != nil && (res.size == 0 || ctx.Err() != nil):
+		return res, fmt.Errorf("failed to read response: %w", err)
+	case err != nil:
+		res.partial = fmt.Sprintf("read failed after %d bytes: %v", res.size, err)
+	}
+	return res, nil
+}
+
+// errWriter remembers the error of its underlying writer
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) Write(p []byte) (int, error) {
+	n, err := e.w.Write(p)
+	if err != nil && e.err == nil {
+		e.err = err
+	}
+	return n, err
+}
+
+// dumpScanner inspects a dump as it streams past: it counts the goroutine
+// headers of a debug=2 dump, keeps the first line, which holds the total of a
+// debug=1 dump, marks the trigger signatures in found that occur in it and,
+// when counts is set, counts stack lines for the zstd dictionary. Lines
+// longer than maxScanLine are cut.
+type dumpScanner struct {
+	goroutines int
+	first      []byte
+	lines      int
+	counts     map[string]int
+	found      map[string]bool // trigger signature -> seen
+
+	line []byte // incomplete line carried over to the next write
+}
+
+func (d *dumpScanner) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			d.carry(p)
+			break
+		}
+		if len(d.line) > 0 {
+			d.carry(p[:i])
+			d.scanLine(d.line)
+			d.line = d.line[:0]
+		} else {
+			d.scanLine(p[:min(i, maxScanLine)])
+		}
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// flush scans the last line of a dump without a trailing newline
+func (d *dumpScanner) flush() {
+	if len(d.line) > 0 {
+		d.scanLine(d.line)
+		d.line = d.line[:0]
+	}
+}
+
+func (d *dumpScanner) carry(p []byte) {
+	d.line = append(d.line, p[:min(len(p), maxScanLine-len(d.line))]...)
+}
+
+func (d *dumpScanner) scanLine(line []byte) {
+	if d.lines == 0 {
+		d.first = bytes.Clone(line)
+	}
+	d.lines++
+	if bytes.HasPrefix(line, []byte("goroutine ")) {
+		d.goroutines++
+	}
+	if d.counts != nil {
+		countStackLine(d.counts, line)
+	}
+	for sig, seen := range d.found {
+		if !seen && bytes.Contains(line, []byte(sig)) {
+			d.found[sig] = true
+		}
+	}
+}
+
+// hashedFile is a file that hashes what is written to it for the manifest
+type hashedFile struct {
+	*os.File
+	hash hash.Hash
+	size int64
+}
+
+func (f *hashedFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	f.hash.Write(p[:n])
+	f.size += int64(n)
+	return n, err
+}
+
+// dumpFile compresses a streamed dump into a hidden temp file
+type dumpFile struct {
+	out *hashedFile
+	zw  io.WriteCloser
+	ext string // ".gz", or ".zst" with -compression zstd
+}
+
+// createDumpFile starts a streamed dump in dir. With -compression zstd it is
+// compressed against the host's current dictionary.
+func (s *Scraper) createDumpFile(dir string) (*dumpFile, error) {
+	f, err := os.CreateTemp(dir, ".dump-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	df := &dumpFile{out: &hashedFile{File: f, hash: sha256.New()}}
+
+	if s.dicts == nil {
+		df.ext = ".gz"
+		df.zw, err = gzip.NewWriterLevel(df.out, gzip.BestCompression)
+	} else {
+		df.ext = ".zst"
+		name, dict := s.dicts.current(dir)
+		if err = snapshot.WriteDictHeader(df.out, name); err == nil {
+			df.zw = zstd.NewWriterLevelDict(df.out, zstdLevel, dict)
+		}
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return df, nil
+}
+
+func (df *dumpFile) Write(p []byte) (int, error) {
+	return df.zw.Write(p)
+}
+
+// abort discards a streamed dump that won't be stored
+func (df *dumpFile) abort() {
+	df.zw.Close()
+	df.out.Close()
+	os.Remove(df.out.Name())
+}
+
+// commit finishes the compressed stream and moves it into place as the
+// snapshot file <kind>.gz or <kind>.zst. It returns the file path and stored size.
+func (df *dumpFile) commit(snap *snapshot.Writer, kind string) (string, int64, error) {
+	err := df.zw.Close()
+	if err == nil {
+		err = df.out.Sync()
+	}
+	if cerr := df.out.Close(); err == nil {
+		err = cerr
+	}
+	path := snap.Path(kind + df.ext)
+	if err == nil {
+		err = os.Rename(df.out.Name(), path)
+	}
+	if err != nil {
+		os.Remove(df.out.Name())
+		return "", 0, err
+	}
+
+	snap.AddFile(snapshot.ManifestFile{Name: snap.Name() + kind + df.ext, Size: df.out.size, SHA256: hex.EncodeToString(df.out.hash.Sum(nil))})
+	return path, df.out.size, nil
+}
+
+// commitDump moves a streamed dump into place and lets the host's zstd
+// dictionary learn from the stack lines counted while it streamed
+func (s *Scraper) commitDump(snap *snapshot.Writer, df *dumpFile, kind string, counts map[string]int) (string, int64, error) {
+	path, size, err := df.commit(snap, kind)
+	if err != nil {
+		return "", 0, err
+	}
+	if s.dicts != nil {
+		s.dicts.learn(snap.Dir(), snap.Name(), counts)
+	}
+	return path, size, nil
+}
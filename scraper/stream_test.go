@@ -0,0 +1,130 @@
+package scraper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// dumpOf returns the first size bytes of a debug=2 dump
+func dumpOf(size int) []byte {
+	var b bytes.Buffer
+	for b.Len() < size {
+		b.WriteString("goroutine 1 [select]:\nmain.worker()\n\t/src/app/worker.go:31 +0x1f\n\n")
+	}
+	return b.Bytes()[:size]
+}
+
+func TestFetchToLimit(t *testing.T) {
+	const limit = 64 << 10
+	tests := []struct {
+		name    string
+		size    int
+		limit   int64
+		want    int
+		partial bool
+	}{
+		{"under the limit", limit - 1, limit, limit - 1, false},
+		{"at the limit", limit, limit, limit, false},
+		{"over the limit", 3 * limit, limit, limit, true},
+		{"no limit", 3 * limit, 0, 3 * limit, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := dumpOf(tt.size)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write(body)
+			}))
+			defer srv.Close()
+
+			var buf bytes.Buffer
+			res, err := New(Options{}).fetchTo(context.Background(), srv.URL, &buf, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buf.Len() != tt.want || res.size != int64(tt.want) {
+				t.Errorf("copied %d bytes (size %d), want %d", buf.Len(), res.size, tt.want)
+			}
+			if !bytes.Equal(buf.Bytes(), body[:tt.want]) {
+				t.Error("copied bytes differ from the response")
+			}
+			if got := res.partial != ""; got != tt.partial {
+				t.Errorf("partial %q, want partial = %v", res.partial, tt.partial)
+			}
+		})
+	}
+}
+
+func TestFetchToCutOff(t *testing.T) {
+	// Promises more than it sends, then drops the connection
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100000")
+		w.Write(dumpOf(1000))
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	res, err := New(Options{}).fetchTo(context.Background(), srv.URL, &buf, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 1000 || !strings.HasPrefix(res.partial, "read failed after 1000 bytes") {
+		t.Errorf("copied %d bytes, partial %q", buf.Len(), res.partial)
+	}
+}
+
+func TestScrapeOversizedResponse(t *testing.T) {
+	const maxMB = 1
+	dump := dumpOf(maxMB<<20 + 100<<10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(dump)
+	}))
+	defer srv.Close()
+
+	outDir := t.TempDir()
+	s := New(Options{OutDir: outDir, MaxResponseMB: maxMB, KeyframeEvery: 1})
+	st := s.newTargetState(&Target{URL: srv.URL, MaxResponseMB: maxMB, KeyframeEvery: 1})
+	st.hostDir = "app"
+	s.scrapeOne(context.Background(), st)
+
+	matches, _ := filepath.Glob(filepath.Join(outDir, "app", "*.goroutines.txt.gz"))
+	if len(matches) != 1 {
+		t.Fatalf("stored %d dumps, want 1", len(matches))
+	}
+	f, err := os.Open(matches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, dump[:maxMB<<20]) {
+		t.Errorf("stored %d bytes, want the first %d of the response", len(stored), maxMB<<20)
+	}
+
+	data, err := os.ReadFile(strings.TrimSuffix(matches[0], ".goroutines.txt.gz") + ".meta.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta SnapshotMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.Partial != "response truncated at 1.000 MB" {
+		t.Errorf("partial %q", meta.Partial)
+	}
+}
@@ -0,0 +1,107 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"gscrape/snapshot"
+)
+
+// Trace capture defaults
+const (
+	defaultTraceSeconds  = 5
+	defaultTraceCooldown = 30 * time.Minute
+	maxTraceSeconds      = 60
+)
+
+// TraceCapture fetches a runtime/trace from the target once when one of its
+// triggers fires against a dump. The trace is taken right after that dump and
+// stored with its snapshot as <timestamp>.trace.gz, so the scrape takes
+// Seconds longer. No trigger fires again for Cooldown.
+type TraceCapture struct {
+	Seconds  int        `json:"seconds,omitempty"`  // default: 5
+	Cooldown Duration   `json:"cooldown,omitempty"` // default: 30m
+	Triggers []*Trigger `json:"triggers"`
+}
+
+func (c *TraceCapture) validate() error {
+	if len(c.Triggers) == 0 {
+		return fmt.Errorf("trace without triggers")
+	}
+	if c.Seconds < 0 || c.Seconds > maxTraceSeconds {
+		return fmt.Errorf("trace seconds must be between 0 and %d", maxTraceSeconds)
+	}
+	for _, tr := range c.Triggers {
+		if err := tr.validate(); err != nil {
+			return fmt.Errorf("trace: %w", err)
+		}
+	}
+	return nil
+}
+
+// checkTrace evaluates the target's trace triggers against the latest dump,
+// before checkTriggers moves on the previous count. It returns why a trace
+// should be captured now, or "".
+func (s *Scraper) checkTrace(st *targetState, count int, found map[string]bool, now time.Time) string {
+	c := st.target.Trace
+	if c == nil || now.Before(st.traceCooldownUntil) {
+		return ""
+	}
+	for _, tr := range c.Triggers {
+		if reason := tr.check(st.lastCount, count, found); reason != "" {
+			cooldown := time.Duration(c.Cooldown)
+			if cooldown <= 0 {
+				cooldown = defaultTraceCooldown
+			}
+			st.traceCooldownUntil = now.Add(cooldown)
+			log.Printf("[%s] trace trigger fired: %s", st.target.displayName(), reason)
+			return reason
+		}
+	}
+	return ""
+}
+
+// fetchTrace fetches an execution trace of the given length. The request may
+// take that much longer than the client timeout of other requests.
+func (s *Scraper) fetchTrace(ctx context.Context, endpoint string, seconds int) ([]byte, error) {
+	client := *s.client
+	if client.Timeout > 0 {
+		client.Timeout += time.Duration(seconds) * time.Second
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s?seconds=%d", profileURL(endpoint, "trace"), seconds), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return data, nil
+}
+
+// captureTrace fetches a trace for a triggered snapshot and stores it as
+// <timestamp>.trace.gz. It returns the compressed size.
+func (s *Scraper) captureTrace(ctx context.Context, st *targetState, snap *snapshot.Writer) (int64, error) {
+	seconds := st.target.Trace.Seconds
+	if seconds <= 0 {
+		seconds = defaultTraceSeconds
+	}
+	data, err := s.fetchTrace(ctx, st.target.URL, seconds)
+	if err != nil {
+		return 0, err
+	}
+	return snap.WriteGzipped(".trace.gz", data)
+}
@@ -0,0 +1,138 @@
+package scraper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gscrape/snapshot"
+)
+
+func TestCheckTrace(t *testing.T) {
+	base := time.Date(2026, 1, 17, 12, 0, 0, 0, time.UTC)
+	const reason = "count 1001 above 1000"
+
+	// Scrapes of one target in order, with a 10m cooldown
+	steps := []struct {
+		name  string
+		at    time.Duration
+		count int
+		want  string
+	}{
+		{"quiet", 0, 500, ""},
+		{"fires", time.Minute, 1001, reason},
+		{"cooldown", 2 * time.Minute, 1001, ""},
+		{"cooldown ends", 11 * time.Minute, 1001, reason},
+	}
+
+	s := New(Options{})
+	st := s.newTargetState(&Target{Trace: &TraceCapture{Cooldown: Duration(10 * time.Minute), Triggers: []*Trigger{{Above: 1000}}}})
+	for _, tt := range steps {
+		if got := s.checkTrace(st, tt.count, nil, base.Add(tt.at)); got != tt.want {
+			t.Fatalf("%s: trace trigger %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if got := s.checkTrace(s.newTargetState(&Target{}), 5000, nil, base); got != "" {
+		t.Errorf("target without trace capture fired %q", got)
+	}
+}
+
+func TestFetchTrace(t *testing.T) {
+	trace := []byte("go 1.22 trace\x00\x00\x00")
+	var seconds string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/debug/pprof/trace" {
+			http.NotFound(w, r)
+			return
+		}
+		seconds = r.URL.Query().Get("seconds")
+		w.Write(trace)
+	}))
+	defer srv.Close()
+
+	s := New(Options{})
+	data, err := s.fetchTrace(context.Background(), srv.URL+"/debug/pprof/goroutine", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, trace) || seconds != "3" {
+		t.Errorf("fetched %q for seconds=%s, want %q for seconds=3", data, seconds, trace)
+	}
+
+	if _, err := s.fetchTrace(context.Background(), srv.URL+"/other/goroutine", 3); err == nil {
+		t.Error("fetched a trace the target doesn't serve")
+	}
+}
+
+func TestScrapeCapturesTrace(t *testing.T) {
+	dump := []byte("goroutine 1 [running]:\nmain.main()\n\t/src/main.go:10 +0x1d\n\n" +
+		"goroutine 2 [select]:\nmain.worker()\n\t/src/main.go:20 +0x2a\n")
+	trace := []byte("go 1.22 trace\x00\x00\x00")
+	traces := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/debug/pprof/goroutine":
+			w.Write(dump)
+		case "/debug/pprof/trace":
+			traces++
+			w.Write(trace)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	outDir := t.TempDir()
+	s := New(Options{OutDir: outDir})
+	st := s.newTargetState(&Target{
+		URL:           srv.URL + "/debug/pprof/goroutine",
+		KeyframeEvery: 1,
+		Trace:         &TraceCapture{Seconds: 1, Triggers: []*Trigger{{Above: 1}}},
+	})
+	st.hostDir = "app"
+
+	// The second scrape falls in the cooldown
+	s.scrapeOne(context.Background(), st)
+	s.scrapeOne(context.Background(), st)
+	if traces != 1 {
+		t.Fatalf("fetched %d traces, want 1", traces)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(outDir, "app", "*.trace.gz"))
+	if len(matches) != 1 {
+		t.Fatalf("stored %d traces, want 1", len(matches))
+	}
+	prefix := strings.TrimSuffix(matches[0], ".trace.gz")
+	dir, err := snapshot.OpenDir(filepath.Join(outDir, "app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(matches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := dir.Decompress(filepath.Base(matches[0]), data); err != nil || !bytes.Equal(got, trace) {
+		t.Errorf("stored trace %q (%v), want %q", got, err, trace)
+	}
+
+	// Stored with the snapshot of the dump that fired it
+	metaData, err := os.ReadFile(prefix + ".meta.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta SnapshotMeta
+	if err := json.Unmarshal(metaData, &meta); err != nil {
+		t.Fatal(err)
+	}
+	if meta.Trace != "count 2 above 1" {
+		t.Errorf("trace trigger %q in the metadata", meta.Trace)
+	}
+}
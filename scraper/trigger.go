@@ -0,0 +1,115 @@
+package scraper
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Burst defaults for targets with triggers
+const (
+	defaultBurstInterval = 2 * time.Second
+	defaultBurstWindow   = time.Minute
+	defaultBurstCooldown = 5 * time.Minute
+)
+
+// Trigger is a rule that switches a target to its burst interval, or
+// captures a trace (see trace.go). Exactly one of RisePercent, Above and
+// Signature is set.
+type Trigger struct {
+	RisePercent float64 `json:"rise_percent,omitempty"` // count rose by this much since the previous scrape
+	Above       int     `json:"above,omitempty"`        // count is above this
+	MinCount    int     `json:"min_count,omitempty"`    // ignore rises below this count
+	Signature   string  `json:"signature,omitempty"`    // text found in a line of the dump, e.g. a function name
+}
+
+func (t *Trigger) validate() error {
+	set := 0
+	for _, ok := range []bool{t.RisePercent > 0, t.Above > 0, t.Signature != ""} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("trigger needs exactly one of rise_percent, above and signature")
+	}
+	return nil
+}
+
+// check returns a description of why the rule fires for the given counts and
+// the signatures found in the dump, or "" if it doesn't. prev is -1 when there
+// is no previous scrape.
+func (t *Trigger) check(prev, cur int, found map[string]bool) string {
+	switch {
+	case t.Signature != "" && found[t.Signature]:
+		return fmt.Sprintf("%q in dump", t.Signature)
+	case t.Above > 0 && cur > t.Above:
+		return fmt.Sprintf("count %d above %d", cur, t.Above)
+	case t.RisePercent > 0 && prev > 0 && cur >= t.MinCount:
+		rise := float64(cur-prev) / float64(prev) * 100
+		if rise >= t.RisePercent {
+			return fmt.Sprintf("count rose %.0f%% (%d -> %d)", rise, prev, cur)
+		}
+	}
+	return ""
+}
+
+// checkTriggers evaluates the target's trigger rules against the goroutine
+// count of the latest dump, starting a burst when one fires. It returns the
+// trigger description to record with the snapshot, which is non-empty for
+// every snapshot taken during a burst.
+func (s *Scraper) checkTriggers(st *targetState, count int, found map[string]bool, now time.Time) string {
+	t := st.target
+	prev := st.lastCount
+	st.lastCount = count
+
+	if st.burstReason != "" && !now.Before(st.burstUntil) {
+		log.Printf("[%s] burst ended, back to every %s", t.URL, st.interval)
+		st.burstReason = ""
+	}
+	if st.burstReason != "" || now.Before(st.cooldownUntil) {
+		return st.burstReason
+	}
+
+	for _, tr := range t.Triggers {
+		reason := tr.check(prev, count, found)
+		if reason == "" {
+			continue
+		}
+
+		window := time.Duration(t.BurstWindow)
+		if window <= 0 {
+			window = defaultBurstWindow
+		}
+		cooldown := time.Duration(t.BurstCooldown)
+		if cooldown <= 0 {
+			cooldown = defaultBurstCooldown
+		}
+		st.burstReason = reason
+		st.burstUntil = now.Add(window)
+		st.cooldownUntil = st.burstUntil.Add(cooldown)
+
+		log.Printf("[%s] trigger fired: %s, scraping every %s for %s", t.URL, reason, st.burstInterval(), window)
+		return reason
+	}
+	return ""
+}
+
+// triggerSignatures returns the signatures the target's trigger rules look
+// for, all not found yet, or nil if there are none
+func triggerSignatures(t *Target) map[string]bool {
+	var found map[string]bool
+	rules := t.Triggers
+	if t.Trace != nil {
+		rules = append(rules[:len(rules):len(rules)], t.Trace.Triggers...)
+	}
+	for _, tr := range rules {
+		if tr.Signature != "" {
+			if found == nil {
+				found = make(map[string]bool)
+			}
+			found[tr.Signature] = false
+		}
+	}
+	return found
+}
@@ -0,0 +1,94 @@
+package scraper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTriggerCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		trigger   Trigger
+		prev, cur int
+		found     map[string]bool
+		want      string
+	}{
+		{"above", Trigger{Above: 1000}, 900, 1001, nil, "count 1001 above 1000"},
+		{"at threshold", Trigger{Above: 1000}, 900, 1000, nil, ""},
+		{"rise", Trigger{RisePercent: 50}, 100, 150, nil, "count rose 50% (100 -> 150)"},
+		{"small rise", Trigger{RisePercent: 50}, 100, 149, nil, ""},
+		{"fall", Trigger{RisePercent: 50}, 150, 100, nil, ""},
+		{"rise below min count", Trigger{RisePercent: 50, MinCount: 500}, 100, 200, nil, ""},
+		{"rise at min count", Trigger{RisePercent: 50, MinCount: 200}, 100, 200, nil, "count rose 100% (100 -> 200)"},
+		{"rise from nothing", Trigger{RisePercent: 50}, 0, 200, nil, ""},
+		{"rise on first scrape", Trigger{RisePercent: 50}, -1, 200, nil, ""},
+		{"signature found", Trigger{Signature: "main.leak"}, 10, 10, map[string]bool{"main.leak": true}, `"main.leak" in dump`},
+		{"signature not found", Trigger{Signature: "main.leak"}, 10, 10, map[string]bool{"main.leak": false}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.trigger.check(tt.prev, tt.cur, tt.found); got != tt.want {
+				t.Errorf("check(%d, %d) = %q, want %q", tt.prev, tt.cur, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckTriggers(t *testing.T) {
+	base := time.Date(2026, 1, 17, 12, 0, 0, 0, time.UTC)
+	const reason = "count 1001 above 1000"
+
+	// Scrapes of one target in order, with a 1m window and 5m cooldown
+	steps := []struct {
+		name  string
+		at    time.Duration
+		count int
+		want  string // recorded with the snapshot
+		burst bool   // burst interval in effect after the scrape
+	}{
+		{"quiet", 0, 500, "", false},
+		{"fires", 10 * time.Second, 1001, reason, true},
+		{"burst goes on below threshold", 12 * time.Second, 500, reason, true},
+		{"last scrape of the window", 69 * time.Second, 500, reason, true},
+		{"window expired", 70 * time.Second, 500, "", false},
+		{"cooldown", 2 * time.Minute, 2000, "", false},
+		{"cooldown ends", 70*time.Second + 5*time.Minute, 2000, "count 2000 above 1000", true},
+	}
+
+	s := New(Options{})
+	st := s.newTargetState(&Target{
+		Interval:      Duration(time.Minute),
+		Triggers:      []*Trigger{{Above: 1000}},
+		BurstInterval: Duration(2 * time.Second),
+		BurstWindow:   Duration(time.Minute),
+		BurstCooldown: Duration(5 * time.Minute),
+	})
+	for _, tt := range steps {
+		if got := s.checkTriggers(st, tt.count, nil, base.Add(tt.at)); got != tt.want {
+			t.Fatalf("%s: trigger %q, want %q", tt.name, got, tt.want)
+		}
+		if burst := st.currentInterval() == 2*time.Second; burst != tt.burst {
+			t.Fatalf("%s: interval %s", tt.name, st.currentInterval())
+		}
+		if st.lastCount != tt.count {
+			t.Fatalf("%s: last count %d, want %d", tt.name, st.lastCount, tt.count)
+		}
+	}
+}
+
+func TestCheckTriggersRise(t *testing.T) {
+	s := New(Options{})
+	st := s.newTargetState(&Target{Triggers: []*Trigger{{RisePercent: 100}}})
+	now := time.Date(2026, 1, 17, 12, 0, 0, 0, time.UTC)
+
+	// The first scrape has nothing to compare with, the rise is measured
+	// against the previous scrape only
+	for i, count := range []int{1000, 1500, 2500} {
+		if got := s.checkTriggers(st, count, nil, now.Add(time.Duration(i)*time.Minute)); got != "" {
+			t.Fatalf("scrape %d: fired %q", i+1, got)
+		}
+	}
+	if got, want := s.checkTriggers(st, 5000, nil, now.Add(3*time.Minute)), "count rose 100% (2500 -> 5000)"; got != want {
+		t.Fatalf("trigger %q, want %q", got, want)
+	}
+}
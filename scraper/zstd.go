@@ -0,0 +1,306 @@
+package scraper
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/zstd"
+
+	"gscrape/snapshot"
+)
+
+// With -compression zstd, dumps are stored as <timestamp>.goroutines.txt.zst
+// (deltas as .goroutines.delta.zst), compressed against a dictionary trained
+// on the host's recent dumps and named in a header frame, see snapshot/zstd.go.
+//
+// The dictionaries are raw content: the stack lines repeated most often, the
+// most valuable last, where matches against them are cheapest to encode.
+const (
+	zstdLevel    = 9
+	maxDictLines = 1 << 16 // distinct lines counted between trainings
+)
+
+// dictStore holds the dictionary of every host directory dumps are written to
+type dictStore struct {
+	size    int           // dictionary size in bytes
+	retrain time.Duration // age after which a dictionary is replaced
+
+	mu    sync.Mutex
+	hosts map[string]*hostDict
+}
+
+// hostDict is the current dictionary of a host directory and the line counts
+// the next one is trained from
+type hostDict struct {
+	mu      sync.Mutex
+	name    string // "" before the first training
+	dict    []byte
+	trained time.Time
+	counts  map[string]int
+}
+
+func newDictStore(size int, retrain time.Duration) *dictStore {
+	return &dictStore{size: size, retrain: retrain, hosts: make(map[string]*hostDict)}
+}
+
+// get returns the dictionary state of a host directory, loading the newest
+// dictionary stored there the first time
+func (ds *dictStore) get(hostDir string) *hostDict {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	d := ds.hosts[hostDir]
+	if d == nil {
+		d = &hostDict{counts: make(map[string]int)}
+		names, err := listDicts(hostDir)
+		if err != nil {
+			log.Printf("[%s] ERROR: dictionaries: %v", filepath.Base(hostDir), err)
+		}
+		if len(names) > 0 {
+			name := names[len(names)-1]
+			dict, err := os.ReadFile(snapshot.DictPath(hostDir, name))
+			if err != nil {
+				log.Printf("[%s] ERROR: dictionary %s: %v", filepath.Base(hostDir), name, err)
+			} else {
+				d.name, d.dict = name, dict
+				d.trained, _ = snapshot.ParseName(name)
+			}
+		}
+		ds.hosts[hostDir] = d
+	}
+	return d
+}
+
+// compress counts the lines of dump, retrains the host's dictionary when it
+// is missing or older than ds.retrain, and compresses data (the dump or its
+// delta encoding) against it. snapName names a new dictionary.
+func (ds *dictStore) compress(hostDir, snapName string, dump, data []byte) ([]byte, error) {
+	d := ds.get(hostDir)
+
+	d.mu.Lock()
+	for _, line := range bytes.Split(dump, []byte("\n")) {
+		countStackLine(d.counts, line)
+	}
+	ds.trainIfDue(d, hostDir, snapName)
+	name, dict := d.name, d.dict
+	d.mu.Unlock()
+
+	return encodeZstd(data, name, dict)
+}
+
+// current returns the dictionary a streamed dump of a host directory is
+// compressed against. The first dump of a host is stored without one.
+func (ds *dictStore) current(hostDir string) (string, []byte) {
+	d := ds.get(hostDir)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.name, d.dict
+}
+
+// learn adds the stack lines counted in a streamed dump and retrains the
+// host's dictionary when it is missing or older than ds.retrain
+func (ds *dictStore) learn(hostDir, snapName string, counts map[string]int) {
+	d := ds.get(hostDir)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for line, n := range counts {
+		if _, ok := d.counts[line]; ok || len(d.counts) < maxDictLines {
+			d.counts[line] += n
+		}
+	}
+	ds.trainIfDue(d, hostDir, snapName)
+}
+
+// trainIfDue trains a new dictionary for d, which must be locked, when it has
+// none or its current one is older than ds.retrain
+func (ds *dictStore) trainIfDue(d *hostDict, hostDir, snapName string) {
+	if d.dict == nil || time.Since(d.trained) >= ds.retrain {
+		if err := d.train(hostDir, snapName, ds.size); err != nil {
+			log.Printf("[%s] ERROR: failed to train dictionary: %v", filepath.Base(hostDir), err)
+		}
+	}
+}
+
+// countStackLine counts a line of a dump towards the next dictionary.
+// Goroutine header lines carry IDs and wait times, which never repeat.
+func countStackLine(counts map[string]int, line []byte) {
+	if len(line) == 0 || bytes.HasPrefix(line, []byte("goroutine ")) {
+		return
+	}
+	if _, ok := counts[string(line)]; ok || len(counts) < maxDictLines {
+		counts[string(line)]++
+	}
+}
+
+// train builds a dictionary of up to size bytes from the lines counted since
+// the last training and stores it as dicts/<name>.zdict
+func (d *hostDict) train(hostDir, name string, size int) error {
+	type scored struct {
+		line  string
+		score int
+	}
+	var lines []scored
+	for line, n := range d.counts {
+		if n > 1 {
+			lines = append(lines, scored{line, n * (len(line) + 1)})
+		}
+	}
+	if len(lines) == 0 {
+		return nil // nothing repeats yet
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].score != lines[j].score {
+			return lines[i].score > lines[j].score
+		}
+		return lines[i].line < lines[j].line
+	})
+
+	n, total := 0, 0
+	for n < len(lines) && total+len(lines[n].line)+1 <= size {
+		total += len(lines[n].line) + 1
+		n++
+	}
+	var buf bytes.Buffer
+	for i := n - 1; i >= 0; i-- {
+		buf.WriteString(lines[i].line)
+		buf.WriteByte('\n')
+	}
+
+	dir := filepath.Join(hostDir, snapshot.DictDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := snapshot.WriteFileAtomic(filepath.Join(dir, "."+name+snapshot.DictExt+".tmp"), snapshot.DictPath(hostDir, name), buf.Bytes()); err != nil {
+		return err
+	}
+
+	d.name, d.dict = name, buf.Bytes()
+	d.trained = time.Now()
+	d.counts = make(map[string]int)
+	log.Printf("[%s] trained dictionary %s (%d lines, %d bytes)", filepath.Base(hostDir), name, n, buf.Len())
+	return nil
+}
+
+// encodeZstd compresses data against dict, prefixed by a skippable frame
+// naming the dictionary ("" for none)
+func encodeZstd(data []byte, dictName string, dict []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	snapshot.WriteDictHeader(&buf, dictName)
+
+	if len(dict) == 0 {
+		dict = nil
+	}
+	zw := zstd.NewWriterLevelDict(&buf, zstdLevel, dict)
+	if _, err := zw.Write(data); err != nil {
+		zw.Close()
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeDump stores data, a dump or its delta encoding, as the snapshot file
+// <kind>.gz, or <kind>.zst with -compression zstd. dump is the full dump the
+// host's dictionary learns from. It returns the file path and stored size.
+func (s *Scraper) writeDump(snap *snapshot.Writer, kind string, dump, data []byte) (string, int64, error) {
+	if s.dicts == nil {
+		size, err := snap.WriteGzipped(kind+".gz", data)
+		return snap.Path(kind + ".gz"), size, err
+	}
+
+	compressed, err := s.dicts.compress(snap.Dir(), snap.Name(), dump, data)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := snap.WriteFile(kind+".zst", compressed); err != nil {
+		return "", 0, err
+	}
+	return snap.Path(kind + ".zst"), int64(len(compressed)), nil
+}
+
+// listDicts returns the names of the dictionaries of a host directory, oldest first
+func listDicts(hostDir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(hostDir, snapshot.DictDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if name, ok := strings.CutSuffix(e.Name(), snapshot.DictExt); ok && !strings.HasPrefix(name, ".") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// pruneDicts deletes the dictionaries of a host directory that no remaining
+// dump refers to. The newest is kept for the next dump, and so are recent
+// ones a dump still being compressed may refer to.
+func pruneDicts(hostDir string) {
+	names, err := listDicts(hostDir)
+	if err != nil || len(names) < 2 {
+		return
+	}
+
+	used := make(map[string]bool)
+	for _, pattern := range []string{"*.goroutines.txt.zst", "*.goroutines.delta.zst"} {
+		files, _ := filepath.Glob(filepath.Join(hostDir, pattern))
+		for _, path := range files {
+			f, err := os.Open(path)
+			if err != nil {
+				continue
+			}
+			if name, err := snapshot.ReadDictName(f); err == nil {
+				used[name] = true
+			}
+			f.Close()
+		}
+	}
+
+	// Dumps rolled up into archives
+	indexes, err := snapshot.ReadRollupIndexes(hostDir)
+	if err != nil {
+		return
+	}
+	for archive, index := range indexes {
+		f, err := os.Open(filepath.Join(hostDir, snapshot.RollupDir, archive+".tar"))
+		if err != nil {
+			continue
+		}
+		for _, file := range index.Files {
+			if strings.HasSuffix(file.Name, ".zst") {
+				if name, err := snapshot.ReadDictName(io.NewSectionReader(f, file.Offset, file.Size)); err == nil {
+					used[name] = true
+				}
+			}
+		}
+		f.Close()
+	}
+
+	for _, name := range names[:len(names)-1] {
+		path := snapshot.DictPath(hostDir, name)
+		info, err := os.Stat(path)
+		if used[name] || err != nil || time.Since(info.ModTime()) < time.Hour {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			log.Printf("[retention] ERROR: %v", err)
+			continue
+		}
+		log.Printf("[retention] %s: removed unused dictionary %s", filepath.Base(hostDir), name)
+	}
+}
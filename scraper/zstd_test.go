@@ -0,0 +1,89 @@
+package scraper
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gscrape/snapshot"
+)
+
+// workerDump returns a dump of n goroutines waiting in fn
+func workerDump(fn string, n int) []byte {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		b.WriteString("goroutine " + strconv.Itoa(i) + " [select]:\n")
+		b.WriteString(fn + "(0xc000123456)\n\t/src/app/" + fn + ".go:31 +0x1f\n")
+		b.WriteString("created by main.main in goroutine 1\n\t/src/app/main.go:12 +0x3b\n\n")
+	}
+	return []byte(b.String())
+}
+
+func TestDictStoreRoundTrip(t *testing.T) {
+	hostDir := t.TempDir()
+	now := time.Now()
+	first, second := snapshot.Name(now.Add(-time.Minute)), snapshot.Name(now)
+	dumpA, dumpB := workerDump("main.alpha", 50), workerDump("main.beta", 50)
+
+	// The first dump trains the dictionary it is compressed against
+	ds := newDictStore(4096, time.Hour)
+	dataA, err := ds.compress(hostDir, first, dumpA, dumpA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, err := snapshot.ReadDictName(bytes.NewReader(dataA)); err != nil || name != first {
+		t.Fatalf("frame names dictionary %q (%v), want %q", name, err, first)
+	}
+	stored, err := os.ReadFile(snapshot.DictPath(hostDir, first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(stored, []byte("main.alpha(0xc000123456)")) {
+		t.Errorf("dictionary lacks the repeated stack lines: %q", stored)
+	}
+
+	// A new store (a restarted scraper) picks the dictionary up again
+	reloaded := newDictStore(4096, time.Hour)
+	if name, dict := reloaded.current(hostDir); name != first || !bytes.Equal(dict, stored) {
+		t.Fatalf("reloaded dictionary %q (%d bytes), want %q (%d bytes)", name, len(dict), first, len(stored))
+	}
+	if data, err := reloaded.compress(hostDir, second, dumpA, dumpA); err != nil {
+		t.Fatal(err)
+	} else if name, _ := snapshot.ReadDictName(bytes.NewReader(data)); name != first {
+		t.Errorf("compressed against %q before the dictionary is due, want %q", name, first)
+	}
+
+	// Retraining writes a new dictionary; dumps compressed against the old
+	// one still decode
+	reloaded.retrain = 0
+	dataB, err := reloaded.compress(hostDir, second, dumpB, dumpB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := snapshot.ReadDictName(bytes.NewReader(dataB)); name != second {
+		t.Fatalf("compressed against %q after retraining, want %q", name, second)
+	}
+	names, err := listDicts(hostDir)
+	if err != nil || len(names) != 2 {
+		t.Fatalf("dictionaries %v (%v), want 2", names, err)
+	}
+
+	dir, err := snapshot.OpenDir(hostDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		data, want []byte
+	}{{dataA, dumpA}, {dataB, dumpB}} {
+		got, err := dir.Decompress(first+".goroutines.txt.zst", tt.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("decoded %d bytes, want %d", len(got), len(tt.want))
+		}
+	}
+}
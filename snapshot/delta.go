@@ -0,0 +1,129 @@
+package snapshot
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A delta file (<timestamp>.goroutines.delta.gz) stores a debug=2 dump
+// relative to the previous snapshot of its host. The dump is split into its
+// goroutine blocks at blank lines; the delta is a header line followed by
+// items, also separated by blank lines:
+//
+//	gscrape delta v1 base=2026-01-17T14-33-01-123Z
+//
+//	=1 2 7           blocks of these goroutine IDs are unchanged in the base
+//
+//	goroutine 9 [select]:
+//	...              a new or changed block, stored as-is
+//
+// A stored block starting with '=' or '\' gets a '\' prepended, so it is not
+// read as references. Goroutines missing from the delta have exited. Joining
+// the blocks in item order with blank lines gives back the exact dump.
+const DeltaMagic = "gscrape delta v1 base="
+
+// IsDelta reports whether a snapshot file holds a delta dump
+func IsDelta(name string) bool {
+	return strings.HasSuffix(name, ".goroutines.delta.gz") || strings.HasSuffix(name, ".goroutines.delta.zst")
+}
+
+// EncodeDelta encodes dump against base, the dump stored as snapshot
+// baseName. It returns the delta and the number of blocks stored in full.
+func EncodeDelta(baseName string, base, dump []byte) ([]byte, int) {
+	baseBlocks := splitBlocks(base)
+
+	var buf bytes.Buffer
+	buf.WriteString(DeltaMagic + baseName)
+
+	literal := 0
+	inRefs := false
+	for _, block := range bytes.Split(dump, []byte("\n\n")) {
+		if id, ok := blockID(block); ok && bytes.Equal(baseBlocks[id], block) {
+			if inRefs {
+				buf.WriteByte(' ')
+			} else {
+				buf.WriteString("\n\n=")
+				inRefs = true
+			}
+			buf.WriteString(id)
+			continue
+		}
+		buf.WriteString("\n\n")
+		if len(block) > 0 && (block[0] == '=' || block[0] == '\\') {
+			buf.WriteByte('\\')
+		}
+		buf.Write(block)
+		inRefs = false
+		literal++
+	}
+	return buf.Bytes(), literal
+}
+
+// DeltaBase returns the name of the snapshot a delta was encoded against
+func DeltaBase(delta []byte) (string, error) {
+	header, _, _ := bytes.Cut(delta, []byte("\n"))
+	name, ok := bytes.CutPrefix(header, []byte(DeltaMagic))
+	if !ok {
+		return "", fmt.Errorf("not a delta dump")
+	}
+	return string(name), nil
+}
+
+// DecodeDelta rebuilds a dump from a delta. base is the dump of the snapshot
+// named baseName; the delta must have been encoded against it.
+func DecodeDelta(delta []byte, baseName string, base []byte) ([]byte, error) {
+	name, err := DeltaBase(delta)
+	if err != nil {
+		return nil, err
+	}
+	if name != baseName {
+		return nil, fmt.Errorf("delta against %s, but base %q is not available", name, baseName)
+	}
+
+	baseBlocks := splitBlocks(base)
+	var blocks [][]byte
+	for _, item := range bytes.Split(delta, []byte("\n\n"))[1:] {
+		if block, ok := bytes.CutPrefix(item, []byte(`\`)); ok {
+			blocks = append(blocks, block)
+			continue
+		}
+		refs, ok := bytes.CutPrefix(item, []byte("="))
+		if !ok {
+			blocks = append(blocks, item)
+			continue
+		}
+		for _, id := range strings.Fields(string(refs)) {
+			block, ok := baseBlocks[id]
+			if !ok {
+				return nil, fmt.Errorf("goroutine %s not in base %s", id, baseName)
+			}
+			blocks = append(blocks, block)
+		}
+	}
+	return bytes.Join(blocks, []byte("\n\n")), nil
+}
+
+// splitBlocks returns the goroutine blocks of a dump by ID
+func splitBlocks(dump []byte) map[string][]byte {
+	blocks := make(map[string][]byte)
+	for _, block := range bytes.Split(dump, []byte("\n\n")) {
+		if id, ok := blockID(block); ok {
+			blocks[id] = block
+		}
+	}
+	return blocks
+}
+
+// blockID returns the goroutine ID of a block starting with "goroutine <id> ["
+func blockID(block []byte) (string, bool) {
+	rest, ok := bytes.CutPrefix(block, []byte("goroutine "))
+	if !ok {
+		return "", false
+	}
+	id, _, ok := strings.Cut(string(rest[:min(len(rest), 24)]), " ")
+	if !ok || id == "" || strings.Trim(id, "0123456789") != "" {
+		return "", false
+	}
+	return id, true
+}
@@ -0,0 +1,67 @@
+package snapshot
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// block returns a debug=2 goroutine block of goroutine id blocked in fn
+func block(id, fn string) string {
+	return "goroutine " + id + " [select]:\nmain." + fn + "()\n\t/src/main.go:20 +0x2a"
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	join := func(blocks ...string) string { return strings.Join(blocks, "\n\n") }
+	base := join(block("1", "main"), block("7", "wait"), block("8", "wait"))
+
+	tests := []struct {
+		name    string
+		dump    string
+		literal int // blocks stored in full
+	}{
+		{"unchanged", base, 0},
+		{"added", join(block("1", "main"), block("7", "wait"), block("8", "wait"), block("9", "read")), 1},
+		{"removed", join(block("1", "main"), block("8", "wait")), 0},
+		{"changed", join(block("1", "main"), block("7", "read"), block("8", "wait")), 1},
+		{"reordered", join(block("8", "wait"), block("1", "main"), block("7", "wait")), 0},
+		{"trailing newline", base + "\n", 1},
+		{"literal starting with =", join("=1 7", block("1", "main"), block("7", "wait")), 1},
+		{"literal starting with backslash", join(`\=1`, block("1", "main"), `\`), 2},
+		{"empty", "", 1},
+		{"blank lines", "\n\n\n\n" + block("1", "main") + "\n\n\n", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delta, literal := EncodeDelta("base", []byte(base), []byte(tt.dump))
+			if literal != tt.literal {
+				t.Errorf("%d blocks stored in full, want %d", literal, tt.literal)
+			}
+			if name, err := DeltaBase(delta); err != nil || name != "base" {
+				t.Fatalf("DeltaBase = %q, %v", name, err)
+			}
+			got, err := DecodeDelta(delta, "base", []byte(base))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, []byte(tt.dump)) {
+				t.Fatalf("decoded %q, want %q\ndelta: %q", got, tt.dump, delta)
+			}
+		})
+	}
+}
+
+func TestDecodeDeltaErrors(t *testing.T) {
+	base := []byte(block("1", "main"))
+	delta, _ := EncodeDelta("base", base, base)
+
+	if _, err := DecodeDelta(delta, "other", base); err == nil {
+		t.Error("decoded against the wrong base")
+	}
+	if _, err := DecodeDelta(delta, "base", []byte(block("2", "main"))); err == nil {
+		t.Error("decoded with a reference missing from the base")
+	}
+	if _, err := DecodeDelta([]byte("goroutine 1 [running]:"), "base", base); err == nil {
+		t.Error("decoded a dump without delta header")
+	}
+}
@@ -0,0 +1,201 @@
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrCorrupt is wrapped by errors about files that can't be decoded
+var ErrCorrupt = errors.New("corrupt")
+
+// Dir reads the snapshot files of a host directory. Files rolled up into an
+// archive are found and read as if they were still loose in the directory.
+type Dir struct {
+	path     string
+	archived map[string]archivedFile // by file name
+
+	mu    sync.Mutex
+	dicts map[string][]byte // zstd dictionaries by name
+}
+
+// archivedFile locates a snapshot file inside an archive
+type archivedFile struct {
+	tar string
+	RollupFile
+}
+
+// OpenDir reads the offset tables of the archives of a host directory. If
+// they can't be read, the returned Dir still reads the loose files.
+func OpenDir(path string) (*Dir, error) {
+	d := &Dir{path: path, archived: make(map[string]archivedFile), dicts: make(map[string][]byte)}
+	indexes, err := ReadRollupIndexes(path)
+	if err != nil {
+		return d, err
+	}
+	for name, index := range indexes {
+		tar := filepath.Join(path, RollupDir, name+".tar")
+		for _, file := range index.Files {
+			d.archived[file.Name] = archivedFile{tar: tar, RollupFile: file}
+		}
+	}
+	return d, nil
+}
+
+// Path is the host directory
+func (d *Dir) Path() string {
+	return d.path
+}
+
+// Glob returns the names of the snapshot files matching pattern, loose or
+// archived, sorted
+func (d *Dir) Glob(pattern string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(d.path, pattern))
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(paths))
+	names := make([]string, 0, len(paths))
+	for _, path := range paths {
+		name := filepath.Base(path)
+		seen[name] = true
+		names = append(names, name)
+	}
+	for name := range d.archived {
+		if ok, err := filepath.Match(pattern, name); err != nil {
+			return nil, err
+		} else if ok && !seen[name] {
+			// Still loose too if compaction was interrupted
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ReadFile reads a snapshot file, loose or out of its archive. A file that is
+// neither gives an error wrapping fs.ErrNotExist.
+func (d *Dir) ReadFile(name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(d.path, name))
+	if !errors.Is(err, fs.ErrNotExist) {
+		return data, err
+	}
+	a, ok := d.archived[name]
+	if !ok {
+		return nil, err
+	}
+	f, err := os.Open(a.tar)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data = make([]byte, a.Size)
+	if _, err := f.ReadAt(data, a.Offset); err != nil {
+		return nil, fmt.Errorf("%s: %w", a.tar, err)
+	}
+	return data, nil
+}
+
+// Decompress decodes the contents of a gzip or zstd snapshot file by its
+// name. Errors in the data wrap ErrCorrupt; a missing zstd dictionary gives
+// an error wrapping fs.ErrNotExist.
+func (d *Dir) Decompress(name string, data []byte) ([]byte, error) {
+	if strings.HasSuffix(name, ".zst") {
+		return decodeZstd(data, d.dict)
+	}
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	defer gr.Close()
+
+	out, err := io.ReadAll(gr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return out, nil
+}
+
+// dict loads a zstd dictionary of the host once
+func (d *Dir) dict(name string) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if dict, ok := d.dicts[name]; ok {
+		return dict, nil
+	}
+	dict, err := os.ReadFile(DictPath(d.path, name))
+	if err != nil {
+		return nil, err
+	}
+	d.dicts[name] = dict
+	return dict, nil
+}
+
+// DumpFile returns the name of the file the dump of a snapshot is stored in
+func (d *Dir) DumpFile(prefix string) (string, error) {
+	for _, suffix := range DumpSuffixes {
+		name := prefix + suffix
+		if _, ok := d.archived[name]; ok {
+			return name, nil
+		}
+		if _, err := os.Stat(filepath.Join(d.path, name)); err == nil {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("dump of %s: %w", prefix, fs.ErrNotExist)
+}
+
+// ReadDump reads and decompresses a dump file, rebuilding a delta from the
+// chain of snapshots it was encoded against. That reads the whole chain back
+// to its keyframe; use ReadDumpAfter to read a chain in order.
+func (d *Dir) ReadDump(name string) ([]byte, error) {
+	return d.ReadDumpAfter(name, "", nil)
+}
+
+// ReadDumpAfter is ReadDump for a file following prevName, whose dump is
+// prev: a delta against prevName is decoded against prev rather than by
+// reading the chain again. Reading a chain in order decodes each file once.
+func (d *Dir) ReadDumpAfter(name, prevName string, prev []byte) ([]byte, error) {
+	data, err := d.ReadFile(name)
+	if err == nil {
+		data, err = d.Decompress(name, data)
+	}
+	if err != nil || !IsDelta(name) {
+		return data, err
+	}
+
+	baseName, err := DeltaBase(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if baseName == prevName && prev != nil {
+		dump, err := DecodeDelta(data, baseName, prev)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		return dump, nil
+	}
+	baseFile, err := d.DumpFile(baseName)
+	if err != nil {
+		return nil, err
+	}
+	base, err := d.ReadDump(baseFile)
+	if err != nil {
+		return nil, fmt.Errorf("base %s: %w", baseName, err)
+	}
+	dump, err := DecodeDelta(data, baseName, base)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return dump, nil
+}
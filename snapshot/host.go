@@ -0,0 +1,12 @@
+package snapshot
+
+// HostInfoFile is written in each host directory by gscrape with the
+// identity of the target whose data is in it
+const HostInfoFile = "host.json"
+
+// HostInfo is the layout of host.json
+type HostInfo struct {
+	Alias  string            `json:"alias,omitempty"`
+	URL    string            `json:"url,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
@@ -0,0 +1,24 @@
+package snapshot
+
+import "time"
+
+// JournalFile is appended to in every host directory by gscrape, one JSON
+// line per scrape attempt
+const JournalFile = "journal.jsonl"
+
+// JournalEntry records one scrape attempt, successful or not
+type JournalEntry struct {
+	URL             string    `json:"url"`
+	Start           time.Time `json:"start"`
+	DurationMS      int64     `json:"duration_ms"`
+	Status          int       `json:"status,omitempty"` // HTTP status of the dump request
+	Error           string    `json:"error,omitempty"`
+	Skipped         string    `json:"skipped,omitempty"` // cost guard reason when not scraped at all
+	Missed          int       `json:"missed,omitempty"`  // scheduled scrapes skipped before this one
+	Debug           int       `json:"debug,omitempty"`   // 1 when downgraded
+	Partial         string    `json:"partial,omitempty"` // why the stored dump is incomplete
+	RawBytes        int64     `json:"raw_bytes,omitempty"`
+	CompressedBytes int64     `json:"compressed_bytes,omitempty"` // including extra profiles
+	Goroutines      int       `json:"goroutines,omitempty"`
+	File            string    `json:"file,omitempty"`
+}
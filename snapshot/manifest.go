@@ -0,0 +1,131 @@
+package snapshot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ManifestName is the append-only list of completed snapshots in each host
+// directory. Files of a snapshot only count once its line is there.
+const ManifestName = "manifest.jsonl"
+
+// manifestMu serializes manifest appends within the process
+var manifestMu sync.Mutex
+
+// ManifestEntry is a line of manifest.jsonl: a completed snapshot with the
+// checksums of its files, or the removal of one by retention
+type ManifestEntry struct {
+	Snapshot string         `json:"snapshot"` // file prefix
+	Time     time.Time      `json:"time,omitzero"`
+	Files    []ManifestFile `json:"files,omitempty"`
+	Removed  bool           `json:"removed,omitempty"`
+}
+
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// FileRecord returns the manifest record of a file holding data
+func FileRecord(name string, data []byte) ManifestFile {
+	sum := sha256.Sum256(data)
+	return ManifestFile{Name: name, Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])}
+}
+
+// AppendManifest adds an entry to the manifest of a host directory
+func AppendManifest(dir string, entry *ManifestEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	f, err := os.OpenFile(filepath.Join(dir, ManifestName), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// CompactManifest rewrites the manifest of a host directory without the
+// snapshots retention removed, once their lines make up most of it. The first
+// listing stays, followed by its removal if it is gone, as it dates the
+// manifest: unlisted files older than it predate the manifest.
+func CompactManifest(dir string) error {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	path := filepath.Join(dir, ManifestName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var first *ManifestEntry
+	var firstLine []byte
+	var order []string
+	live := make(map[string][]byte)
+	lines := 0
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var e ManifestEntry
+		if json.Unmarshal(line, &e) != nil {
+			continue // empty or torn
+		}
+		lines++
+		if e.Removed {
+			delete(live, e.Snapshot)
+			continue
+		}
+		if first == nil {
+			first, firstLine = &e, line
+		}
+		if _, ok := live[e.Snapshot]; !ok {
+			order = append(order, e.Snapshot)
+		}
+		live[e.Snapshot] = line
+	}
+	// The first listing and its removal may be kept
+	if first == nil || lines-len(live) <= len(live)+2 {
+		return nil
+	}
+
+	var out bytes.Buffer
+	if _, ok := live[first.Snapshot]; !ok {
+		removal, err := json.Marshal(&ManifestEntry{Snapshot: first.Snapshot, Removed: true})
+		if err != nil {
+			return err
+		}
+		out.Write(firstLine)
+		out.WriteByte('\n')
+		out.Write(removal)
+		out.WriteByte('\n')
+	}
+	for _, name := range order {
+		if line, ok := live[name]; ok {
+			out.Write(line)
+			out.WriteByte('\n')
+			delete(live, name)
+		}
+	}
+	return WriteFileAtomic(filepath.Join(dir, "."+ManifestName+".tmp"), path, out.Bytes())
+}
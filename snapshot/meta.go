@@ -0,0 +1,36 @@
+package snapshot
+
+import "time"
+
+// Meta is stored next to each dump as <timestamp>.meta.json. gindex takes the
+// snapshot time from Time rather than from the file name.
+type Meta struct {
+	Time       time.Time         `json:"time"`                  // when the dump was received, UTC
+	URL        string            `json:"url,omitempty"`         // scraped endpoint
+	ServerDate string            `json:"server_date,omitempty"` // Date header of the target's response
+	LatencyMS  int64             `json:"latency_ms,omitempty"`  // time to fetch the dump
+	Labels     map[string]string `json:"labels,omitempty"`      // target labels from the config
+
+	Interval string `json:"interval,omitempty"` // scrape interval in effect for the target
+	Trigger  string `json:"trigger,omitempty"`  // trigger rule behind a burst snapshot
+	Missed   int    `json:"missed,omitempty"`   // scrapes skipped before this one because the previous was still running
+	Trace    string `json:"trace,omitempty"`    // trace trigger behind the snapshot's .trace.gz
+
+	// Debug is 1 when the dump was downgraded to grouped counts (debug=1)
+	// because a full dump would have exceeded the target's cost ceiling
+	Debug     int    `json:"debug,omitempty"`
+	Downgrade string `json:"downgrade,omitempty"`
+
+	// Partial says why the dump is incomplete: the response exceeded the
+	// target's size limit or broke off. Its last goroutine is likely cut off.
+	Partial string `json:"partial,omitempty"`
+
+	// Redaction profile the dump was rewritten with and the digest of its rules
+	Redaction       string `json:"redaction,omitempty"`
+	RedactionDigest string `json:"redaction_digest,omitempty"`
+
+	// Base and Keyframe name the previous snapshot a delta dump is encoded
+	// against and the full dump its chain starts from
+	Base     string `json:"base,omitempty"`
+	Keyframe string `json:"keyframe,omitempty"`
+}
@@ -0,0 +1,48 @@
+// Package snapshot is gscrape's on-disk format: snapshot names, the
+// manifest, delta and zstd encoded dumps and hourly rollup archives. Writer
+// stores a snapshot the way gscrape does, and Dir reads the files of a host
+// directory back, loose or archived, decoding any dump.
+package snapshot
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LegacyFormat is the local-time, second-precision prefix of snapshots
+// written by older versions. It is still read, but never written.
+const LegacyFormat = "2006-01-02T15-04-05"
+
+// Name returns the file prefix shared by all files of a snapshot taken at t:
+// UTC with milliseconds, e.g. 2026-01-17T14-33-01-123Z. Names sort in time
+// order and are unaffected by DST.
+func Name(t time.Time) string {
+	t = t.UTC()
+	return fmt.Sprintf("%s-%03dZ", t.Format(LegacyFormat), t.Nanosecond()/int(time.Millisecond))
+}
+
+// ParseName parses a snapshot file prefix, either as written by Name or in
+// the legacy local-time format
+func ParseName(s string) (time.Time, error) {
+	if base, ok := strings.CutSuffix(s, "Z"); ok && len(base) == len(LegacyFormat)+4 && base[len(LegacyFormat)] == '-' {
+		t, err := time.Parse(LegacyFormat, base[:len(LegacyFormat)])
+		if err != nil {
+			return time.Time{}, err
+		}
+		ms, err := strconv.Atoi(base[len(LegacyFormat)+1:])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid milliseconds in %q", s)
+		}
+		return t.Add(time.Duration(ms) * time.Millisecond), nil
+	}
+	return time.ParseInLocation(LegacyFormat, s, time.Local)
+}
+
+// Prefix returns the snapshot name a file belongs to
+func Prefix(path string) string {
+	prefix, _, _ := strings.Cut(filepath.Base(path), ".")
+	return prefix
+}
@@ -0,0 +1,55 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Completed hours of snapshots can be rolled up into one uncompressed tar per
+// host and hour, <host>/rollups/<first snapshot>.tar, with an offset table in
+// <first snapshot>.index.json next to it. Readers seek straight to a file
+// instead of scanning the tar, and the member files keep their names, so the
+// manifest still describes them.
+//
+// An archive always starts at a full dump: deltas are rolled up with the hour
+// of their keyframe, so a chain never spans two archives.
+const RollupDir = "rollups"
+
+// RollupIndex is the offset table of an archive
+type RollupIndex struct {
+	Snapshots []string     `json:"snapshots"` // file prefixes, oldest first
+	Files     []RollupFile `json:"files"`
+}
+
+// RollupFile locates a snapshot file inside an archive
+type RollupFile struct {
+	Name   string `json:"name"`
+	Offset int64  `json:"offset"` // of the file data, past its tar header
+	Size   int64  `json:"size"`
+}
+
+// ReadRollupIndexes returns the offset tables of a host's archives by archive name
+func ReadRollupIndexes(hostDir string) (map[string]*RollupIndex, error) {
+	paths, err := filepath.Glob(filepath.Join(hostDir, RollupDir, "*.index.json"))
+	if err != nil {
+		return nil, err
+	}
+	indexes := make(map[string]*RollupIndex)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var index RollupIndex
+		if err := json.Unmarshal(data, &index); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if len(index.Snapshots) > 0 {
+			indexes[strings.TrimSuffix(filepath.Base(path), ".index.json")] = &index
+		}
+	}
+	return indexes, nil
+}
@@ -0,0 +1,179 @@
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DumpSuffixes are the file suffixes a snapshot's dump may be stored under
+var DumpSuffixes = []string{".goroutines.txt.gz", ".goroutines.delta.gz", ".goroutines.txt.zst", ".goroutines.delta.zst"}
+
+// Writer writes the files of one snapshot. Each file is written to a hidden
+// temp file, fsynced and renamed into place; Commit then records the snapshot
+// in the manifest.
+type Writer struct {
+	dir   string
+	name  string    // file prefix, see Name
+	time  time.Time // what name stands for
+	files []ManifestFile
+}
+
+// Reserve claims a snapshot name in dir for a dump taken at t by creating a
+// hidden reservation file. If the name is taken, t is moved forward a
+// millisecond at a time, so concurrent writers never overwrite each other.
+func Reserve(dir string, t time.Time) (*Writer, error) {
+	t = t.Truncate(time.Millisecond)
+	for {
+		w := &Writer{dir: dir, name: Name(t), time: t}
+		f, err := os.OpenFile(w.TempPath(""), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			f.Close()
+			if !w.exists() {
+				return w, nil
+			}
+			os.Remove(w.TempPath(""))
+		} else if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		t = t.Add(time.Millisecond)
+	}
+}
+
+// exists reports whether a dump was already stored under the snapshot's name
+func (w *Writer) exists() bool {
+	for _, suffix := range DumpSuffixes {
+		if _, err := os.Stat(w.Path(suffix)); !errors.Is(err, fs.ErrNotExist) {
+			return true
+		}
+	}
+	return false
+}
+
+// Dir is the host directory the snapshot is written to
+func (w *Writer) Dir() string { return w.dir }
+
+// Name is the file prefix of the snapshot
+func (w *Writer) Name() string { return w.name }
+
+// Time is the time the snapshot's name stands for
+func (w *Writer) Time() time.Time { return w.time }
+
+// Path is the final name of the snapshot file with the given suffix
+func (w *Writer) Path(suffix string) string {
+	return filepath.Join(w.dir, w.name+suffix)
+}
+
+// TempPath is hidden from globs and retention, which skip dot files
+func (w *Writer) TempPath(suffix string) string {
+	return filepath.Join(w.dir, "."+w.name+suffix+".tmp")
+}
+
+// WriteFile atomically stores data as the snapshot file with the given suffix
+func (w *Writer) WriteFile(suffix string, data []byte) error {
+	if err := WriteFileAtomic(w.TempPath(suffix), w.Path(suffix), data); err != nil {
+		return err
+	}
+	w.files = append(w.files, FileRecord(w.name+suffix, data))
+	return nil
+}
+
+// WriteGzipped compresses data into the snapshot file with the given suffix
+// and returns the compressed size
+func (w *Writer) WriteGzipped(suffix string, data []byte) (int64, error) {
+	var buf bytes.Buffer
+	gw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := gw.Write(data); err != nil {
+		return 0, err
+	}
+	if err := gw.Close(); err != nil {
+		return 0, err
+	}
+	if err := w.WriteFile(suffix, buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return int64(buf.Len()), nil
+}
+
+// WriteMeta stores the snapshot's .meta.json sidecar
+func (w *Writer) WriteMeta(meta any) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return w.WriteFile(".meta.json", data)
+}
+
+// AddFile lists a file the caller moved into place itself, e.g. a dump
+// streamed to disk, in the snapshot's manifest entry
+func (w *Writer) AddFile(f ManifestFile) {
+	w.files = append(w.files, f)
+}
+
+// Abort releases the reservation of a snapshot that won't be committed
+func (w *Writer) Abort() {
+	os.Remove(w.TempPath(""))
+}
+
+// Commit makes the renames durable, lists the snapshot in the manifest and
+// releases the reservation
+func (w *Writer) Commit() error {
+	return w.commit(true)
+}
+
+// CommitIfListed is Commit for a snapshot that may be older than others in
+// its directory, e.g. one imported from a log: it is listed only if the
+// directory already has a manifest, since starting one would hide the
+// unlisted files newer than the snapshot from gindex
+func (w *Writer) CommitIfListed() error {
+	_, err := os.Stat(filepath.Join(w.dir, ManifestName))
+	return w.commit(err == nil)
+}
+
+func (w *Writer) commit(list bool) error {
+	defer os.Remove(w.TempPath(""))
+	if d, err := os.Open(w.dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	if !list {
+		return nil
+	}
+	return AppendManifest(w.dir, &ManifestEntry{Snapshot: w.name, Time: w.time.UTC(), Files: w.files})
+}
+
+// WriteFileAtomic writes data to tmp, fsyncs it and renames it to path, so
+// readers never see a partial file
+func WriteFileAtomic(tmp, path string, data []byte) error {
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/DataDog/zstd"
+)
+
+// With gscrape -compression zstd, dumps are stored as .goroutines.txt.zst
+// (deltas as .goroutines.delta.zst), compressed against a dictionary trained
+// on the host's recent dumps. Each file starts with a zstd skippable frame
+// holding the name of its dictionary, stored as <host>/dicts/<name>.zdict, so
+// readers know which one to load and `zstd -d -D <dict>` still works.
+const (
+	DictDir        = "dicts"
+	DictExt        = ".zdict"
+	DictFrameMagic = 0x184D2A50 // first of the zstd skippable frame magics
+)
+
+// DictPath is where the dictionary of a host directory with the given name is stored
+func DictPath(hostDir, name string) string {
+	return filepath.Join(hostDir, DictDir, name+DictExt)
+}
+
+// WriteDictHeader writes the skippable frame naming the dictionary of a dump
+func WriteDictHeader(w io.Writer, dictName string) error {
+	header := binary.LittleEndian.AppendUint32(nil, DictFrameMagic)
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(dictName)))
+	_, err := w.Write(append(header, dictName...))
+	return err
+}
+
+// ReadDictName returns the dictionary named in the header of a .zst dump
+func ReadDictName(r io.Reader) (string, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return "", err
+	}
+	size := binary.LittleEndian.Uint32(header[4:])
+	if binary.LittleEndian.Uint32(header[:4]) != DictFrameMagic || size > 256 {
+		return "", fmt.Errorf("no dictionary header")
+	}
+	name := make([]byte, size)
+	if _, err := io.ReadFull(r, name); err != nil {
+		return "", err
+	}
+	return string(name), nil
+}
+
+// decodeZstd decompresses the contents of a .zst dump file, loading the
+// dictionary named in its header with dict. Errors in the data wrap ErrCorrupt.
+func decodeZstd(data []byte, dict func(name string) ([]byte, error)) ([]byte, error) {
+	r := bytes.NewReader(data)
+	name, err := ReadDictName(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+
+	var d []byte
+	if name != "" {
+		if d, err = dict(name); err != nil {
+			return nil, fmt.Errorf("dictionary %s: %w", name, err)
+		}
+	}
+	zr := zstd.NewReaderDict(r, d)
+	defer zr.Close()
+	out, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: zstd: %v", ErrCorrupt, err)
+	}
+	return out, nil
+}
@@ -0,0 +1,199 @@
+// Package traceback parses Go goroutine tracebacks, as in goroutine?debug=2
+// dumps, SIGQUIT output and GOTRACEBACK=all panics, into goroutines and their
+// frames.
+package traceback
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Frame is a call in a goroutine's stack
+type Frame struct {
+	Func string // e.g. "net/http.(*conn).serve"
+	Args string // as printed, e.g. "(0xc000123456, {0x1, 0x2})"; empty for "created by"
+	File string
+	Line int
+}
+
+// Location is the frame's "file:line", or only the file if it has no line
+func (f Frame) Location() string {
+	if f.Line == 0 {
+		return f.File
+	}
+	return f.File + ":" + strconv.Itoa(f.Line)
+}
+
+// Goroutine is one goroutine of a traceback
+type Goroutine struct {
+	ID          int64
+	State       string // e.g. "chan receive", "IO wait"
+	WaitMinutes int    // how long it has been blocked, 0 if under a minute
+	Locked      bool   // locked to its OS thread
+	Frames      []Frame
+	Elided      bool   // frames between Frames and CreatedBy were left out
+	CreatedBy   *Frame // nil for goroutines started by the runtime
+	ParentID    int64  // goroutine that created it, 0 if not printed (before Go 1.21)
+}
+
+var (
+	// goroutine 42 [chan receive, 5 minutes]:
+	// goroutine 1 gp=0xc000002380 m=0 mp=0x1234 [running]: (crash output)
+	headerRe = regexp.MustCompile(`(?m)^goroutine (\d+) (?:gp=\S+ m=\S+ (?:mp=\S+ )?)?\[([^\]]*)\]`)
+	waitRe   = regexp.MustCompile(`^(\d+) minutes?$`)
+	hexRe    = regexp.MustCompile(`0x[0-9a-fA-F]+\??`)
+)
+
+const elidedLine = "...additional frames elided..."
+
+// Parse returns the goroutines of a traceback in the order they appear.
+// Anything before the first goroutine header, like a panic message, is
+// skipped.
+func Parse(data string) []*Goroutine {
+	headers := headerRe.FindAllStringIndex(data, -1)
+	goros := make([]*Goroutine, 0, len(headers))
+	for i, match := range headers {
+		end := len(data)
+		if i+1 < len(headers) {
+			end = headers[i+1][0]
+		}
+		if g := ParseGoroutine(data[match[0]:end]); g != nil {
+			goros = append(goros, g)
+		}
+	}
+	return goros
+}
+
+// ParseGoroutine parses a single goroutine block, starting at its header. It
+// returns nil if the block doesn't start with a goroutine header.
+func ParseGoroutine(block string) *Goroutine {
+	header, rest, _ := strings.Cut(block, "\n")
+	match := headerRe.FindStringSubmatch(header)
+	if match == nil {
+		return nil
+	}
+
+	g := &Goroutine{}
+	g.ID, _ = strconv.ParseInt(match[1], 10, 64)
+	for i, part := range strings.Split(match[2], ", ") {
+		switch {
+		case i == 0:
+			g.State = part
+		case part == "locked to thread":
+			g.Locked = true
+		default:
+			if m := waitRe.FindStringSubmatch(part); m != nil {
+				g.WaitMinutes, _ = strconv.Atoi(m[1])
+			}
+		}
+	}
+
+	// last is the frame a following file line belongs to
+	var last *Frame
+	for _, line := range strings.Split(rest, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case line == elidedLine:
+			g.Elided = true
+		case strings.HasPrefix(line, "created by "):
+			fn := strings.TrimPrefix(line, "created by ")
+			if f, id, ok := strings.Cut(fn, " in goroutine "); ok {
+				fn = f
+				g.ParentID, _ = strconv.ParseInt(id, 10, 64)
+			}
+			g.CreatedBy = &Frame{Func: fn}
+			last = g.CreatedBy
+		case isFileLine(line):
+			if last != nil {
+				last.File, last.Line = parseLocation(line)
+				last = nil
+			}
+		default:
+			fn, args := splitCall(line)
+			g.Frames = append(g.Frames, Frame{Func: fn, Args: args})
+			last = &g.Frames[len(g.Frames)-1]
+		}
+	}
+	return g
+}
+
+// Stack returns the goroutine's stack as text with argument values, PC
+// offsets and the parent goroutine ID left out, so goroutines doing the same
+// thing have the same stack: function and "file:line" lines, leaf first.
+func (g *Goroutine) Stack() string {
+	var b strings.Builder
+	writeFrame := func(fn string, f *Frame) {
+		b.WriteString(fn)
+		b.WriteByte('\n')
+		if f.File != "" {
+			b.WriteString(f.Location())
+			b.WriteByte('\n')
+		}
+	}
+	for i := range g.Frames {
+		f := &g.Frames[i]
+		writeFrame(f.Func+hexRe.ReplaceAllString(f.Args, "..."), f)
+	}
+	if g.Elided {
+		b.WriteString(elidedLine)
+		b.WriteByte('\n')
+	}
+	if g.CreatedBy != nil {
+		writeFrame("created by "+g.CreatedBy.Func, g.CreatedBy)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Funcs returns the function names of the goroutine's frames, leaf first,
+// without the function that created it
+func (g *Goroutine) Funcs() []string {
+	funcs := make([]string, len(g.Frames))
+	for i, f := range g.Frames {
+		funcs[i] = f.Func
+	}
+	return funcs
+}
+
+// isFileLine reports whether a (trimmed) traceback line is the location of
+// the frame above it
+func isFileLine(line string) bool {
+	return strings.HasPrefix(line, "/") || strings.Contains(line, ".go:")
+}
+
+// parseLocation parses "/path/file.go:123 +0x45", ignoring the PC offset and
+// the fp/sp/pc of crash output
+func parseLocation(line string) (string, int) {
+	if i := strings.Index(line, " +0x"); i >= 0 {
+		line = line[:i]
+	}
+	line = strings.TrimSpace(line)
+	if i := strings.LastIndexByte(line, ':'); i >= 0 {
+		if n, err := strconv.Atoi(line[i+1:]); err == nil {
+			return line[:i], n
+		}
+	}
+	return line, 0
+}
+
+// splitCall splits a function line into the function name and its argument
+// list, the parenthesized group at the end. Method receivers like (*T) and
+// argument values like {0x1, 0x2} stay where they belong.
+func splitCall(line string) (string, string) {
+	if !strings.HasSuffix(line, ")") {
+		return line, ""
+	}
+	depth := 0
+	for i := len(line) - 1; i >= 0; i-- {
+		switch line[i] {
+		case ')':
+			depth++
+		case '(':
+			if depth--; depth == 0 {
+				return line[:i], line[i:]
+			}
+		}
+	}
+	return line, ""
+}
@@ -0,0 +1,124 @@
+package traceback
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []*Goroutine
+	}{
+		{
+			name: "debug=2",
+			data: "goroutine 42 [chan receive, 5 minutes]:\n" +
+				"main.(*Worker).run(0xc000123456, {0x1, 0x2})\n" +
+				"\t/src/app/worker.go:31 +0x1f\n" +
+				"created by main.main in goroutine 1\n" +
+				"\t/src/app/main.go:12 +0x3b\n",
+			want: []*Goroutine{{
+				ID: 42, State: "chan receive", WaitMinutes: 5,
+				Frames:    []Frame{{Func: "main.(*Worker).run", Args: "(0xc000123456, {0x1, 0x2})", File: "/src/app/worker.go", Line: 31}},
+				CreatedBy: &Frame{Func: "main.main", File: "/src/app/main.go", Line: 12},
+				ParentID:  1,
+			}},
+		},
+		{
+			name: "crash header",
+			data: "panic: boom\n\n" +
+				"goroutine 1 gp=0xc000002380 m=0 mp=0x5ac1a0 [running]:\n" +
+				"main.main()\n" +
+				"\t/src/app/main.go:10 +0x1d fp=0xc00006ef70 sp=0xc00006ef50 pc=0x46b2bd\n\n" +
+				"goroutine 7 gp=0xc000003000 m=nil [select, 1 minute, locked to thread]:\n" +
+				"main.loop()\n" +
+				"\t/src/app/loop.go:5\n",
+			want: []*Goroutine{
+				{ID: 1, State: "running", Frames: []Frame{{Func: "main.main", Args: "()", File: "/src/app/main.go", Line: 10}}},
+				{ID: 7, State: "select", WaitMinutes: 1, Locked: true, Frames: []Frame{{Func: "main.loop", Args: "()", File: "/src/app/loop.go", Line: 5}}},
+			},
+		},
+		{
+			name: "created by before Go 1.21",
+			data: "goroutine 3 [IO wait]:\n" +
+				"net.(*conn).Read(0xc0000a2000)\n" +
+				"\t/usr/local/go/src/net/net.go:179 +0x45\n" +
+				"created by net/http.(*Server).Serve\n" +
+				"\t/usr/local/go/src/net/http/server.go:3086 +0x5cb\n",
+			want: []*Goroutine{{
+				ID: 3, State: "IO wait",
+				Frames:    []Frame{{Func: "net.(*conn).Read", Args: "(0xc0000a2000)", File: "/usr/local/go/src/net/net.go", Line: 179}},
+				CreatedBy: &Frame{Func: "net/http.(*Server).Serve", File: "/usr/local/go/src/net/http/server.go", Line: 3086},
+			}},
+		},
+		{
+			name: "elided frames",
+			data: "goroutine 9 [running]:\n" +
+				"main.recurse(...)\n" +
+				"\t/src/app/r.go:3\n" +
+				"...additional frames elided...\n" +
+				"created by main.main in goroutine 1\n" +
+				"\t/src/app/main.go:8 +0x25\n",
+			want: []*Goroutine{{
+				ID: 9, State: "running",
+				Frames:    []Frame{{Func: "main.recurse", Args: "(...)", File: "/src/app/r.go", Line: 3}},
+				Elided:    true,
+				CreatedBy: &Frame{Func: "main.main", File: "/src/app/main.go", Line: 8},
+				ParentID:  1,
+			}},
+		},
+		{
+			name: "no goroutines",
+			data: "panic: boom\nexit status 2\n",
+			want: []*Goroutine{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Parse(tt.data)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse:\ngot  %s\nwant %s", dump(got), dump(tt.want))
+			}
+		})
+	}
+}
+
+// dump formats goroutines for test failures
+func dump(goros []*Goroutine) string {
+	s := ""
+	for _, g := range goros {
+		s += fmt.Sprintf("\n%+v created by %+v", *g, g.CreatedBy)
+	}
+	return s
+}
+
+func TestStack(t *testing.T) {
+	g := Parse("goroutine 42 [select]:\n" +
+		"main.(*Worker).run(0xc000123456, {0x1, 0x2})\n" +
+		"\t/src/app/worker.go:31 +0x1f\n" +
+		"...additional frames elided...\n" +
+		"created by main.main in goroutine 1\n" +
+		"\t/src/app/main.go:12 +0x3b\n")[0]
+
+	want := "main.(*Worker).run(..., {..., ...})\n" +
+		"/src/app/worker.go:31\n" +
+		"...additional frames elided...\n" +
+		"created by main.main\n" +
+		"/src/app/main.go:12"
+	if got := g.Stack(); got != want {
+		t.Errorf("Stack() = %q, want %q", got, want)
+	}
+	if got := g.Funcs(); !reflect.DeepEqual(got, []string{"main.(*Worker).run"}) {
+		t.Errorf("Funcs() = %q", got)
+	}
+}
+
+func TestParseGoroutineNotAHeader(t *testing.T) {
+	for _, block := range []string{"", "goroutine profile: total 3", "main.main()\n\t/src/main.go:1"} {
+		if g := ParseGoroutine(block); g != nil {
+			t.Errorf("ParseGoroutine(%q) = %+v, want nil", block, g)
+		}
+	}
+}
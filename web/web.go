@@ -0,0 +1,1742 @@
+// Package web serves gweb's UI and JSON API from an index database.
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gscrape/index"
+)
+
+// Server serves the web UI and its JSON API from an index database, read-only
+// or still being written by an index.Indexer
+type Server struct {
+	r *index.Reader
+}
+
+// NewServer returns a Server reading r
+func NewServer(r *index.Reader) *Server {
+	return &Server{r: r}
+}
+
+// Handler returns the routes of the UI and the API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/api/hosts", s.handleHosts)
+	mux.HandleFunc("/api/goroutine", s.handleGoroutine)
+	mux.HandleFunc("/api/search", s.handleSearch)
+	mux.HandleFunc("/api/stats", s.handleStats)
+	mux.HandleFunc("/api/children", s.handleChildren)
+	mux.HandleFunc("/api/profile", s.handleProfile)
+	mux.HandleFunc("/api/trace", s.handleTrace)
+	mux.HandleFunc("/api/labels", s.handleLabels)
+	mux.HandleFunc("/api/journal", s.handleJournal)
+	mux.HandleFunc("/api/runtime", s.handleRuntime)
+	return mux
+}
+
+// ========== API Handlers ==========
+
+// HostInfo is a host with the alias, URL and labels of its target
+type HostInfo struct {
+	Host   string            `json:"host"`
+	Alias  string            `json:"alias,omitempty"`
+	URL    string            `json:"url,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+func (s *Server) handleHosts(w http.ResponseWriter, r *http.Request) {
+	hosts, _ := s.r.Hosts()
+
+	infos := make([]HostInfo, 0, len(hosts))
+	for _, host := range hosts {
+		info := HostInfo{Host: host}
+		if h, err := s.r.HostInfo(host); err == nil {
+			info.Alias, info.URL, info.Labels = h.Alias, h.URL, h.Labels
+		}
+		infos = append(infos, info)
+	}
+	writeJSON(w, infos)
+}
+
+func (s *Server) handleGoroutine(w http.ResponseWriter, r *http.Request) {
+	host := r.URL.Query().Get("host")
+	goroID := r.URL.Query().Get("id")
+
+	if host == "" || goroID == "" {
+		http.Error(w, "host and id parameters required", http.StatusBadRequest)
+		return
+	}
+
+	series, err := s.r.Goroutine(host, parseInt64(goroID))
+	if err == index.ErrNotFound {
+		http.Error(w, "Goroutine not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to decode data", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, series)
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	hosts, _ := s.r.Hosts()
+
+	type HostStats struct {
+		Host       string             `json:"host"`
+		Timestamps []int64            `json:"timestamps"`
+		Counts     []int              `json:"counts"`
+		Profiles   map[int64][]string `json:"profiles,omitempty"`
+		Triggers   map[int64]string   `json:"triggers,omitempty"`
+		Grouped    []int64            `json:"grouped,omitempty"`
+		Redactions map[int64]string   `json:"redactions,omitempty"`
+		Traces     map[int64]string   `json:"traces,omitempty"`
+	}
+
+	var allStats []HostStats
+
+	for _, host := range hosts {
+		// Read pre-computed stats
+		stats, err := s.r.Stats(host)
+		if err != nil {
+			continue
+		}
+
+		allStats = append(allStats, HostStats{
+			Host:       host,
+			Timestamps: stats.Timestamps,
+			Counts:     stats.Counts,
+			Profiles:   stats.Profiles,
+			Triggers:   stats.Triggers,
+			Grouped:    stats.Grouped,
+			Redactions: stats.Redactions,
+			Traces:     stats.Traces,
+		})
+	}
+
+	writeJSON(w, allStats)
+}
+
+func (s *Server) handleLabels(w http.ResponseWriter, r *http.Request) {
+	hostFilter := r.URL.Query().Get("host")
+	hosts, _ := s.r.Hosts()
+
+	type HostLabels struct {
+		Host       string                      `json:"host"`
+		Timestamps []int64                     `json:"timestamps"`
+		Values     map[string]map[string][]int `json:"values"` // key -> value -> counts
+	}
+
+	allLabels := []HostLabels{}
+
+	for _, host := range hosts {
+		if hostFilter != "" && host != hostFilter {
+			continue
+		}
+
+		labelStats, err := s.r.Labels(host)
+		if err != nil {
+			continue
+		}
+
+		allLabels = append(allLabels, HostLabels{
+			Host:       host,
+			Timestamps: labelStats.Timestamps,
+			Values:     labelStats.Values,
+		})
+	}
+
+	writeJSON(w, allLabels)
+}
+
+func (s *Server) handleRuntime(w http.ResponseWriter, r *http.Request) {
+	hostFilter := r.URL.Query().Get("host")
+	hosts, _ := s.r.Hosts()
+
+	type HostRuntime struct {
+		Host       string                `json:"host"`
+		Timestamps []int64               `json:"timestamps"`
+		Values     map[string][]*float64 `json:"values"` // series -> values, null where missing
+	}
+
+	allRuntime := []HostRuntime{}
+
+	for _, host := range hosts {
+		if hostFilter != "" && host != hostFilter {
+			continue
+		}
+
+		runtimeStats, err := s.r.Runtime(host)
+		if err != nil {
+			continue
+		}
+
+		allRuntime = append(allRuntime, HostRuntime{
+			Host:       host,
+			Timestamps: runtimeStats.Timestamps,
+			Values:     runtimeStats.Values,
+		})
+	}
+
+	writeJSON(w, allRuntime)
+}
+
+func (s *Server) handleJournal(w http.ResponseWriter, r *http.Request) {
+	hostFilter := r.URL.Query().Get("host")
+	hosts, _ := s.r.Hosts()
+
+	type HostJournal struct {
+		Host     string                 `json:"host"`
+		Attempts int                    `json:"attempts"`
+		Failures []index.JournalFailure `json:"failures"`
+	}
+
+	allJournals := []HostJournal{}
+
+	for _, host := range hosts {
+		if hostFilter != "" && host != hostFilter {
+			continue
+		}
+
+		journal, err := s.r.Journal(host)
+		if err != nil {
+			continue
+		}
+
+		allJournals = append(allJournals, HostJournal{
+			Host:     host,
+			Attempts: journal.Attempts,
+			Failures: journal.Failures,
+		})
+	}
+
+	writeJSON(w, allJournals)
+}
+
+func (s *Server) handleProfile(w http.ResponseWriter, r *http.Request) {
+	host := r.URL.Query().Get("host")
+	ts := r.URL.Query().Get("t")
+	name := r.URL.Query().Get("name")
+
+	if host == "" || ts == "" || name == "" {
+		http.Error(w, "host, t and name parameters required", http.StatusBadRequest)
+		return
+	}
+
+	data, err := s.r.Profile(host, parseInt64(ts), name)
+	if err != nil {
+		http.Error(w, "Profile not found", http.StatusNotFound)
+		return
+	}
+
+	// Stored as scraped (gzipped protobuf), ready for `go tool pprof`
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("%s-%s.%s.pb.gz", host, ts, name)))
+	w.Write(data)
+}
+
+func (s *Server) handleTrace(w http.ResponseWriter, r *http.Request) {
+	host := r.URL.Query().Get("host")
+	ts := r.URL.Query().Get("t")
+
+	if host == "" || ts == "" {
+		http.Error(w, "host and t parameters required", http.StatusBadRequest)
+		return
+	}
+
+	trace, err := s.r.Trace(host, parseInt64(ts))
+	if err == index.ErrNotFound {
+		http.Error(w, "Trace not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Corrupt trace", http.StatusInternalServerError)
+		return
+	}
+	defer trace.Close()
+
+	// Served as the raw trace for `go tool trace`
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("%s-%s.trace", host, ts)))
+	io.Copy(w, trace)
+}
+
+func (s *Server) handleChildren(w http.ResponseWriter, r *http.Request) {
+	host := r.URL.Query().Get("host")
+	parentID := r.URL.Query().Get("id")
+
+	if host == "" || parentID == "" {
+		http.Error(w, "host and id parameters required", http.StatusBadRequest)
+		return
+	}
+
+	// Read pre-computed children index
+	storedChildren, err := s.r.Children(host, parseInt64(parentID))
+	if err == index.ErrNotFound {
+		// No children
+		writeJSON(w, []struct{}{})
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to decode data", http.StatusInternalServerError)
+		return
+	}
+
+	type ChildInfo struct {
+		ID        int64  `json:"id"`
+		Funcs     string `json:"funcs"`
+		FirstSeen int64  `json:"first"`
+		LastSeen  int64  `json:"last"`
+	}
+
+	// Convert to API format
+	children := make([]ChildInfo, len(storedChildren))
+	for i, c := range storedChildren {
+		children[i] = ChildInfo{
+			ID:        c.ID,
+			Funcs:     c.Funcs,
+			FirstSeen: c.FirstSeen,
+			LastSeen:  c.LastSeen,
+		}
+	}
+
+	writeJSON(w, children)
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	host := r.URL.Query().Get("host")
+	goroID := r.URL.Query().Get("id")
+	label := r.URL.Query().Get("label") // optional "key=value" filter
+
+	if host == "" {
+		http.Error(w, "host parameter required", http.StatusBadRequest)
+		return
+	}
+
+	labelKey, labelValue, filterLabel := strings.Cut(label, "=")
+
+	type match struct {
+		ID    string `json:"id"`
+		Count int    `json:"count"`
+		First int64  `json:"first"`
+		Last  int64  `json:"last"`
+	}
+	var matches []match
+
+	// Search for goroutines matching the ID prefix
+	s.r.Goroutines(host, func(id int64, series *index.GoroutineTimeSeries) bool {
+		idStr := strconv.FormatInt(id, 10)
+
+		// If searching for specific ID, filter
+		if goroID != "" && !strings.Contains(idStr, goroID) {
+			return true
+		}
+
+		if filterLabel && !hasLabel(series, labelKey, labelValue) {
+			return true
+		}
+
+		if len(series.Entries) > 0 {
+			matches = append(matches, match{
+				ID:    idStr,
+				Count: len(series.Entries),
+				First: series.Entries[0].Timestamp,
+				Last:  series.Entries[len(series.Entries)-1].Timestamp,
+			})
+		}
+
+		// Limit results
+		return len(matches) < 100
+	})
+
+	writeJSON(w, matches)
+}
+
+// hasLabel reports whether the goroutine carried the label at any point
+func hasLabel(series *index.GoroutineTimeSeries, key, value string) bool {
+	for _, e := range series.Entries {
+		if v, ok := e.Labels[key]; ok && v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ========== HTML UI ==========
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Goroutine Timelapse</title>
+    <script src="https://cdn.jsdelivr.net/npm/chart.js"></script>
+    <script src="https://cdn.jsdelivr.net/npm/chartjs-adapter-date-fns"></script>
+    <script src="https://cdn.jsdelivr.net/npm/chartjs-plugin-annotation"></script>
+    <style>
+        * { box-sizing: border-box; }
+        html, body { 
+            height: 100%;
+            margin: 0;
+        }
+        body { 
+            font-family: 'Monaco', 'Menlo', 'Ubuntu Mono', monospace; 
+            padding: 20px; 
+            background: #1e1e1e; 
+            color: #d4d4d4;
+            display: flex;
+            flex-direction: column;
+        }
+        .header { 
+            display: flex; 
+            gap: 10px; 
+            align-items: center; 
+            margin-bottom: 20px;
+            flex-wrap: wrap;
+        }
+        select, input, button { 
+            padding: 8px 12px; 
+            font-size: 14px; 
+            background: #3c3c3c;
+            color: #d4d4d4;
+            border: 1px solid #555;
+            border-radius: 4px;
+        }
+        button { 
+            cursor: pointer; 
+            background: #0e639c;
+            border-color: #0e639c;
+        }
+        button:hover { background: #1177bb; }
+        button:disabled { opacity: 0.5; cursor: not-allowed; }
+        .controls {
+            display: flex;
+            align-items: center;
+            gap: 10px;
+            margin-bottom: 10px;
+            background: #252526;
+            padding: 10px;
+            border-radius: 4px;
+        }
+        .slider-container { flex: 1; }
+        input[type="range"] { 
+            width: 100%; 
+            cursor: pointer;
+        }
+        .time-info {
+            display: flex;
+            justify-content: space-between;
+            font-size: 12px;
+            color: #888;
+            margin-top: 5px;
+        }
+        .current-time {
+            font-size: 16px;
+            font-weight: bold;
+            color: #4ec9b0;
+            min-width: 200px;
+        }
+        .state {
+            padding: 4px 8px;
+            border-radius: 4px;
+            font-size: 12px;
+            background: #264f78;
+        }
+        .stack-container {
+            background: #252526;
+            border-radius: 4px;
+            overflow: hidden;
+        }
+        .parent-link {
+            margin-left: 10px;
+            color: #569cd6;
+            cursor: pointer;
+            text-decoration: underline;
+        }
+        .parent-link:hover {
+            color: #9cdcfe;
+        }
+        .labels {
+            margin-left: 8px;
+            font-size: 12px;
+        }
+        .label-tag {
+            padding: 2px 6px;
+            margin-right: 4px;
+            border-radius: 4px;
+            background: #3a3d41;
+            color: #9cdcfe;
+        }
+        .trigger-tag {
+            margin-left: 8px;
+            padding: 2px 6px;
+            border-radius: 4px;
+            background: #5a1d1d;
+            color: #f48771;
+            font-size: 12px;
+        }
+        .profile-link {
+            margin-left: 8px;
+            font-size: 12px;
+            color: #ce9178;
+        }
+        .profile-link:hover {
+            color: #f4a582;
+        }
+        .stack-header {
+            padding: 10px;
+            background: #333;
+            border-bottom: 1px solid #444;
+            display: flex;
+            justify-content: space-between;
+            align-items: center;
+        }
+        .stack {
+            padding: 15px;
+            overflow-x: auto;
+            white-space: pre;
+            font-size: 13px;
+            line-height: 1.5;
+            max-height: 70vh;
+            overflow-y: auto;
+        }
+        .stack-line { display: block; }
+        .stack-line:hover { background: #333; }
+        .stack-line.func { color: #dcdcaa; }
+        .stack-line.file { color: #808080; }
+        .stack-line.changed { background: #3d2b2b; }
+        .stack-line.new { background: #2b3d2b; }
+        .search-results {
+            max-height: 300px;
+            overflow-y: auto;
+            background: #252526;
+            border-radius: 4px;
+            margin-bottom: 20px;
+        }
+        .search-result {
+            padding: 8px 12px;
+            cursor: pointer;
+            border-bottom: 1px solid #333;
+            display: flex;
+            justify-content: space-between;
+        }
+        .search-result:hover { background: #333; }
+        .search-result.selected { background: #0e639c; }
+        .frame-counter {
+            font-size: 14px;
+            color: #888;
+        }
+        .playback-speed {
+            display: flex;
+            align-items: center;
+            gap: 5px;
+            font-size: 12px;
+        }
+        #loading {
+            position: fixed;
+            top: 50%;
+            left: 50%;
+            transform: translate(-50%, -50%);
+            background: #333;
+            padding: 20px;
+            border-radius: 8px;
+            display: none;
+        }
+        .chart-container {
+            background: #252526;
+            border-radius: 4px;
+            padding: 15px;
+            margin-bottom: 20px;
+        }
+        .chart-container h3 {
+            margin: 0 0 15px 0;
+            color: #4ec9b0;
+            font-size: 16px;
+        }
+        .chart-wrapper {
+            position: relative;
+            height: 300px;
+        }
+        .tab-bar {
+            display: flex;
+            gap: 5px;
+            margin-bottom: 20px;
+            flex-shrink: 0;
+        }
+        .tab {
+            padding: 8px 16px;
+            background: #3c3c3c;
+            border: none;
+            color: #d4d4d4;
+            cursor: pointer;
+            border-radius: 4px 4px 0 0;
+        }
+        .tab.active {
+            background: #0e639c;
+        }
+        .tab:hover:not(.active) {
+            background: #4c4c4c;
+        }
+        .children-container {
+            background: #252526;
+            border-radius: 4px;
+            margin-top: 15px;
+            overflow: hidden;
+            display: flex;
+            flex-direction: column;
+            flex: 1;
+            min-height: 300px;
+        }
+        .children-header {
+            padding: 10px;
+            background: #333;
+            border-bottom: 1px solid #444;
+            display: flex;
+            justify-content: space-between;
+            align-items: center;
+            cursor: pointer;
+        }
+        .children-header:hover {
+            background: #3a3a3a;
+        }
+        .children-header h4 {
+            margin: 0;
+            color: #4ec9b0;
+            font-size: 14px;
+        }
+        .children-toggle {
+            color: #888;
+            font-size: 12px;
+        }
+        .children-toolbar {
+            padding: 8px 10px;
+            background: #2d2d2d;
+            border-bottom: 1px solid #444;
+            display: none;
+        }
+        .children-search {
+            width: 100%;
+            padding: 6px 10px;
+            font-size: 12px;
+            background: #3c3c3c;
+            color: #d4d4d4;
+            border: 1px solid #555;
+            border-radius: 4px;
+        }
+        .children-search:focus {
+            outline: none;
+            border-color: #0e639c;
+        }
+        .children-search::placeholder {
+            color: #888;
+        }
+        .children-list {
+            flex: 1;
+            min-height: 250px;
+            overflow-y: auto;
+        }
+        .child-item {
+            padding: 8px 12px;
+            border-bottom: 1px solid #333;
+            display: grid;
+            grid-template-columns: 80px 1fr 180px 100px;
+            gap: 10px;
+            align-items: center;
+            font-size: 12px;
+        }
+        .child-item:hover {
+            background: #333;
+        }
+        .child-id {
+            color: #569cd6;
+            cursor: pointer;
+            text-decoration: underline;
+        }
+        .child-id:hover {
+            color: #9cdcfe;
+        }
+        .child-funcs {
+            color: #dcdcaa;
+            overflow: hidden;
+            text-overflow: ellipsis;
+            white-space: nowrap;
+        }
+        .child-time {
+            color: #888;
+        }
+        .child-duration {
+            color: #4ec9b0;
+            text-align: right;
+        }
+        .viewer-chart-container {
+            background: #252526;
+            border-radius: 4px;
+            padding: 10px;
+            margin-bottom: 10px;
+        }
+        .viewer-chart-wrapper {
+            position: relative;
+            height: 120px;
+        }
+        #chartTab {
+            flex-shrink: 0;
+        }
+        #viewerTab {
+            display: flex;
+            flex-direction: column;
+            flex: 1;
+            min-height: 0;
+        }
+        #viewer {
+            display: flex;
+            flex-direction: column;
+            flex: 1;
+            min-height: 0;
+        }
+    </style>
+</head>
+<body>
+    <div id="loading">Loading...</div>
+    
+    <div class="tab-bar">
+        <button class="tab active" onclick="showTab('chart')">Overview</button>
+        <button class="tab" onclick="showTab('viewer')">Goroutine Viewer</button>
+    </div>
+
+    <div id="chartTab">
+        <div class="chart-container">
+            <h3>Active Goroutines Over Time</h3>
+            <div class="header">
+                <label>Group by:</label>
+                <select id="groupBySelect" onchange="loadChart()">
+                    <option value="">Host</option>
+                </select>
+                <label>Colour by:</label>
+                <select id="colorBySelect" onchange="renderHostSelect(); loadChart()">
+                    <option value="">Host</option>
+                </select>
+                <input type="text" id="hostFilter" placeholder="Filter hosts: env=prod,service=api" style="width: 250px" onchange="renderHostSelect(); loadChart()">
+                <label>Overlay:</label>
+                <select id="overlaySelect" onchange="loadChart(); renderViewerChart()">
+                    <option value="">None</option>
+                </select>
+                <button onclick="refresh()" title="Reload the data, e.g. while gscrape serve indexes new snapshots">↻ Refresh</button>
+            </div>
+            <div class="chart-wrapper">
+                <canvas id="goroChart"></canvas>
+            </div>
+        </div>
+        <div class="chart-container" id="tracesContainer" style="display:none">
+            <h3>Execution Traces</h3>
+            <div class="search-results" id="tracesList"></div>
+        </div>
+    </div>
+
+    <div id="viewerTab" style="display:none">
+        <div class="header">
+            <select id="hostSelect">
+                <option value="">Select Host...</option>
+            </select>
+            <input type="text" id="goroSearch" placeholder="Goroutine ID..." style="width: 150px">
+            <input type="text" id="labelFilter" placeholder="label=value" style="width: 150px">
+            <button onclick="searchGoroutines()">Search</button>
+            <button onclick="loadGoroutine()">Load</button>
+        </div>
+
+        <div id="searchResults" class="search-results" style="display:none"></div>
+
+    <div id="viewer" style="display:none">
+        <div class="viewer-chart-container">
+            <div class="viewer-chart-wrapper">
+                <canvas id="viewerChart"></canvas>
+            </div>
+        </div>
+
+        <div class="controls">
+            <button id="playBtn" onclick="togglePlay()">▶ Play</button>
+            <div class="slider-container">
+                <input type="range" id="timeSlider" min="0" max="100" value="0" oninput="onSliderChange()">
+                <div class="time-info">
+                    <span id="startTime">--</span>
+                    <span id="endTime">--</span>
+                </div>
+            </div>
+            <div class="playback-speed">
+                <label>Speed:</label>
+                <select id="speedSelect">
+                    <option value="2000">0.5x</option>
+                    <option value="1000" selected>1x</option>
+                    <option value="500">2x</option>
+                    <option value="200">5x</option>
+                    <option value="100">10x</option>
+                </select>
+            </div>
+        </div>
+
+        <div class="stack-container">
+            <div class="stack-header">
+                <div>
+                    <span class="current-time" id="currentTime">--</span>
+                    <span class="state" id="currentState">--</span>
+                    <span id="parentLink"></span>
+                    <span class="labels" id="currentLabels"></span>
+                    <span id="profileLinks"></span>
+                    <span id="triggerTag"></span>
+                </div>
+                <span class="frame-counter" id="frameCounter">-- / --</span>
+            </div>
+            <div class="stack" id="stackView"></div>
+        </div>
+
+        <div class="children-container" id="childrenContainer" style="display:none">
+            <div class="children-header" onclick="toggleChildren()">
+                <h4>Children Goroutines (<span id="childrenCount">0</span>)</h4>
+                <span class="children-toggle" id="childrenToggle">▼ Show</span>
+            </div>
+            <div class="children-toolbar" id="childrenToolbar">
+                <input type="text" class="children-search" id="childrenSearch" placeholder="Filter by function name..." oninput="filterChildren()">
+            </div>
+            <div class="children-list" id="childrenList" style="display:none"></div>
+        </div>
+    </div>
+    </div>
+
+    <script>
+        let hosts = [];
+        let currentData = null;
+        let currentFrame = 0;
+        let playing = false;
+        let playInterval = null;
+        let previousStack = '';
+        let goroChart = null;
+        let viewerChart = null;
+        let statsData = null;
+        let childrenData = null;
+        let childrenVisible = false;
+        let labelsData = null;
+        let journalData = null;
+        let runtimeData = null;
+
+        // Tab switching
+        function showTab(tab) {
+            document.querySelectorAll('.tab').forEach(t => t.classList.remove('active'));
+            document.querySelector('.tab[onclick="showTab(\'' + tab + '\')"]').classList.add('active');
+            
+            document.getElementById('chartTab').style.display = tab === 'chart' ? 'block' : 'none';
+            document.getElementById('viewerTab').style.display = tab === 'viewer' ? 'flex' : 'none';
+        }
+
+        // Chart colors for different hosts
+        const chartColors = [
+            'rgb(78, 201, 176)',   // teal
+            'rgb(86, 156, 214)',   // blue
+            'rgb(220, 220, 170)',  // yellow
+            'rgb(206, 145, 120)',  // orange
+            'rgb(197, 134, 192)',  // purple
+            'rgb(244, 135, 113)',  // red
+            'rgb(129, 212, 250)',  // light blue
+            'rgb(165, 214, 167)',  // light green
+        ];
+
+        // Alias of a host, falling back to its directory name
+        function hostName(host) {
+            const info = hosts.find(h => h.host === host);
+            return info && info.alias ? info.alias : host;
+        }
+
+        // Whether a host passes the filter: comma-separated key=value label
+        // matches, or plain text matched against the host name and alias
+        function hostMatches(host) {
+            const filter = document.getElementById('hostFilter').value.trim();
+            if (!filter) return true;
+            const info = hosts.find(h => h.host === host) || { host: host };
+            const labels = info.labels || {};
+            return filter.split(',').map(f => f.trim()).filter(f => f).every(f => {
+                const eq = f.indexOf('=');
+                if (eq < 0) return host.includes(f) || (info.alias || '').includes(f);
+                return labels[f.slice(0, eq).trim()] === f.slice(eq + 1).trim();
+            });
+        }
+
+        // Colour of a host: by the value of the colour-by label, so hosts
+        // sharing it share a colour, or else by position
+        function hostColor(host, i) {
+            const key = document.getElementById('colorBySelect').value;
+            if (!key) return chartColors[i % chartColors.length];
+            const values = [...new Set(hosts.map(h => (h.labels || {})[key]).filter(v => v !== undefined))].sort();
+            const info = hosts.find(h => h.host === host);
+            const value = info && info.labels ? info.labels[key] : undefined;
+            return value === undefined ? 'rgb(128, 128, 128)' : chartColors[values.indexOf(value) % chartColors.length];
+        }
+
+        // Fill the host dropdown with the hosts passing the filter, grouped
+        // by the colour-by label
+        function renderHostSelect() {
+            const select = document.getElementById('hostSelect');
+            const selected = select.value;
+            select.innerHTML = '<option value="">Select Host...</option>';
+
+            const key = document.getElementById('colorBySelect').value;
+            const groups = new Map();
+            hosts.filter(h => hostMatches(h.host)).forEach(h => {
+                const group = key ? (h.labels || {})[key] || '(no ' + key + ')' : '';
+                if (!groups.has(group)) groups.set(group, []);
+                groups.get(group).push(h);
+            });
+            [...groups.keys()].sort().forEach(group => {
+                const parent = group ? document.createElement('optgroup') : select;
+                if (group) {
+                    parent.label = key + '=' + group;
+                    select.appendChild(parent);
+                }
+                groups.get(group).forEach(h => {
+                    const opt = document.createElement('option');
+                    opt.value = h.host;
+                    opt.textContent = h.alias ? h.alias + ' (' + h.host + ')' : h.host;
+                    const labels = Object.keys(h.labels || {}).sort().map(k => k + '=' + h.labels[k]);
+                    if (labels.length) opt.title = labels.join(', ');
+                    parent.appendChild(opt);
+                });
+            });
+            select.value = selected;
+        }
+
+        // Fetch stats data (used by both charts)
+        async function fetchStats() {
+            if (statsData) return statsData;
+            const resp = await fetch('/api/stats');
+            statsData = await resp.json();
+            return statsData;
+        }
+
+        // Fetch pprof label series (used by the group-by chart)
+        async function fetchJournal() {
+            if (journalData) return journalData;
+            const resp = await fetch('/api/journal');
+            journalData = await resp.json();
+            return journalData;
+        }
+
+        // Failed scrape attempts of a host: a null point breaking the line,
+        // plus a marker per failure in a separate dataset
+        function journalDataset(hostData, journal) {
+            const failures = journal ? journal.failures || [] : [];
+            if (failures.length === 0) return null;
+            const counts = hostData.timestamps;
+            const points = failures.map(f => {
+                // Place the marker at the count of the last snapshot before it
+                let j = counts.length - 1;
+                while (j > 0 && counts[j] > f.t) j--;
+                return { x: new Date(f.t), y: hostData.counts[j] || 0, error: f.e, status: f.s };
+            });
+            return {
+                label: hostName(hostData.host) + ' errors',
+                data: points,
+                type: 'scatter',
+                borderColor: '#f44747',
+                backgroundColor: '#f44747',
+                pointStyle: 'crossRot',
+                pointRadius: 5,
+                borderWidth: 2,
+                showLine: false
+            };
+        }
+
+        async function fetchRuntime() {
+            if (runtimeData) return runtimeData;
+            const resp = await fetch('/api/runtime');
+            runtimeData = await resp.json();
+            return runtimeData;
+        }
+
+        // Cumulative series are offered as a per-second rate too
+        function isCounter(series) {
+            return /(_total|_count|_sum)$/.test(series) || /^memstats\.(NumGC|PauseTotalNs|TotalAlloc|Mallocs|Frees)$/.test(series);
+        }
+
+        // Points of the overlay series ("<series>" or "rate:<series>") of a
+        // host, or null if the host doesn't have it
+        function runtimePoints(rt, overlay) {
+            const rate = overlay.startsWith('rate:');
+            const series = rate ? overlay.slice(5) : overlay;
+            const values = rt ? rt.values[series] : null;
+            if (!values) return null;
+            return rt.timestamps.map((ts, j) => {
+                let y = values[j];
+                if (rate) {
+                    const prev = j > 0 ? values[j - 1] : null;
+                    const dt = j > 0 ? (ts - rt.timestamps[j - 1]) / 1000 : 0;
+                    // A counter going down means the target restarted
+                    y = y === null || prev === null || dt <= 0 || y < prev ? null : (y - prev) / dt;
+                }
+                return { x: new Date(ts), y: y };
+            });
+        }
+
+        // Dashed line of the overlay series of a host on the right-hand axis
+        function runtimeDataset(host, rt, overlay, color) {
+            const points = runtimePoints(rt, overlay);
+            if (!points) return null;
+            return {
+                label: hostName(host) + ' ' + overlay,
+                data: points,
+                yAxisID: 'y1',
+                borderColor: color,
+                borderDash: [4, 4],
+                borderWidth: 1,
+                pointRadius: 0,
+                tension: 0.1,
+                fill: false
+            };
+        }
+
+        async function fetchLabels() {
+            if (labelsData) return labelsData;
+            const resp = await fetch('/api/labels');
+            labelsData = await resp.json();
+            return labelsData;
+        }
+
+        // One dataset per label value (per host when there are several)
+        function labelDatasets(labels, key) {
+            const datasets = [];
+            labels.forEach(hostData => {
+                const values = hostData.values[key] || {};
+                Object.keys(values).sort().forEach(value => {
+                    const color = chartColors[datasets.length % chartColors.length];
+                    datasets.push({
+                        label: labels.length > 1 ? key + '=' + value + ' (' + hostName(hostData.host) + ')' : key + '=' + value,
+                        data: hostData.timestamps.map((ts, j) => ({
+                            x: new Date(ts),
+                            y: values[value][j]
+                        })),
+                        borderColor: color,
+                        backgroundColor: color.replace('rgb', 'rgba').replace(')', ', 0.1)'),
+                        borderWidth: 1.5,
+                        pointRadius: 0,
+                        tension: 0.1,
+                        fill: false
+                    });
+                });
+            });
+            return datasets;
+        }
+
+        // Load and render the chart
+        async function loadChart() {
+            showLoading(true);
+            const stats = await fetchStats();
+            const labels = await fetchLabels();
+            const journals = await fetchJournal();
+            const runtime = await fetchRuntime();
+            showLoading(false);
+
+            // Offer every label key seen on any host for grouping
+            const groupBy = document.getElementById('groupBySelect');
+            const keys = new Set();
+            labels.forEach(h => Object.keys(h.values).forEach(k => keys.add(k)));
+            keys.forEach(k => {
+                if (!groupBy.querySelector('option[value="' + k + '"]')) {
+                    const opt = document.createElement('option');
+                    opt.value = k;
+                    opt.textContent = 'label: ' + k;
+                    groupBy.appendChild(opt);
+                }
+            });
+
+            // Offer every target label key for colouring
+            const colorBy = document.getElementById('colorBySelect');
+            const hostKeys = new Set();
+            hosts.forEach(h => Object.keys(h.labels || {}).forEach(k => hostKeys.add(k)));
+            [...hostKeys].sort().forEach(k => {
+                if (!colorBy.querySelector('option[value="' + k + '"]')) {
+                    const opt = document.createElement('option');
+                    opt.value = k;
+                    opt.textContent = k;
+                    colorBy.appendChild(opt);
+                }
+            });
+
+            // Offer every runtime metric series seen on any host as an overlay
+            const overlaySelect = document.getElementById('overlaySelect');
+            const seriesNames = new Set();
+            runtime.forEach(h => Object.keys(h.values).forEach(k => seriesNames.add(k)));
+            [...seriesNames].sort().flatMap(k => isCounter(k) ? [k, 'rate:' + k] : [k]).forEach(k => {
+                if (!overlaySelect.querySelector('option[value="' + k + '"]')) {
+                    const opt = document.createElement('option');
+                    opt.value = k;
+                    opt.textContent = k.startsWith('rate:') ? k.slice(5) + ' /s' : k;
+                    overlaySelect.appendChild(opt);
+                }
+            });
+
+            const shown = stats.filter(h => hostMatches(h.host));
+            const datasets = groupBy.value ? labelDatasets(labels.filter(h => hostMatches(h.host)), groupBy.value) : shown.flatMap((hostData, i) => {
+                const data = hostData.timestamps.map((ts, j) => ({
+                    x: new Date(ts),
+                    y: hostData.counts[j]
+                }));
+
+                // Break the line where scrapes failed
+                const journal = journals.find(j => j.host === hostData.host);
+                if (journal && journal.failures) {
+                    journal.failures.forEach(f => data.push({ x: new Date(f.t), y: null }));
+                    data.sort((a, b) => a.x - b.x);
+                }
+
+                // Highlight snapshots taken in a trigger burst
+                const triggers = hostData.triggers || {};
+                const color = hostColor(hostData.host, i);
+
+                const isTriggered = (p) => p.raw && p.raw.y !== null && triggers[p.raw.x.getTime()];
+
+                const line = {
+                    label: hostName(hostData.host),
+                    data: data,
+                    borderColor: color,
+                    backgroundColor: color.replace('rgb', 'rgba').replace(')', ', 0.1)'),
+                    borderWidth: 1.5,
+                    pointRadius: (p) => isTriggered(p) ? 3 : 0,
+                    pointBackgroundColor: (p) => isTriggered(p) ? '#f48771' : color,
+                    triggers: triggers,
+                    grouped: new Set(hostData.grouped || []),
+                    redactions: hostData.redactions || {},
+                    traces: hostData.traces || {},
+                    tension: 0.1,
+                    fill: false
+                };
+                const errors = journalDataset(hostData, journal);
+                return errors ? [line, errors] : [line];
+            });
+
+            // Runtime metrics of the shown hosts on a second axis
+            const overlay = overlaySelect.value;
+            if (overlay) {
+                shown.forEach((hostData, i) => {
+                    const rt = runtime.find(r => r.host === hostData.host);
+                    const dataset = runtimeDataset(hostData.host, rt, overlay, hostColor(hostData.host, i));
+                    if (dataset) datasets.push(dataset);
+                });
+            }
+
+            renderTraces(shown);
+
+            const ctx = document.getElementById('goroChart').getContext('2d');
+            
+            if (goroChart) {
+                goroChart.destroy();
+            }
+
+            goroChart = new Chart(ctx, {
+                type: 'line',
+                data: { datasets },
+                options: {
+                    responsive: true,
+                    maintainAspectRatio: false,
+                    interaction: {
+                        mode: 'index',
+                        intersect: false
+                    },
+                    plugins: {
+                        legend: {
+                            position: 'top',
+                            labels: {
+                                color: '#d4d4d4',
+                                usePointStyle: true,
+                                pointStyle: 'line'
+                            }
+                        },
+                        tooltip: {
+                            backgroundColor: '#333',
+                            titleColor: '#d4d4d4',
+                            bodyColor: '#d4d4d4',
+                            borderColor: '#555',
+                            borderWidth: 1,
+                            callbacks: {
+                                afterLabel: (item) => {
+                                    if (item.raw.error) return (item.raw.status ? 'HTTP ' + item.raw.status + ': ' : '') + item.raw.error;
+                                    const ts = item.raw.x.getTime();
+                                    const notes = [];
+                                    const trigger = item.dataset.triggers && item.dataset.triggers[ts];
+                                    if (trigger) notes.push('⚡ ' + trigger);
+                                    const trace = item.dataset.traces && item.dataset.traces[ts];
+                                    if (trace) notes.push('⏱ trace: ' + trace);
+                                    if (item.dataset.grouped && item.dataset.grouped.has(ts)) notes.push('debug=1 (counts only)');
+                                    const redaction = item.dataset.redactions && item.dataset.redactions[ts];
+                                    if (redaction) notes.push('redacted: ' + redaction);
+                                    return notes;
+                                }
+                            }
+                        }
+                    },
+                    scales: {
+                        x: {
+                            type: 'time',
+                            time: {
+                                displayFormats: {
+                                    minute: 'HH:mm',
+                                    hour: 'HH:mm'
+                                }
+                            },
+                            grid: {
+                                color: '#333'
+                            },
+                            ticks: {
+                                color: '#888'
+                            }
+                        },
+                        y: {
+                            beginAtZero: false,
+                            grid: {
+                                color: '#333'
+                            },
+                            ticks: {
+                                color: '#888'
+                            },
+                            title: {
+                                display: true,
+                                text: 'Active Goroutines',
+                                color: '#888'
+                            }
+                        },
+                        y1: {
+                            display: !!overlay,
+                            position: 'right',
+                            grid: { drawOnChartArea: false },
+                            ticks: { color: '#888' },
+                            title: {
+                                display: !!overlay,
+                                text: overlay.replace(/^rate:(.*)$/, '$1 /s'),
+                                color: '#888'
+                            }
+                        }
+                    }
+                }
+            });
+        }
+
+        // Drop the cached data and reload hosts and the chart
+        async function refresh() {
+            statsData = labelsData = journalData = runtimeData = null;
+            const resp = await fetch('/api/hosts');
+            hosts = await resp.json();
+            renderHostSelect();
+            loadChart();
+        }
+
+        // Initialize
+        async function init() {
+            const resp = await fetch('/api/hosts');
+            hosts = await resp.json();
+            renderHostSelect();
+
+            // Load the chart
+            loadChart();
+
+            // Check URL params
+            const params = new URLSearchParams(window.location.search);
+            const host = params.get('host');
+            const id = params.get('id');
+            if (host && id) {
+                document.getElementById('hostSelect').value = host;
+                document.getElementById('goroSearch').value = id;
+                showTab('viewer');
+                loadGoroutine();
+            }
+        }
+
+        async function searchGoroutines() {
+            const host = document.getElementById('hostSelect').value;
+            const id = document.getElementById('goroSearch').value;
+            const label = document.getElementById('labelFilter').value.trim();
+            if (!host) {
+                alert('Please select a host');
+                return;
+            }
+
+            showLoading(true);
+            const resp = await fetch('/api/search?host=' + encodeURIComponent(host) + '&id=' + encodeURIComponent(id) + '&label=' + encodeURIComponent(label));
+            const results = await resp.json();
+            showLoading(false);
+
+            const container = document.getElementById('searchResults');
+            container.innerHTML = '';
+            container.style.display = results.length ? 'block' : 'none';
+
+            results.forEach(r => {
+                const div = document.createElement('div');
+                div.className = 'search-result';
+                div.innerHTML = '<span>Goroutine ' + r.id + '</span><span>' + r.count + ' frames, ' + formatDuration(r.last - r.first) + '</span>';
+                div.onclick = () => {
+                    document.getElementById('goroSearch').value = r.id;
+                    loadGoroutine();
+                };
+                container.appendChild(div);
+            });
+        }
+
+        async function loadGoroutine() {
+            const host = document.getElementById('hostSelect').value;
+            const id = document.getElementById('goroSearch').value;
+            if (!host || !id) {
+                alert('Please select a host and enter a goroutine ID');
+                return;
+            }
+
+            showLoading(true);
+            const resp = await fetch('/api/goroutine?host=' + encodeURIComponent(host) + '&id=' + encodeURIComponent(id));
+            if (!resp.ok) {
+                showLoading(false);
+                alert('Goroutine not found');
+                return;
+            }
+
+            currentData = await resp.json();
+            showLoading(false);
+
+            if (!currentData.e || currentData.e.length === 0) {
+                alert('No data for this goroutine');
+                return;
+            }
+
+            // Update URL
+            history.replaceState(null, '', '?host=' + encodeURIComponent(host) + '&id=' + encodeURIComponent(id));
+
+            // Setup viewer
+            document.getElementById('searchResults').style.display = 'none';
+            document.getElementById('viewer').style.display = 'flex';
+
+            const slider = document.getElementById('timeSlider');
+            slider.max = currentData.e.length - 1;
+            slider.value = 0;
+
+            document.getElementById('startTime').textContent = formatTime(currentData.e[0].t);
+            document.getElementById('endTime').textContent = formatTime(currentData.e[currentData.e.length - 1].t);
+
+            // Stats carry the per-snapshot profile list shown in the frame header
+            await fetchStats();
+
+            currentFrame = 0;
+            previousStack = '';
+            renderFrame();
+
+            // Load children goroutines (also renders the mini chart)
+            loadChildren(host, id);
+        }
+
+        async function loadChildren(host, id) {
+            const resp = await fetch('/api/children?host=' + encodeURIComponent(host) + '&id=' + encodeURIComponent(id));
+            childrenData = await resp.json();
+
+            const container = document.getElementById('childrenContainer');
+            const countSpan = document.getElementById('childrenCount');
+            const list = document.getElementById('childrenList');
+
+            if (!childrenData || childrenData.length === 0) {
+                container.style.display = 'none';
+                renderViewerChart(); // Hide the chart
+                return;
+            }
+
+            container.style.display = 'flex';
+            countSpan.textContent = childrenData.length;
+
+            // Sort by first seen
+            childrenData.sort((a, b) => a.first - b.first);
+
+            // Render children list
+            let html = '';
+            childrenData.forEach(child => {
+                const duration = formatDuration(child.last - child.first);
+                const startTime = formatTime(child.first).substring(11); // Just time part
+                const endTime = formatTime(child.last).substring(11);
+                html += '<div class="child-item">' +
+                    '<span class="child-id" onclick="goToChild(' + child.id + ')">#' + child.id + '</span>' +
+                    '<span class="child-funcs" title="' + escapeHtml(child.funcs) + '">' + escapeHtml(child.funcs) + '</span>' +
+                    '<span class="child-time">' + startTime + ' - ' + endTime + '</span>' +
+                    '<span class="child-duration">' + duration + '</span>' +
+                    '</div>';
+            });
+            list.innerHTML = html;
+
+            // Reset visibility and search state
+            childrenVisible = false;
+            document.getElementById('childrenToggle').textContent = '▼ Show';
+            document.getElementById('childrenToolbar').style.display = 'none';
+            document.getElementById('childrenSearch').value = '';
+            list.style.display = 'none';
+
+            // Render the mini chart showing active children over time
+            renderViewerChart();
+        }
+
+        function toggleChildren() {
+            childrenVisible = !childrenVisible;
+            document.getElementById('childrenList').style.display = childrenVisible ? 'block' : 'none';
+            document.getElementById('childrenToolbar').style.display = childrenVisible ? 'block' : 'none';
+            document.getElementById('childrenToggle').textContent = childrenVisible ? '▲ Hide' : '▼ Show';
+            if (childrenVisible) {
+                document.getElementById('childrenSearch').focus();
+            }
+        }
+
+        function filterChildren() {
+            const query = document.getElementById('childrenSearch').value.toLowerCase();
+            const items = document.querySelectorAll('.child-item');
+            let visibleCount = 0;
+            
+            items.forEach(item => {
+                const funcs = item.querySelector('.child-funcs').textContent.toLowerCase();
+                const id = item.querySelector('.child-id').textContent.toLowerCase();
+                const matches = funcs.includes(query) || id.includes(query);
+                item.style.display = matches ? '' : 'none';
+                if (matches) visibleCount++;
+            });
+            
+            // Update count to show filtered/total
+            const countSpan = document.getElementById('childrenCount');
+            if (query) {
+                countSpan.textContent = visibleCount + '/' + childrenData.length;
+            } else {
+                countSpan.textContent = childrenData.length;
+            }
+        }
+
+        function goToChild(childId) {
+            const host = document.getElementById('hostSelect').value;
+            window.location.href = '?host=' + encodeURIComponent(host) + '&id=' + childId;
+        }
+
+        function renderFrame() {
+            if (!currentData || !currentData.e) return;
+
+            const entry = currentData.e[currentFrame];
+            document.getElementById('currentTime').textContent = formatTime(entry.t);
+            document.getElementById('currentState').textContent = entry.s;
+            document.getElementById('frameCounter').textContent = (currentFrame + 1) + ' / ' + currentData.e.length;
+            document.getElementById('timeSlider').value = currentFrame;
+
+            // Show parent goroutine link
+            const parentLink = document.getElementById('parentLink');
+            if (entry.c && entry.c > 0) {
+                const host = document.getElementById('hostSelect').value;
+                parentLink.innerHTML = '<a class="parent-link" href="?host=' + encodeURIComponent(host) + '&id=' + entry.c + '">← parent goroutine ' + entry.c + '</a>';
+            } else {
+                parentLink.innerHTML = '';
+            }
+
+            // Show pprof labels carried by the goroutine at this point
+            const labels = entry.l || {};
+            document.getElementById('currentLabels').innerHTML = Object.keys(labels).sort().map(k =>
+                '<span class="label-tag">' + escapeHtml(k + '=' + labels[k]) + '</span>'
+            ).join('');
+
+            // Offer extra pprof profiles scraped at the same moment
+            renderProfileLinks(entry.t);
+            renderTriggerTag(entry.t);
+
+            // Render stack with diff highlighting
+            // Reverse the stack so lowest function (root) is at top - this keeps the display stable
+            const stackView = document.getElementById('stackView');
+            const lines = entry.k.split('\n').reverse();
+            const prevLines = previousStack.split('\n').reverse();
+
+            let html = '';
+            lines.forEach((line, i) => {
+                let cls = 'stack-line';
+                if (line.startsWith('\t') || line.startsWith('/') || line.includes('.go:')) {
+                    cls += ' file';
+                } else {
+                    cls += ' func';
+                }
+                
+                if (previousStack && i < prevLines.length && line !== prevLines[i]) {
+                    cls += ' changed';
+                } else if (previousStack && i >= prevLines.length) {
+                    cls += ' new';
+                }
+
+                html += '<span class="' + cls + '">' + escapeHtml(line) + '</span>';
+            });
+
+            stackView.innerHTML = html;
+            previousStack = entry.k;
+
+            // Update chart marker
+            updateViewerChartMarker();
+        }
+
+        function renderProfileLinks(ts) {
+            const container = document.getElementById('profileLinks');
+            const host = document.getElementById('hostSelect').value;
+            const hostStats = statsData ? statsData.find(s => s.host === host) : null;
+            const names = hostStats && hostStats.profiles ? hostStats.profiles[ts] || [] : [];
+            const trace = hostStats && hostStats.traces ? hostStats.traces[ts] : null;
+            container.innerHTML = names.map(name =>
+                '<a class="profile-link" href="/api/profile?host=' + encodeURIComponent(host) + '&t=' + ts +
+                '&name=' + encodeURIComponent(name) + '" title="Download ' + name + ' profile">⤓ ' + escapeHtml(name) + '</a>'
+            ).join('') + (trace
+                ? '<a class="profile-link" href="/api/trace?host=' + encodeURIComponent(host) + '&t=' + ts +
+                  '" title="Execution trace: ' + escapeHtml(trace) + '">⤓ trace</a>'
+                : '');
+        }
+
+        // List the execution traces of the shown hosts, newest first
+        function renderTraces(shown) {
+            const traces = shown.flatMap(h => Object.keys(h.traces || {}).map(ts => ({ host: h.host, t: parseInt(ts), reason: h.traces[ts] })));
+            traces.sort((a, b) => b.t - a.t);
+            document.getElementById('tracesContainer').style.display = traces.length ? 'block' : 'none';
+            document.getElementById('tracesList').innerHTML = traces.map(tr =>
+                '<div class="search-result">' +
+                '<span>' + formatTime(tr.t) + ' ' + escapeHtml(hostName(tr.host)) + ': ' + escapeHtml(tr.reason) + '</span>' +
+                '<a class="profile-link" href="/api/trace?host=' + encodeURIComponent(tr.host) + '&t=' + tr.t + '" title="Download for go tool trace">⤓ trace</a>' +
+                '</div>'
+            ).join('');
+        }
+
+        function renderTriggerTag(ts) {
+            const host = document.getElementById('hostSelect').value;
+            const hostStats = statsData ? statsData.find(s => s.host === host) : null;
+            const trigger = hostStats && hostStats.triggers ? hostStats.triggers[ts] : null;
+            document.getElementById('triggerTag').innerHTML = trigger
+                ? '<span class="trigger-tag" title="Snapshot taken in a trigger burst">⚡ ' + escapeHtml(trigger) + '</span>'
+                : '';
+        }
+
+        function onSliderChange() {
+            currentFrame = parseInt(document.getElementById('timeSlider').value);
+            renderFrame();
+        }
+
+        function togglePlay() {
+            playing = !playing;
+            document.getElementById('playBtn').textContent = playing ? '⏸ Pause' : '▶ Play';
+
+            if (playing) {
+                const speed = parseInt(document.getElementById('speedSelect').value);
+                playInterval = setInterval(() => {
+                    if (currentFrame < currentData.e.length - 1) {
+                        currentFrame++;
+                        renderFrame();
+                    } else {
+                        togglePlay(); // Stop at end
+                    }
+                }, speed);
+            } else {
+                clearInterval(playInterval);
+            }
+        }
+
+        // Timestamps from the API are Unix milliseconds
+        function formatTime(ts) {
+            const d = new Date(ts);
+            return d.toISOString().replace('T', ' ').substring(0, 23);
+        }
+
+        function formatDuration(ms) {
+            const seconds = Math.round(ms / 1000);
+            if (seconds < 60) return seconds + 's';
+            if (seconds < 3600) return Math.floor(seconds / 60) + 'm ' + (seconds % 60) + 's';
+            return Math.floor(seconds / 3600) + 'h ' + Math.floor((seconds % 3600) / 60) + 'm';
+        }
+
+        function escapeHtml(text) {
+            const div = document.createElement('div');
+            div.textContent = text;
+            return div.innerHTML;
+        }
+
+        function showLoading(show) {
+            document.getElementById('loading').style.display = show ? 'block' : 'none';
+        }
+
+        function renderViewerChart() {
+            const chartContainer = document.getElementById('viewerChart').parentElement.parentElement;
+
+            // Runtime metrics of the goroutine's host over its lifetime
+            const overlay = document.getElementById('overlaySelect').value;
+            let overlayPoints = null;
+            if (currentData && overlay && runtimeData) {
+                const host = document.getElementById('hostSelect').value;
+                const first = currentData.e[0].t, last = currentData.e[currentData.e.length - 1].t;
+                const points = runtimePoints(runtimeData.find(r => r.host === host), overlay);
+                overlayPoints = points ? points.filter(p => p.x >= first && p.x <= last) : null;
+            }
+            const hasChildren = childrenData && childrenData.length > 0;
+
+            if (!currentData || (!hasChildren && !overlayPoints)) {
+                // Hide chart if no children and no overlay
+                chartContainer.style.display = 'none';
+                if (viewerChart) {
+                    viewerChart.destroy();
+                    viewerChart = null;
+                }
+                return;
+            }
+
+            chartContainer.style.display = 'block';
+
+            // Get all unique timestamps from the goroutine's entries
+            const timestamps = currentData.e.map(e => e.t);
+
+            // For each timestamp, count how many children were active
+            const data = !hasChildren ? [] : timestamps.map(ts => {
+                let count = 0;
+                for (const child of childrenData) {
+                    if (ts >= child.first && ts <= child.last) {
+                        count++;
+                    }
+                }
+                return { x: new Date(ts), y: count };
+            });
+
+            // Get current timestamp for the marker
+            const currentTs = currentData.e[currentFrame].t;
+
+            const ctx = document.getElementById('viewerChart').getContext('2d');
+
+            if (viewerChart) {
+                viewerChart.destroy();
+            }
+
+            const datasets = [{
+                label: 'Active Children',
+                data: data,
+                borderColor: 'rgb(78, 201, 176)',
+                backgroundColor: 'rgba(78, 201, 176, 0.1)',
+                borderWidth: 1.5,
+                pointRadius: 0,
+                tension: 0,
+                fill: true,
+                stepped: 'before'
+            }];
+            if (overlayPoints) {
+                datasets.push({
+                    label: overlay,
+                    data: overlayPoints,
+                    yAxisID: 'y1',
+                    borderColor: 'rgb(220, 220, 170)',
+                    borderDash: [4, 4],
+                    borderWidth: 1,
+                    pointRadius: 0,
+                    tension: 0.1,
+                    fill: false
+                });
+            }
+
+            viewerChart = new Chart(ctx, {
+                type: 'line',
+                data: { datasets },
+                options: {
+                    responsive: true,
+                    maintainAspectRatio: false,
+                    animation: false,
+                    plugins: {
+                        legend: { display: false },
+                        tooltip: { enabled: false },
+                        annotation: {
+                            annotations: {
+                                currentLine: {
+                                    type: 'line',
+                                    xMin: currentTs,
+                                    xMax: currentTs,
+                                    borderColor: 'rgb(255, 99, 132)',
+                                    borderWidth: 2,
+                                    borderDash: [5, 5]
+                                }
+                            }
+                        }
+                    },
+                    scales: {
+                        x: {
+                            type: 'time',
+                            time: {
+                                displayFormats: {
+                                    minute: 'HH:mm',
+                                    hour: 'HH:mm'
+                                }
+                            },
+                            grid: { color: '#333' },
+                            ticks: { color: '#888', maxTicksLimit: 8 }
+                        },
+                        y: {
+                            display: hasChildren,
+                            beginAtZero: true,
+                            grid: { color: '#333' },
+                            ticks: { 
+                                color: '#888',
+                                stepSize: 1
+                            },
+                            title: {
+                                display: true,
+                                text: 'Active Children',
+                                color: '#888',
+                                font: { size: 10 }
+                            }
+                        },
+                        y1: {
+                            display: !!overlayPoints,
+                            position: 'right',
+                            grid: { drawOnChartArea: false },
+                            ticks: { color: '#888', maxTicksLimit: 4 },
+                            title: {
+                                display: true,
+                                text: overlay.replace(/^rate:(.*)$/, '$1 /s'),
+                                color: '#888',
+                                font: { size: 10 }
+                            }
+                        }
+                    }
+                }
+            });
+        }
+
+        function updateViewerChartMarker() {
+            if (!viewerChart || !currentData) return;
+            const currentTs = currentData.e[currentFrame].t;
+            viewerChart.options.plugins.annotation.annotations.currentLine.xMin = currentTs;
+            viewerChart.options.plugins.annotation.annotations.currentLine.xMax = currentTs;
+            viewerChart.update('none');
+        }
+
+        // Keyboard controls
+        document.addEventListener('keydown', (e) => {
+            if (!currentData) return;
+            if (e.key === 'ArrowLeft' && currentFrame > 0) {
+                currentFrame--;
+                renderFrame();
+            } else if (e.key === 'ArrowRight' && currentFrame < currentData.e.length - 1) {
+                currentFrame++;
+                renderFrame();
+            } else if (e.key === ' ') {
+                e.preventDefault();
+                togglePlay();
+            }
+        });
+
+        init();
+    </script>
+</body>
+</html>`)
+}
+
+// ========== Utilities ==========
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// parseInt64 parses a numeric query parameter, 0 if it isn't one
+func parseInt64(s string) int64 {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
+}
@@ -0,0 +1,278 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gscrape/index"
+	"gscrape/snapshot"
+)
+
+// base is the time of the first test snapshot
+var base = time.Date(2026, 1, 17, 12, 0, 0, 0, time.UTC)
+
+// testSnapshot is a snapshot stored for a handler test
+type testSnapshot struct {
+	at    time.Duration // after base
+	dump  string
+	meta  snapshot.Meta
+	files map[string][]byte // other files by suffix, gzipped if it ends in .gz
+	raw   bool              // files stored as given
+}
+
+// workers returns a debug=2 dump of goroutine 1 and n workers
+func workers(n int) string {
+	var b strings.Builder
+	b.WriteString("goroutine 1 [running]:\nmain.main()\n\t/src/main.go:10 +0x1d\n")
+	for i := 0; i < n; i++ {
+		b.WriteString("\ngoroutine " + strconv.Itoa(i+2) + " [select]:\nmain.worker()\n\t/src/main.go:20 +0x2a\n")
+	}
+	return b.String()
+}
+
+// testServer stores the snapshots of each host the way gscrape does, indexes
+// them and returns a server reading the index
+func testServer(t *testing.T, hosts map[string][]testSnapshot, journals map[string][]snapshot.JournalEntry) *Server {
+	t.Helper()
+	outDir := t.TempDir()
+	for host, snaps := range hosts {
+		hostDir := filepath.Join(outDir, host)
+		if err := os.MkdirAll(hostDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, snap := range snaps {
+			w, err := snapshot.Reserve(hostDir, base.Add(snap.at))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.WriteGzipped(".goroutines.txt.gz", []byte(snap.dump)); err != nil {
+				t.Fatal(err)
+			}
+			for suffix, data := range snap.files {
+				if strings.HasSuffix(suffix, ".gz") && !snap.raw {
+					_, err = w.WriteGzipped(suffix, data)
+				} else {
+					err = w.WriteFile(suffix, data)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			meta := snap.meta
+			meta.Time = w.Time()
+			if err := w.WriteMeta(&meta); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for host, entries := range journals {
+		var lines []byte
+		for _, e := range entries {
+			line, _ := json.Marshal(&e)
+			lines = append(append(lines, line...), '\n')
+		}
+		if err := os.WriteFile(filepath.Join(outDir, host, snapshot.JournalFile), lines, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dbPath := filepath.Join(t.TempDir(), "index.db")
+	if err := index.Build(outDir, dbPath, 1, index.DefaultSeries); err != nil {
+		t.Fatal(err)
+	}
+	r, err := index.Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return NewServer(r)
+}
+
+// get requests target from the server and decodes a JSON response into v
+func get(t *testing.T, s *Server, target string, v any) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	if v != nil && w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s: %v: %s", target, err, w.Body)
+		}
+	}
+	return w
+}
+
+func TestPartialDumps(t *testing.T) {
+	const reason = "response truncated at 1.000 MB"
+	s := testServer(t, map[string][]testSnapshot{
+		"app": {
+			{at: 0, dump: workers(3)},
+			// Cut off in the middle of the last goroutine
+			{at: time.Minute, dump: workers(3) + "\ngoroutine 9 [select]:\nmain.wor", meta: snapshot.Meta{Partial: reason}},
+		},
+	}, map[string][]snapshot.JournalEntry{
+		"app": {
+			{URL: "http://app:6060/debug/pprof/goroutine", Start: base},
+			{URL: "http://app:6060/debug/pprof/goroutine", Start: base.Add(time.Minute), Partial: reason},
+		},
+	})
+
+	var stats []struct {
+		Host   string `json:"host"`
+		Counts []int  `json:"counts"`
+	}
+	get(t, s, "/api/stats", &stats)
+	if len(stats) != 1 || len(stats[0].Counts) != 2 || stats[0].Counts[0] != 4 || stats[0].Counts[1] != 4 {
+		t.Errorf("stats %+v, want counts [4 4] without the cut off goroutine", stats)
+	}
+
+	var journals []struct {
+		Host     string                 `json:"host"`
+		Attempts int                    `json:"attempts"`
+		Failures []index.JournalFailure `json:"failures"`
+	}
+	get(t, s, "/api/journal?host=app", &journals)
+	if len(journals) != 1 || journals[0].Attempts != 2 || len(journals[0].Failures) != 1 ||
+		journals[0].Failures[0].Error != "partial dump: "+reason || journals[0].Failures[0].Timestamp != base.Add(time.Minute).UnixMilli() {
+		t.Errorf("journal %+v, want the partial dump as the one failure", journals)
+	}
+
+	// Unknown hosts are left out
+	get(t, s, "/api/journal?host=other", &journals)
+	if len(journals) != 0 {
+		t.Errorf("journal of an unknown host %+v", journals)
+	}
+}
+
+func TestRuntimeHandler(t *testing.T) {
+	s := testServer(t, map[string][]testSnapshot{
+		"app": {
+			{at: 0, dump: workers(1), files: map[string][]byte{".vars.json.gz": []byte(`{"memstats": {"HeapAlloc": 1024, "NumGC": 3}}`)}},
+			{at: time.Minute, dump: workers(1)}, // metrics not fetched
+			{at: 2 * time.Minute, dump: workers(1), files: map[string][]byte{".vars.json.gz": []byte(`{"memstats": {"HeapAlloc": 2048, "NumGC": 5}}`)}},
+		},
+		"prom": {
+			{at: 0, dump: workers(1), files: map[string][]byte{".metrics.txt.gz": []byte("go_threads 8\nprocess_cpu_seconds_total{mode=\"user\"} 1.5\nprocess_cpu_seconds_total{mode=\"system\"} 0.5\n")}},
+		},
+		"bare": {{at: 0, dump: workers(1)}},
+	}, nil)
+
+	type hostRuntime struct {
+		Host       string                `json:"host"`
+		Timestamps []int64               `json:"timestamps"`
+		Values     map[string][]*float64 `json:"values"`
+	}
+	// values returns a series as numbers, -1 for null
+	values := func(vs []*float64) []float64 {
+		out := make([]float64, len(vs))
+		for i, v := range vs {
+			out[i] = -1
+			if v != nil {
+				out[i] = *v
+			}
+		}
+		return out
+	}
+
+	var all []hostRuntime
+	get(t, s, "/api/runtime", &all)
+	hosts := make(map[string]hostRuntime)
+	for _, h := range all {
+		hosts[h.Host] = h
+	}
+	if _, ok := hosts["bare"]; ok || len(hosts) != 2 {
+		t.Errorf("runtime of hosts %v, want app and prom", all)
+	}
+
+	// A snapshot without metrics adds no point
+	app := hosts["app"]
+	if want := []int64{base.UnixMilli(), base.Add(2 * time.Minute).UnixMilli()}; !reflect.DeepEqual(app.Timestamps, want) {
+		t.Errorf("app timestamps %v, want %v", app.Timestamps, want)
+	}
+	if got := values(app.Values["memstats.HeapAlloc"]); !reflect.DeepEqual(got, []float64{1024, 2048}) {
+		t.Errorf("app gauge %v", got)
+	}
+	if got := values(app.Values["memstats.NumGC"]); !reflect.DeepEqual(got, []float64{3, 5}) {
+		t.Errorf("app counter %v", got)
+	}
+	if len(app.Values) != 2 {
+		t.Errorf("app series %v, want only the selected ones found", app.Values)
+	}
+
+	prom := hosts["prom"]
+	if got := values(prom.Values["process_cpu_seconds_total"]); !reflect.DeepEqual(got, []float64{2}) {
+		t.Errorf("prom counter %v, want summed over its labels", got)
+	}
+	if got := values(prom.Values["go_threads"]); !reflect.DeepEqual(got, []float64{8}) {
+		t.Errorf("prom gauge %v", got)
+	}
+
+	var filtered []hostRuntime
+	get(t, s, "/api/runtime?host=bare", &filtered)
+	if len(filtered) != 0 {
+		t.Errorf("runtime of a host without metrics %v", filtered)
+	}
+	get(t, s, "/api/runtime?host=prom", &filtered)
+	if len(filtered) != 1 || filtered[0].Host != "prom" {
+		t.Errorf("runtime filtered to prom %v", filtered)
+	}
+}
+
+func TestTraceHandler(t *testing.T) {
+	trace := []byte("go 1.22 trace\x00\x00\x00")
+	s := testServer(t, map[string][]testSnapshot{
+		"app": {
+			{at: 0, dump: workers(1)},
+			{at: time.Minute, dump: workers(5), meta: snapshot.Meta{Trace: "count 6 above 5"}, files: map[string][]byte{".trace.gz": trace}},
+			{at: 2 * time.Minute, dump: workers(5), files: map[string][]byte{".trace.gz": []byte("not gzip")}, raw: true},
+		},
+	}, nil)
+	traced := strconv.FormatInt(base.Add(time.Minute).UnixMilli(), 10)
+
+	// Listed with the trigger on the overview
+	var stats []struct {
+		Traces map[string]string `json:"traces"`
+	}
+	get(t, s, "/api/stats", &stats)
+	if len(stats) != 1 || stats[0].Traces[traced] != "count 6 above 5" {
+		t.Errorf("traces in stats %+v", stats)
+	}
+
+	w := get(t, s, "/api/trace?host=app&t="+traced, nil)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), trace) {
+		t.Errorf("status %d, body %q, want the trace", w.Code, w.Body)
+	}
+	if got, want := w.Header().Get("Content-Disposition"), `attachment; filename="app-`+traced+`.trace"`; got != want {
+		t.Errorf("Content-Disposition %q, want %q", got, want)
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{"no host", "/api/trace?t=" + traced, http.StatusBadRequest},
+		{"no time", "/api/trace?host=app", http.StatusBadRequest},
+		{"snapshot without trace", "/api/trace?host=app&t=" + strconv.FormatInt(base.UnixMilli(), 10), http.StatusNotFound},
+		{"unknown host", "/api/trace?host=other&t=" + traced, http.StatusNotFound},
+		{"corrupt", "/api/trace?host=app&t=" + strconv.FormatInt(base.Add(2*time.Minute).UnixMilli(), 10), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := get(t, s, tt.target, nil); w.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}